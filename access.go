@@ -0,0 +1,373 @@
+package processing
+
+import (
+	"net/http"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/filters"
+)
+
+var collectionTypes = vocab.ActivityVocabularyTypes{
+	vocab.CollectionType,
+	vocab.OrderedCollectionType,
+	vocab.CollectionPageType,
+	vocab.OrderedCollectionPageType,
+}
+
+// alwaysVisibleTypes are the types of the objects that don't have an audience of their own,
+// and which are visible to anyone that isn't blocked by their owner.
+var alwaysVisibleTypes = append(vocab.ActivityVocabularyTypes{vocab.TombstoneType}, vocab.ActorTypes...)
+
+func isValidCollectionPath(col vocab.CollectionPath) bool {
+	return vocab.ValidObjectCollection(col) || vocab.ValidActivityCollection(col)
+}
+
+// objectRecipients returns all the recipients of the "ob" object, including the blind ones.
+func objectRecipients(ob *vocab.Object) vocab.ItemCollection {
+	recipients := make(vocab.ItemCollection, 0)
+	for _, rec := range []vocab.ItemCollection{ob.To, ob.Bto, ob.CC, ob.BCC} {
+		_ = recipients.Append(rec...)
+	}
+	_ = vocab.OnItem(ob.Audience, func(rec vocab.Item) error {
+		return recipients.Append(rec)
+	})
+	return recipients
+}
+
+// itemOwners returns the IRIs of the actors that own "it": the actor itself, the actor of an activity,
+// the authors of an object, or the actor of a local collection.
+func itemOwners(it vocab.Item) vocab.IRIs {
+	owners := make(vocab.IRIs, 0)
+	if vocab.IsNil(it) {
+		return owners
+	}
+	typ := it.GetType()
+	switch {
+	case vocab.ActorTypes.Match(typ):
+		_ = owners.Append(it.GetLink())
+	case collectionTypes.Match(typ):
+		if maybeActor, col := vocab.Split(it.GetLink()); isValidCollectionPath(col) || isHiddenCollection(it.GetLink()) {
+			_ = owners.Append(maybeActor)
+		}
+	case validActivityTypes.Match(typ):
+		_ = vocab.OnIntransitiveActivity(it, func(act *vocab.IntransitiveActivity) error {
+			return vocab.OnItem(act.Actor, func(actor vocab.Item) error {
+				return owners.Append(actor.GetLink())
+			})
+		})
+	}
+	_ = vocab.OnObject(it, func(ob *vocab.Object) error {
+		return vocab.OnItem(ob.AttributedTo, func(author vocab.Item) error {
+			if !owners.Contains(author.GetLink()) {
+				_ = owners.Append(author.GetLink())
+			}
+			return nil
+		})
+	})
+	return owners
+}
+
+// isCollectionMember checks if "requester" is an item of the local "col" collection.
+// The collection is loaded filtered by the requester's IRI, so the storage doesn't need to return all its items.
+func (p *P) isCollectionMember(col vocab.IRI, requester vocab.IRI) bool {
+	if !p.IsLocalIRI(col) {
+		return false
+	}
+	if _, path := vocab.Split(col); !isValidCollectionPath(path) {
+		return false
+	}
+	it, err := p.s.Load(col, filters.SameID(requester))
+	if err != nil || vocab.IsNil(it) {
+		return false
+	}
+	found := false
+	_ = vocab.OnCollectionIntf(it, func(c vocab.CollectionInterface) error {
+		found = c.Contains(requester)
+		return nil
+	})
+	return found
+}
+
+// blockedChecks caches, by the IRIs of the local owners of the items, the functions that check if they have
+// blocked an actor, so each owner's blocked collection is loaded only once while serving a request.
+type blockedChecks map[vocab.IRI]func(vocab.Item) bool
+
+// hasBlocked checks if the local "owner" has blocked the "requester" actor.
+func (b blockedChecks) hasBlocked(p *P, owner vocab.IRI, requester vocab.Item) bool {
+	ownerHasBlocked, ok := b[owner]
+	if !ok {
+		ownerHasBlocked = p.actorHasBlockedFn(owner)
+		b[owner] = ownerHasBlocked
+	}
+	return ownerHasBlocked(requester)
+}
+
+// IsBlockedBy checks if any of the owners of "it" has blocked the "requester" actor.
+func (p *P) IsBlockedBy(it vocab.Item, requester vocab.Item) bool {
+	return p.isBlockedBy(it, requester, make(blockedChecks))
+}
+
+// isBlockedBy checks if any of the owners of "it" has blocked the "requester" actor, using the "blocked" cache.
+func (p *P) isBlockedBy(it vocab.Item, requester vocab.Item, blocked blockedChecks) bool {
+	if vocab.IsNil(requester) {
+		return false
+	}
+	for _, owner := range itemOwners(it) {
+		if !p.IsLocalIRI(owner) {
+			continue
+		}
+		if blocked.hasBlocked(p, owner, requester) {
+			return true
+		}
+	}
+	return false
+}
+
+// CanRead checks if the "requester" actor is allowed to see "it", based on its audience.
+// A nil requester represents an anonymous request.
+//
+// Items addressed to the Public namespace are visible to everyone, and the others are visible only to their
+// owners and to the actors they are addressed to, directly or through a local collection they are members of,
+// like the followers collection of the author.
+// Actors and tombstones don't have an audience, and are visible to everyone. Collections are visible to
+// everyone when they don't have an audience of their own, except for the hidden ones, like the blocked,
+// the ignored or the history collections, which are visible only to their owners.
+// The content from domains silenced by the processor's FederationPolicy, or of actors silenced by its
+// ActorStatusPolicy, is not considered public, and it's visible only to the actors it is addressed to,
+// and to the local actors that follow its authors.
+// The content of suspended actors, including the actors themselves, is visible only to them.
+func (p *P) CanRead(it vocab.Item, requester vocab.Item) bool {
+	if vocab.IsNil(it) {
+		return false
+	}
+	if vocab.IsIRI(it) {
+		return true
+	}
+
+	owners := itemOwners(it)
+	for _, owner := range owners {
+		if p.IsSuspended(owner) {
+			return !vocab.IsNil(requester) && owners.Contains(requester.GetLink())
+		}
+	}
+	if alwaysVisibleTypes.Match(it.GetType()) {
+		return true
+	}
+
+	isCollection := collectionTypes.Match(it.GetType())
+	if isCollection && isHiddenCollection(it.GetLink()) {
+		return !vocab.IsNil(requester) && owners.Contains(requester.GetLink())
+	}
+
+	var recipients vocab.ItemCollection
+	_ = vocab.OnObject(it, func(ob *vocab.Object) error {
+		recipients = objectRecipients(ob)
+		return nil
+	})
+	if isCollection && len(recipients) == 0 {
+		return true
+	}
+	silenced := false
+	for _, owner := range owners {
+		if silenced = p.IsSilencedDomain(owner) || p.IsSilenced(owner); silenced {
+			break
+		}
+	}
+	if !silenced && recipients.Contains(vocab.PublicNS) {
+		return true
+	}
+	if vocab.IsNil(requester) {
+		return false
+	}
+
+	requesterIRI := requester.GetLink()
+	if owners.Contains(requesterIRI) {
+		return true
+	}
+	if silenced {
+		// NOTE(marius): the public content of silenced domains is visible only to the local actors that follow its authors
+		for _, owner := range owners {
+			if p.isCollectionMember(vocab.Following.IRI(requesterIRI), owner) {
+				return true
+			}
+		}
+	}
+	for _, rec := range recipients {
+		if rec.GetLink().Equal(requesterIRI) || p.isCollectionMember(rec.GetLink(), requesterIRI) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactItem returns a copy of "it" without the blind recipients, unless "requester" is one of its owners.
+func redactItem(it vocab.Item, requester vocab.Item) vocab.Item {
+	if !vocab.IsNil(requester) && itemOwners(it).Contains(requester.GetLink()) {
+		return it
+	}
+	hasBlindRecipients := false
+	_ = vocab.OnObject(it, func(ob *vocab.Object) error {
+		hasBlindRecipients = len(ob.Bto) > 0 || len(ob.BCC) > 0
+		return nil
+	})
+	if !hasBlindRecipients {
+		return it
+	}
+
+	// NOTE(marius): we don't want to modify the item we received, as it might be shared with the storage layer
+	raw, err := vocab.MarshalJSON(it)
+	if err != nil {
+		return it
+	}
+	cp, err := vocab.UnmarshalJSON(raw)
+	if err != nil {
+		return it
+	}
+	_ = vocab.OnObject(cp, func(ob *vocab.Object) error {
+		ob.Bto = nil
+		ob.BCC = nil
+		return nil
+	})
+	return cp
+}
+
+// readableItems returns the items from "items" which the "requester" is allowed to see, and the number
+// of items that have been removed.
+// The items which are only IRIs are checked after loading them from storage, and the ones that can't
+// be loaded are removed.
+func (p *P) readableItems(items vocab.ItemCollection, requester vocab.Item, blocked blockedChecks) (vocab.ItemCollection, uint) {
+	readable := make(vocab.ItemCollection, 0, len(items))
+	for _, it := range items {
+		full := it
+		if vocab.IsIRI(it) {
+			if full = p.loadLocalCopy(it); vocab.IsIRI(full) {
+				continue
+			}
+		}
+		if !p.CanRead(full, requester) || p.isBlockedBy(full, requester, blocked) {
+			continue
+		}
+		if vocab.IsIRI(it) {
+			readable = append(readable, it)
+			continue
+		}
+		readable = append(readable, redactItem(it, requester))
+	}
+	return readable, uint(len(items) - len(readable))
+}
+
+// filterCollection returns a copy of the "col" collection, which contains only the items "requester"
+// is allowed to see.
+func (p *P) filterCollection(col vocab.Item, requester vocab.Item, blocked blockedChecks) vocab.Item {
+	var removed uint
+	filtered := col
+	switch typ := col.GetType(); {
+	case vocab.OrderedCollectionPageType.Match(typ):
+		_ = vocab.OnOrderedCollectionPage(col, func(c *vocab.OrderedCollectionPage) error {
+			cp := *c
+			cp.OrderedItems, removed = p.readableItems(c.OrderedItems, requester, blocked)
+			cp.TotalItems -= min(removed, cp.TotalItems)
+			filtered = &cp
+			return nil
+		})
+	case vocab.OrderedCollectionType.Match(typ):
+		_ = vocab.OnOrderedCollection(col, func(c *vocab.OrderedCollection) error {
+			cp := *c
+			cp.OrderedItems, removed = p.readableItems(c.OrderedItems, requester, blocked)
+			cp.TotalItems -= min(removed, cp.TotalItems)
+			filtered = &cp
+			return nil
+		})
+	case vocab.CollectionPageType.Match(typ):
+		_ = vocab.OnCollectionPage(col, func(c *vocab.CollectionPage) error {
+			cp := *c
+			cp.Items, removed = p.readableItems(c.Items, requester, blocked)
+			cp.TotalItems -= min(removed, cp.TotalItems)
+			filtered = &cp
+			return nil
+		})
+	case vocab.CollectionType.Match(typ):
+		_ = vocab.OnCollection(col, func(c *vocab.Collection) error {
+			cp := *c
+			cp.Items, removed = p.readableItems(c.Items, requester, blocked)
+			cp.TotalItems -= min(removed, cp.TotalItems)
+			filtered = &cp
+			return nil
+		})
+	case vocab.IsItemCollection(col):
+		_ = vocab.OnItemCollection(col, func(c *vocab.ItemCollection) error {
+			filtered, _ = p.readableItems(*c, requester, blocked)
+			return nil
+		})
+	}
+	return filtered
+}
+
+// requesterFromRequest returns the actor that made the "r" request, as resolved by the HTTPSignatureVerifierMw
+// middleware, or set with ContextWithAuthor by the token authorization of the application.
+// If the processor has been created with the RequireSignedFetches option, it returns an error for anonymous
+// requests, with the exception of the ones for the instance actor, which needs to be readable for verifying
+// the signatures of the requests made by the instance.
+func (p *P) requesterFromRequest(r *http.Request) (vocab.Item, error) {
+	if author, ok := AuthorFromRequest(r); ok && !vocab.PublicNS.Equal(author.ID) {
+		return &author, nil
+	}
+	if !p.requireSignedFetch {
+		return nil, nil
+	}
+	if len(p.instanceActor) > 0 && reqIRI(r).Equals(p.instanceActor, false) {
+		return nil, nil
+	}
+	return nil, errors.Unauthorizedf("the request needs to be signed")
+}
+
+// AuthorizedItemHandler wraps the "fn" ItemHandlerFn with access control based on the actor which made the request.
+//
+// The returned items which the actor is not allowed to see, or whose owners have blocked the actor, result in
+// a not found error. For collections, only the items which the actor is allowed to see are kept.
+// The blind recipients are removed from the items, unless the actor owns them.
+func (p *P) AuthorizedItemHandler(fn ItemHandlerFn) ItemHandlerFn {
+	return func(r *http.Request) (vocab.Item, error) {
+		requester, err := p.requesterFromRequest(r)
+		if err != nil {
+			return nil, err
+		}
+		it, err := fn(r)
+		if vocab.IsNil(it) || (err != nil && !errors.IsNotModified(err)) {
+			return it, err
+		}
+		blocked := make(blockedChecks)
+		if !p.CanRead(it, requester) || p.isBlockedBy(it, requester, blocked) {
+			return nil, errors.NotFoundf("")
+		}
+		if collectionTypes.Match(it.GetType()) {
+			return p.filterCollection(it, requester, blocked), err
+		}
+		return redactItem(it, requester), err
+	}
+}
+
+// AuthorizedCollectionHandler wraps the "fn" CollectionHandlerFn with access control based on the actor which
+// made the request, similarly to AuthorizedItemHandler.
+func (p *P) AuthorizedCollectionHandler(fn CollectionHandlerFn) CollectionHandlerFn {
+	return func(typ vocab.CollectionPath, r *http.Request) (vocab.CollectionInterface, error) {
+		requester, err := p.requesterFromRequest(r)
+		if err != nil {
+			return nil, err
+		}
+		col, err := fn(typ, r)
+		if vocab.IsNil(col) || (err != nil && !errors.IsNotModified(err)) {
+			return col, err
+		}
+		blocked := make(blockedChecks)
+		if !p.CanRead(col, requester) || p.isBlockedBy(col, requester, blocked) {
+			return nil, errors.NotFoundf("")
+		}
+		filtered, ok := p.filterCollection(col, requester, blocked).(vocab.CollectionInterface)
+		if !ok {
+			return col, err
+		}
+		return filtered, err
+	}
+}
@@ -0,0 +1,198 @@
+package processing
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+func TestP_CanRead(t *testing.T) {
+	p := mockProcessor(t, defaultActorID)
+
+	follower := vocab.IRI("https://remote.example.com/~follower")
+	stranger := vocab.IRI("https://remote.example.com/~stranger")
+	if err := p.s.AddTo(vocab.Followers.IRI(defaultActor), follower); err != nil {
+		t.Fatalf("unable to add follower: %s", err)
+	}
+
+	public := &vocab.Object{
+		ID:           defaultActorID + "/objects/1",
+		Type:         vocab.NoteType,
+		AttributedTo: defaultActorID,
+		To:           vocab.ItemCollection{vocab.PublicNS},
+	}
+	followersOnly := &vocab.Object{
+		ID:           defaultActorID + "/objects/2",
+		Type:         vocab.NoteType,
+		AttributedTo: defaultActorID,
+		To:           vocab.ItemCollection{vocab.Followers.IRI(defaultActor)},
+	}
+	direct := &vocab.Object{
+		ID:           defaultActorID + "/objects/3",
+		Type:         vocab.NoteType,
+		AttributedTo: defaultActorID,
+		BCC:          vocab.ItemCollection{stranger},
+	}
+	outbox := emptyCol(vocab.Outbox.IRI(defaultActor))
+	followersCol := emptyCol(vocab.Followers.IRI(defaultActor))
+	followersCol.To = vocab.ItemCollection{vocab.Followers.IRI(defaultActor)}
+	blocked := emptyCol(BlockedCollection.IRI(defaultActor))
+	history := emptyCol(HistoryCollection.IRI(public))
+
+	tests := []struct {
+		name      string
+		it        vocab.Item
+		requester vocab.Item
+		want      bool
+	}{
+		{name: "public for anonymous", it: public, want: true},
+		{name: "actor for anonymous", it: defaultActor, want: true},
+		{name: "followers only for anonymous", it: followersOnly, want: false},
+		{name: "followers only for stranger", it: followersOnly, requester: stranger, want: false},
+		{name: "followers only for follower", it: followersOnly, requester: follower, want: true},
+		{name: "followers only for author", it: followersOnly, requester: defaultActorID, want: true},
+		{name: "direct for recipient", it: direct, requester: stranger, want: true},
+		{name: "direct for follower", it: direct, requester: follower, want: false},
+		{name: "collection without audience for anonymous", it: outbox, want: true},
+		{name: "collection with audience for stranger", it: followersCol, requester: stranger, want: false},
+		{name: "collection with audience for follower", it: followersCol, requester: follower, want: true},
+		{name: "blocked collection for anonymous", it: blocked, want: false},
+		{name: "blocked collection for stranger", it: blocked, requester: stranger, want: false},
+		{name: "blocked collection for owner", it: blocked, requester: defaultActorID, want: true},
+		{name: "history collection for follower", it: history, requester: follower, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := p.CanRead(tt.it, tt.requester); got != tt.want {
+				t.Errorf("CanRead() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestP_AuthorizedItemHandler(t *testing.T) {
+	p := mockProcessor(t, defaultActorID)
+
+	blocked := vocab.Actor{ID: "https://remote.example.com/~blocked", Type: vocab.PersonType}
+	recipient := vocab.Actor{ID: "https://remote.example.com/~recipient", Type: vocab.PersonType}
+	if _, err := p.s.Create(emptyCol(BlockedCollection.IRI(defaultActor))); err != nil {
+		t.Fatalf("unable to create blocked collection: %s", err)
+	}
+	if err := p.s.AddTo(BlockedCollection.IRI(defaultActor), blocked.ID); err != nil {
+		t.Fatalf("unable to block actor: %s", err)
+	}
+
+	note := &vocab.Object{
+		ID:           defaultActorID + "/objects/1",
+		Type:         vocab.NoteType,
+		AttributedTo: defaultActorID,
+		To:           vocab.ItemCollection{vocab.PublicNS},
+		BCC:          vocab.ItemCollection{recipient.ID},
+	}
+	handler := p.AuthorizedItemHandler(func(r *http.Request) (vocab.Item, error) {
+		return note, nil
+	})
+
+	request := func(author *vocab.Actor) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, note.ID.String(), nil)
+		if author != nil {
+			r = r.WithContext(ContextWithAuthor(r.Context(), *author))
+		}
+		return r
+	}
+
+	t.Run("anonymous", func(t *testing.T) {
+		it, err := handler(request(nil))
+		if err != nil {
+			t.Fatalf("handler error = %s", err)
+		}
+		_ = vocab.OnObject(it, func(ob *vocab.Object) error {
+			if len(ob.BCC) > 0 {
+				t.Errorf("handler returned the blind recipients %v", ob.BCC)
+			}
+			return nil
+		})
+		if len(note.BCC) == 0 {
+			t.Errorf("handler modified the original item")
+		}
+	})
+	t.Run("blocked", func(t *testing.T) {
+		if _, err := handler(request(&blocked)); !errors.IsNotFound(err) {
+			t.Errorf("handler error = %v, expected not found", err)
+		}
+	})
+	t.Run("signed fetch required", func(t *testing.T) {
+		p.requireSignedFetch = true
+		defer func() { p.requireSignedFetch = false }()
+
+		if _, err := handler(request(nil)); !errors.IsUnauthorized(err) {
+			t.Errorf("handler error = %v, expected unauthorized", err)
+		}
+		if _, err := handler(request(&recipient)); err != nil {
+			t.Errorf("handler error = %s", err)
+		}
+	})
+}
+
+func TestP_filterCollection(t *testing.T) {
+	p := mockProcessor(t, defaultActorID)
+	store := newLoadCountingStore(p.s)
+	p.s = store
+
+	requester := vocab.Actor{ID: "https://remote.example.com/~jdoe", Type: vocab.PersonType}
+	blocking := vocab.IRI(defaultActorID + "/~blocking")
+	if _, err := p.s.Create(emptyCol(BlockedCollection.IRI(blocking))); err != nil {
+		t.Fatalf("unable to create blocked collection: %s", err)
+	}
+	if err := p.s.AddTo(BlockedCollection.IRI(blocking), requester.ID); err != nil {
+		t.Fatalf("unable to block actor: %s", err)
+	}
+
+	note := func(id string, author vocab.IRI, to vocab.Item) *vocab.Object {
+		return &vocab.Object{ID: vocab.IRI(defaultActorID + "/objects/" + id), Type: vocab.NoteType, AttributedTo: author, To: vocab.ItemCollection{to}}
+	}
+	public := note("public", defaultActorID, vocab.PublicNS)
+	publicIRI := note("public-iri", defaultActorID, vocab.PublicNS)
+	private := note("private", defaultActorID, vocab.Followers.IRI(defaultActor))
+	privateIRI := note("private-iri", defaultActorID, vocab.Followers.IRI(defaultActor))
+	for _, ob := range []*vocab.Object{public, publicIRI, private, privateIRI} {
+		if _, err := p.s.Save(ob); err != nil {
+			t.Fatalf("unable to save object: %s", err)
+		}
+	}
+
+	items := vocab.ItemCollection{public, publicIRI.ID, private, privateIRI.ID, vocab.IRI(defaultActorID + "/objects/missing")}
+	for i := range 3 {
+		items = append(items, note(fmt.Sprintf("blocking-%d", i), blocking, vocab.PublicNS))
+	}
+	col := &vocab.OrderedCollection{
+		ID:           defaultActorID + "/outbox",
+		Type:         vocab.OrderedCollectionType,
+		OrderedItems: items,
+		TotalItems:   uint(len(items)),
+	}
+
+	filtered := p.filterCollection(col, &requester, make(blockedChecks))
+	_ = vocab.OnOrderedCollection(filtered, func(c *vocab.OrderedCollection) error {
+		want := vocab.IRIs{public.ID, publicIRI.ID}
+		if len(c.OrderedItems) != len(want) {
+			t.Fatalf("filterCollection() returned %d items, want %d: %v", len(c.OrderedItems), len(want), c.OrderedItems.IRIs())
+		}
+		for _, it := range c.OrderedItems {
+			if !want.Contains(it.GetLink()) {
+				t.Errorf("filterCollection() returned %s, which the requester can't see", it.GetLink())
+			}
+		}
+		if c.TotalItems != uint(len(want)) {
+			t.Errorf("filterCollection() total items = %d, want %d", c.TotalItems, len(want))
+		}
+		return nil
+	})
+	if loads := store.count(BlockedCollection.IRI(blocking)); loads != 1 {
+		t.Errorf("filterCollection() loaded the blocked collection %d times, want 1", loads)
+	}
+}
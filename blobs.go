@@ -0,0 +1,162 @@
+package processing
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+// FSBlobStore is a BlobStore that keeps the binary content in files under a root directory.
+// Each blob is saved in its own file with a random name, even when the same content is saved multiple times,
+// so deleting the blob of one object doesn't affect the others. They are served from IRIs under the base IRI.
+type FSBlobStore struct {
+	root string
+	base vocab.IRI
+}
+
+var _ BlobStore = FSBlobStore{}
+
+// NewFSBlobStore returns a BlobStore that saves the blobs in the "root" directory, which are accessible
+// at IRIs under "base".
+func NewFSBlobStore(root string, base vocab.IRI) FSBlobStore {
+	return FSBlobStore{root: root, base: base}
+}
+
+const mediaTypeFileExt = ".type"
+
+func (f FSBlobStore) path(key string) string {
+	return filepath.Join(f.root, key[:2], key)
+}
+
+func (f FSBlobStore) keyFromIRI(iri vocab.IRI) (string, error) {
+	if !iri.Contains(f.base, false) {
+		return "", errors.NotFoundf("%s is not a blob IRI", iri)
+	}
+	key := filepath.Base(string(iri))
+	if _, err := hex.DecodeString(key); err != nil || len(key) != blobKeySize*2 {
+		return "", errors.NotFoundf("%s is not a blob IRI", iri)
+	}
+	return key, nil
+}
+
+// blobKeySize is the size in bytes of the random keys of the blobs.
+const blobKeySize = 32
+
+// Put saves "data" to a file with a random name, and the media type to a sidecar file next to it.
+func (f FSBlobStore) Put(mediaType vocab.MimeType, data []byte) (vocab.IRI, int64, error) {
+	raw := make([]byte, blobKeySize)
+	if _, err := rand.Read(raw); err != nil {
+		return "", 0, errors.Annotatef(err, "unable to generate blob key")
+	}
+	key := hex.EncodeToString(raw)
+
+	path := f.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", 0, errors.Annotatef(err, "unable to create blob directory")
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return "", 0, errors.Annotatef(err, "unable to save blob %s", key)
+	}
+	if err := os.WriteFile(path+mediaTypeFileExt, []byte(mediaType), 0o644); err != nil {
+		return "", 0, errors.Annotatef(err, "unable to save media type for blob %s", key)
+	}
+	return f.base.AddPath(key), int64(len(data)), nil
+}
+
+// Get loads the blob corresponding to "iri" from its file.
+func (f FSBlobStore) Get(iri vocab.IRI) ([]byte, vocab.MimeType, error) {
+	key, err := f.keyFromIRI(iri)
+	if err != nil {
+		return nil, "", err
+	}
+	path := f.path(key)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, "", errors.NotFoundf("blob %s not found", iri)
+		}
+		return nil, "", errors.Annotatef(err, "unable to load blob %s", iri)
+	}
+	mediaType, _ := os.ReadFile(path + mediaTypeFileExt)
+	return data, vocab.MimeType(mediaType), nil
+}
+
+// Size returns the size of the file of the blob corresponding to "iri".
+func (f FSBlobStore) Size(iri vocab.IRI) (int64, error) {
+	key, err := f.keyFromIRI(iri)
+	if err != nil {
+		return 0, err
+	}
+	fi, err := os.Stat(f.path(key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, errors.NotFoundf("blob %s not found", iri)
+		}
+		return 0, errors.Annotatef(err, "unable to load blob %s", iri)
+	}
+	return fi.Size(), nil
+}
+
+// Delete removes the file of the blob corresponding to "iri".
+func (f FSBlobStore) Delete(iri vocab.IRI) error {
+	key, err := f.keyFromIRI(iri)
+	if err != nil {
+		return err
+	}
+	path := f.path(key)
+	if err = os.Remove(path); err != nil {
+		if os.IsNotExist(err) {
+			return errors.NotFoundf("blob %s not found", iri)
+		}
+		return errors.Annotatef(err, "unable to delete blob %s", iri)
+	}
+	_ = os.Remove(path + mediaTypeFileExt)
+	return nil
+}
+
+// decodeDataURI decodes the content of a data URI as specified by RFC2397:
+//
+//	data:[<mediatype>][;base64],<data>
+func decodeDataURI(raw []byte) (vocab.MimeType, []byte, error) {
+	if !bytes.HasPrefix(raw, []byte("data:")) {
+		return "", nil, errors.Newf("not a data URI")
+	}
+	header, data, ok := bytes.Cut(raw[len("data:"):], []byte(","))
+	if !ok {
+		return "", nil, errors.Newf("invalid data URI, missing data separator")
+	}
+
+	isBase64 := false
+	params := strings.Split(string(header), ";")
+	if len(params) > 1 && params[len(params)-1] == "base64" {
+		isBase64 = true
+		params = params[:len(params)-1]
+	}
+	mediaType := vocab.MimeType(strings.Join(params, ";"))
+	if mediaType == "" {
+		// NOTE(marius): the default media type for data URIs
+		mediaType = "text/plain;charset=US-ASCII"
+	}
+
+	if isBase64 {
+		decoded := make([]byte, base64.StdEncoding.DecodedLen(len(data)))
+		n, err := base64.StdEncoding.Decode(decoded, bytes.TrimRight(data, "\n"))
+		if err != nil {
+			return mediaType, nil, errors.Annotatef(err, "invalid base64 data URI")
+		}
+		return mediaType, decoded[:n], nil
+	}
+	decoded, err := url.PathUnescape(string(data))
+	if err != nil {
+		return mediaType, nil, errors.Annotatef(err, "invalid data URI")
+	}
+	return mediaType, []byte(decoded), nil
+}
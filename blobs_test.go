@@ -0,0 +1,208 @@
+package processing
+
+import (
+	"bytes"
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_decodeDataURI(t *testing.T) {
+	tests := []struct {
+		name          string
+		raw           []byte
+		wantMediaType vocab.MimeType
+		wantData      []byte
+		wantErr       bool
+	}{
+		{
+			name:    "empty",
+			raw:     nil,
+			wantErr: true,
+		},
+		{
+			name:    "missing separator",
+			raw:     []byte("data:image/png;base64"),
+			wantErr: true,
+		},
+		{
+			name:          "plain text",
+			raw:           []byte("data:,A%20brief%20note"),
+			wantMediaType: "text/plain;charset=US-ASCII",
+			wantData:      []byte("A brief note"),
+		},
+		{
+			name:          "base64 png",
+			raw:           []byte("data:image/png;base64,iVBORw0KGgo="),
+			wantMediaType: "image/png",
+			wantData:      []byte("\x89PNG\r\n\x1a\n"),
+		},
+		{
+			name:    "invalid base64",
+			raw:     []byte("data:image/png;base64,!!!"),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mediaType, data, err := decodeDataURI(tt.raw)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeDataURI() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if mediaType != tt.wantMediaType {
+				t.Errorf("decodeDataURI() media type = %s, want %s", mediaType, tt.wantMediaType)
+			}
+			if !cmp.Equal(data, tt.wantData) {
+				t.Errorf("decodeDataURI() data = %s", cmp.Diff(tt.wantData, data))
+			}
+		})
+	}
+}
+
+func TestFSBlobStore(t *testing.T) {
+	base := vocab.IRI("https://example.com/blobs")
+	b := NewFSBlobStore(t.TempDir(), base)
+
+	data := []byte("\x89PNG\r\n\x1a\n")
+	iri, size, err := b.Put("image/png", data)
+	if err != nil {
+		t.Fatalf("Put() error = %s", err)
+	}
+	if !iri.Contains(base, false) {
+		t.Errorf("Put() IRI %s is not under %s", iri, base)
+	}
+	if size != int64(len(data)) {
+		t.Errorf("Put() size = %d, want %d", size, len(data))
+	}
+
+	got, mediaType, err := b.Get(iri)
+	if err != nil {
+		t.Fatalf("Get() error = %s", err)
+	}
+	if !bytes.Equal(got, data) || mediaType != "image/png" {
+		t.Errorf("Get() = %q, %s, want %q, %s", got, mediaType, data, "image/png")
+	}
+
+	// NOTE(marius): the same content saved again gets its own blob, which isn't removed together with the first one
+	other, _, err := b.Put("image/png", data)
+	if err != nil {
+		t.Fatalf("Put() error = %s", err)
+	}
+	if other.Equal(iri) {
+		t.Errorf("Put() returned the same IRI %s for a second blob", other)
+	}
+
+	if err = b.Delete(iri); err != nil {
+		t.Fatalf("Delete() error = %s", err)
+	}
+	if _, _, err = b.Get(iri); err == nil {
+		t.Errorf("Get() after Delete() expected error, got nil")
+	}
+	if size, err := b.Size(other); err != nil || size != int64(len(data)) {
+		t.Errorf("Size() = %d, %v for the second blob, want %d", size, err, len(data))
+	}
+}
+
+func TestP_processMediaContent(t *testing.T) {
+	p := &P{blobs: NewFSBlobStore(t.TempDir(), "https://example.com/blobs")}
+	ob := &vocab.Object{
+		ID:      "https://example.com/objects/1",
+		Type:    vocab.ImageType,
+		Content: vocab.DefaultNaturalLanguage("data:image/png;base64,iVBORw0KGgo="),
+	}
+	if err := p.processMediaContent(ob); err != nil {
+		t.Fatalf("processMediaContent() error = %s", err)
+	}
+	if len(ob.Content) > 0 {
+		t.Errorf("processMediaContent() content was not removed: %v", ob.Content)
+	}
+	if ob.MediaType != "image/png" {
+		t.Errorf("processMediaContent() media type = %s, want image/png", ob.MediaType)
+	}
+	u, ok := ob.URL.(*vocab.Link)
+	if !ok || !u.Href.Contains("https://example.com/blobs", false) {
+		t.Errorf("processMediaContent() URL = %v, expected a Link to a blob IRI", ob.URL)
+	}
+	if size := p.mediaSize(mediaBlobIRIs(ob)...); size != 8 {
+		t.Errorf("processMediaContent() media size = %d, want 8", size)
+	}
+}
+
+func TestP_processMediaContent_unchanged(t *testing.T) {
+	content := vocab.DefaultNaturalLanguage("data:image/png;base64,iVBORw0KGgo=")
+	tests := []struct {
+		name    string
+		p       *P
+		ob      *vocab.Object
+		wantErr bool
+	}{
+		{
+			name:    "without blob store",
+			p:       &P{},
+			ob:      &vocab.Object{ID: "https://example.com/objects/1", Type: vocab.ImageType, Content: content},
+			wantErr: true,
+		},
+		{
+			name: "not a media object",
+			p:    &P{blobs: NewFSBlobStore(t.TempDir(), "https://example.com/blobs")},
+			ob:   &vocab.Object{ID: "https://example.com/objects/1", Type: vocab.NoteType, Content: content},
+		},
+		{
+			name:    "invalid media without blob store",
+			p:       &P{},
+			ob:      &vocab.Object{ID: "https://example.com/objects/1", Type: vocab.VideoType, Content: content},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.p.processMediaContent(tt.ob); (err != nil) != tt.wantErr {
+				t.Fatalf("processMediaContent() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !cmp.Equal(tt.ob.Content, content) {
+				t.Errorf("processMediaContent() content = %v, expected it to be unchanged", tt.ob.Content)
+			}
+			if tt.ob.URL != nil || tt.ob.MediaType != "" {
+				t.Errorf("processMediaContent() URL = %v, media type = %s, expected them to be unset", tt.ob.URL, tt.ob.MediaType)
+			}
+		})
+	}
+}
+
+func TestP_deleteMediaBlobs(t *testing.T) {
+	p := mockProcessor(t, defaultActorID)
+	p.blobs = NewFSBlobStore(t.TempDir(), defaultActorID+"/blobs")
+
+	put := func() vocab.IRI {
+		iri, _, err := p.blobs.Put("image/png", []byte("\x89PNG\r\n\x1a\n"))
+		if err != nil {
+			t.Fatalf("Put() error = %s", err)
+		}
+		return iri
+	}
+	image, attachment, remote := put(), put(), put()
+
+	local := &vocab.Object{
+		ID:         defaultActorID + "/objects/1",
+		Type:       vocab.NoteType,
+		Attachment: &vocab.Object{Type: vocab.ImageType, URL: mediaLink(attachment, "image/png", mediaMetadata{})},
+		URL:        mediaLink(image, "image/png", mediaMetadata{}),
+	}
+	// NOTE(marius): a remote object can't remove our blobs, even if it links to them
+	foreign := &vocab.Object{ID: "https://remote.example.com/objects/1", Type: vocab.ImageType, URL: mediaLink(remote, "image/png", mediaMetadata{})}
+	p.deleteMediaBlobs(local, foreign)
+
+	for _, iri := range (vocab.IRIs{image, attachment}) {
+		if _, _, err := p.blobs.Get(iri); !errors.IsNotFound(err) {
+			t.Errorf("deleteMediaBlobs() didn't remove blob %s: %v", iri, err)
+		}
+	}
+	if _, _, err := p.blobs.Get(remote); err != nil {
+		t.Errorf("deleteMediaBlobs() removed blob %s linked from a remote object: %s", remote, err)
+	}
+}
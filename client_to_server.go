@@ -53,17 +53,32 @@ func (p *P) ProcessClientActivity(it vocab.Item, author vocab.Actor, receivedIn
 	if err := p.ValidateClientActivity(it, author, receivedIn); err != nil {
 		return it, err
 	}
+	var previous int64
+	if p.quotaPolicy != nil && vocab.UpdateType.Match(it.GetType()) {
+		previous = p.storedObjectsSize(it)
+	}
+	usage, err := p.reserveQuota(it, author.GetLink(), previous)
+	if err != nil {
+		return it, err
+	}
 	// NOTE(marius): the separation between transitive and intransitive activities overlaps the separation we're
 	// using in the processingClientActivity function between the ActivityStreams motivations separation.
 	// This means that 'it' should probably be treated as a vocab.Item until the last possible moment.
 	if vocab.IntransitiveActivityTypes.Match(it.GetType()) {
-		return p.processClientIntransitiveActivity(it, receivedIn)
+		it, err = p.processClientIntransitiveActivity(it, receivedIn)
+	} else {
+		err = vocab.OnActivity(it, func(act *vocab.Activity) error {
+			var err error
+			it, err = p.processClientActivity(act, receivedIn)
+			return err
+		})
 	}
-	return it, vocab.OnActivity(it, func(act *vocab.Activity) error {
-		var err error
-		it, err = p.processClientActivity(act, receivedIn)
-		return err
-	})
+	if err != nil {
+		p.releaseQuota(author.GetLink(), usage)
+		return it, err
+	}
+	p.settleStoredQuota(author.GetLink(), it, previous, usage)
+	return it, nil
 }
 
 // ProcessOutboxDelivery
@@ -132,6 +147,9 @@ func (p *P) processClientIntransitiveActivity(act vocab.Item, receivedIn vocab.I
 	if act, err = p.s.Save(vocab.FlattenProperties(act)); err != nil {
 		return act, err
 	}
+	if err = p.createActivityIntegrityProof(act); err != nil {
+		p.l.Warnf("unable to create integrity proof of activity %s: %s", act.GetLink(), err)
+	}
 
 	sync := func() {
 		if err := p.ProcessOutboxDelivery(act, receivedIn); err != nil {
@@ -207,6 +225,9 @@ func (p *P) processClientActivity(act *vocab.Activity, receivedIn vocab.IRI) (vo
 	if err != nil {
 		return act, err
 	}
+	if err = p.createActivityIntegrityProof(it); err != nil {
+		p.l.Warnf("unable to create integrity proof of activity %s: %s", it.GetLink(), err)
+	}
 
 	sync := func() {
 		// Additional recommendation from the ActivityPub mailing list:
@@ -273,6 +294,11 @@ func (p P) BuildOutboxRecipientsList(it vocab.Item, receivedIn vocab.IRI) vocab.
 			continue
 		}
 
+		if p.IsBlockedDomain(recIRI) {
+			p.l.WithContext(lw.Ctx{"rec": recIRI}).Tracef("Skipping recipient from blocked domain")
+			continue
+		}
+
 		if actorHasBlocked(recIRI) {
 			// NOTE(marius): if the activity actor has blocked the recipient, we skip
 			p.l.WithContext(lw.Ctx{"actor": act.Actor.GetID(), "rec": recIRI}).Tracef("Skipping blocked recipient")
@@ -280,7 +306,7 @@ func (p P) BuildOutboxRecipientsList(it vocab.Item, receivedIn vocab.IRI) vocab.
 		}
 
 		if !p.IsLocalIRI(recIRI) {
-			_ = allRecipients.Append(vocab.Inbox.IRI(recIRI))
+			_ = allRecipients.Append(p.inboxForDelivery(recIRI))
 			continue
 		}
 
@@ -313,5 +339,5 @@ func (p P) BuildOutboxRecipientsList(it vocab.Item, receivedIn vocab.IRI) vocab.
 	// We do this, because it could be missing from the Activity's recipients fields (to, bto, cc, bcc)
 	_ = allRecipients.Append(receivedIn)
 
-	return vocab.ItemCollectionDeduplication(&allRecipients)
+	return p.restrictSilencedRecipients(act.Actor, vocab.ItemCollectionDeduplication(&allRecipients))
 }
@@ -139,3 +139,76 @@ func (p *P) UpdateObjectID(move *vocab.Activity) (*vocab.Activity, error) {
 
 	return move, nil
 }
+
+// UndoCollectionManagementActivity
+//
+// Removes the side effects of an existing CollectionManagement activity (Add, Remove, Move)
+// Currently this means operating the inverse collection operation of the original Activity:
+// Add - removes the original object from the target collection.
+// Remove - adds the original object back to the origin collection.
+// Move - moves the original object from the target collection back to the origin collection.
+func (p *P) UndoCollectionManagementActivity(toUndo *vocab.Activity) (*vocab.Activity, error) {
+	if vocab.IsNil(toUndo) {
+		return toUndo, InvalidActivity("nil collection management activity")
+	}
+	if vocab.IsNil(toUndo.Object) {
+		return toUndo, InvalidActivityObject("is nil for %T[%s]", toUndo, toUndo.GetType())
+	}
+
+	var err error
+	typ := toUndo.GetType()
+	switch {
+	case vocab.AddType.Match(typ):
+		inverse := vocab.Activity{Type: vocab.RemoveType, Actor: toUndo.Actor, Object: toUndo.Object, Origin: toUndo.Target}
+		_, err = p.RemoveActivity(&inverse)
+	case vocab.RemoveType.Match(typ):
+		inverse := vocab.Activity{Type: vocab.AddType, Actor: toUndo.Actor, Object: toUndo.Object, Target: toUndo.Origin}
+		_, err = p.AddActivity(&inverse)
+	case vocab.MoveType.Match(typ):
+		if vocab.ItemsEqual(toUndo.Object, toUndo.Origin) {
+			// NOTE(marius): the special Move activity that updates an object's ID overwrites the object,
+			// so there's nothing left to move back.
+			return toUndo, errors.NotImplementedf("unable to Undo %s activity that updated the ID of its object", typ)
+		}
+		inverse := vocab.Activity{Type: vocab.MoveType, Actor: toUndo.Actor, Object: toUndo.Object, Origin: toUndo.Target, Target: toUndo.Origin}
+		_, err = p.MoveActivity(&inverse)
+	default:
+		return toUndo, errors.BadRequestf("Activity has wrong type %s, expected one of %v", typ, vocab.CollectionManagementActivityTypes)
+	}
+	if err != nil {
+		return toUndo, errors.Annotatef(err, "failed to Undo %s activity", typ)
+	}
+	return toUndo, p.removeFromLocalDissemination(toUndo)
+}
+
+// removeFromLocalDissemination removes the "act" activity from the local collections it has been disseminated to:
+// its actor's Outbox and the Inboxes of its local recipients.
+func (p *P) removeFromLocalDissemination(act *vocab.Activity) error {
+	errs := make([]error, 0)
+
+	toRemove := act.GetLink()
+	removeFromCols := make(vocab.IRIs, 0)
+	if p.IsLocal(act.Actor) {
+		_ = removeFromCols.Append(vocab.Outbox.IRI(act.Actor))
+	}
+	for _, rec := range act.Recipients() {
+		recIRI := rec.GetLink()
+		if recIRI == "" || vocab.PublicNS.Equal(recIRI) || !p.IsLocalIRI(recIRI) {
+			continue
+		}
+		if !vocab.ValidCollectionIRI(recIRI) {
+			// NOTE(marius): if recipient is not a valid collection,
+			//  we assume it represents an actor, and we try to get their Inbox
+			if recIRI = vocab.Inbox.IRI(recIRI); !p.IsLocalIRI(recIRI) {
+				continue
+			}
+		}
+		_ = removeFromCols.Append(recIRI)
+	}
+	for _, removeFrom := range removeFromCols {
+		if err := p.s.RemoveFrom(removeFrom, toRemove); err != nil && !errors.IsNotFound(err) {
+			errs = append(errs, errors.Annotatef(err, "unable to remove from collection %s", removeFrom))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -374,3 +374,105 @@ func TestP_MoveActivity(t *testing.T) {
 		})
 	}
 }
+
+func TestP_UndoCollectionManagementActivity(t *testing.T) {
+	tests := []struct {
+		name      string
+		base      vocab.IRI
+		toUndo    *vocab.Activity
+		items     map[vocab.IRI]vocab.ItemCollection
+		wantIn    map[vocab.IRI]vocab.ItemCollection
+		wantNotIn map[vocab.IRI]vocab.ItemCollection
+		wantErr   error
+	}{
+		{
+			name:    "empty",
+			base:    "https://example.local",
+			wantErr: InvalidActivity("nil collection management activity"),
+		},
+		{
+			name: "undo add jdoe to his own followers",
+			base: "https://jdoe.example.local",
+			items: map[vocab.IRI]vocab.ItemCollection{
+				"https://jdoe.example.com/followers": {vocab.IRI("https://jdoe.example.com")},
+			},
+			toUndo: &vocab.Activity{
+				Type:   vocab.AddType,
+				Target: vocab.IRI("https://jdoe.example.com/followers"),
+				Object: vocab.IRI("https://jdoe.example.com"),
+			},
+			wantNotIn: map[vocab.IRI]vocab.ItemCollection{
+				"https://jdoe.example.com/followers": {vocab.IRI("https://jdoe.example.com")},
+			},
+		},
+		{
+			name: "undo remove jdoe from his own followers",
+			base: "https://jdoe.example.local",
+			toUndo: &vocab.Activity{
+				Type:   vocab.RemoveType,
+				Origin: vocab.IRI("https://jdoe.example.com/followers"),
+				Object: vocab.IRI("https://jdoe.example.com"),
+			},
+			wantIn: map[vocab.IRI]vocab.ItemCollection{
+				"https://jdoe.example.com/followers": {vocab.IRI("https://jdoe.example.com")},
+			},
+		},
+		{
+			name: "undo move jdoe from followers to following",
+			base: "https://jdoe.example.local",
+			items: map[vocab.IRI]vocab.ItemCollection{
+				"https://jdoe.example.com/following": {vocab.IRI("https://jdoe.example.com")},
+			},
+			toUndo: &vocab.Activity{
+				Type:   vocab.MoveType,
+				Origin: vocab.IRI("https://jdoe.example.com/followers"),
+				Target: vocab.IRI("https://jdoe.example.com/following"),
+				Object: vocab.IRI("https://jdoe.example.com"),
+			},
+			wantIn: map[vocab.IRI]vocab.ItemCollection{
+				"https://jdoe.example.com/followers": {vocab.IRI("https://jdoe.example.com")},
+			},
+			wantNotIn: map[vocab.IRI]vocab.ItemCollection{
+				"https://jdoe.example.com/following": {vocab.IRI("https://jdoe.example.com")},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := mockProcessor(t, tt.base)
+			// NOTE(marius): add items to collections
+			for col, items := range tt.items {
+				_ = p.s.AddTo(col, items...)
+			}
+
+			_, err := p.UndoCollectionManagementActivity(tt.toUndo)
+			if !cmp.Equal(tt.wantErr, err, EquateWeakErrors) {
+				t.Errorf("UndoCollectionManagementActivity() error = %s", cmp.Diff(tt.wantErr, err, EquateWeakErrors))
+				return
+			}
+			checkCollection := func(colIRI vocab.IRI, items vocab.ItemCollection, wantContained bool) {
+				it, err := p.s.Load(colIRI)
+				if err != nil {
+					t.Errorf("UndoCollectionManagementActivity() unable to load collection from storage: %v", err)
+					return
+				}
+				col, ok := it.(vocab.CollectionInterface)
+				if !ok {
+					t.Errorf("UndoCollectionManagementActivity() %T is not %T", it, vocab.CollectionInterface(nil))
+					return
+				}
+				for _, item := range items {
+					if col.Contains(item) != wantContained {
+						t.Errorf("UndoCollectionManagementActivity() object %s contained in %s: %t, expected %t", item.GetLink(), colIRI, !wantContained, wantContained)
+					}
+				}
+			}
+			for colIRI, items := range tt.wantIn {
+				checkCollection(colIRI, items, true)
+			}
+			for colIRI, items := range tt.wantNotIn {
+				checkCollection(colIRI, items, false)
+			}
+		})
+	}
+}
@@ -1,7 +1,10 @@
 package processing
 
 import (
+	"bytes"
 	"context"
+	"io"
+	"net/http"
 	"time"
 
 	"git.sr.ht/~mariusor/lw"
@@ -55,12 +58,38 @@ func (p P) disseminateToRemoteCollections(it vocab.Item, iris ...vocab.IRI) erro
 		return InvalidActivity("is nil")
 	}
 
-	if p.c == nil {
+	if p.c == nil && p.keyLoader == nil {
 		return errors.NotImplementedf("unable to push to remote collection, S2S client is nil for %s", it.GetLink())
 	}
 
+	var actor vocab.Item
+	_ = vocab.OnIntransitiveActivity(it, func(act *vocab.IntransitiveActivity) error {
+		actor = act.Actor
+		return nil
+	})
+	// NOTE(marius): the blind recipients must not be disclosed to the remote servers
+	it = redactItem(it, nil)
+
+	// NOTE(marius): when we have a KeyLoader, we sign the deliveries ourselves with the key of the actor of the
+	// activity. Otherwise, we rely on the client having been set up for signing them, and we pass the actor in
+	// the context of the requests, for its transport to find. See P.SigningTransport.
+	reqCtx := ContextWithSigningActor(context.TODO(), actor)
+	deliver := func(col vocab.IRI) error {
+		_, _, err := p.c.CtxToCollection(reqCtx, it, col)
+		return err
+	}
+	if p.keyLoader != nil {
+		body, err := vocab.MarshalJSON(it)
+		if err != nil {
+			return errors.Annotatef(err, "unable to marshal activity %s", it.GetLink())
+		}
+		deliver = func(col vocab.IRI) error {
+			return p.deliverSigned(reqCtx, body, actor, col)
+		}
+	}
+
 	states := make([]ssm.Fn, 0, len(iris))
-	for _, col := range iris {
+	for _, col := range p.filterBlockedDomains(iris) {
 		if p.IsLocalIRI(col) {
 			p.l.Warnf("Invalid attempt to disseminate to local collection %s", col)
 			continue
@@ -70,15 +99,12 @@ func (p P) disseminateToRemoteCollections(it vocab.Item, iris ...vocab.IRI) erro
 		start := time.Now().UTC()
 		delay := time.Duration(0)
 		state := retryFn(p.retries, func(ctx context.Context) ssm.Fn {
-			// NOTE(marius): we expect that the client has already been set up for being able to POST requests
-			// to remote servers. This means that it has been constructed using a HTTP client that includes
-			// an HTTP-Signature RoundTripper.
 			defer func() {
 				currentRetry += 1
 				delay = time.Since(start)
 			}()
 			ll := p.l.WithContext(lw.Ctx{"to": col, "retry": currentRetry, "delay": delay.String()})
-			if _, _, err := p.c.CtxToCollection(context.TODO(), it, col); err != nil {
+			if err := deliver(col); err != nil {
 				ll.Warnf("Unable to disseminate activity %s", err)
 				switch {
 				case errors.IsConflict(err):
@@ -109,6 +135,42 @@ func (p P) disseminateToRemoteCollections(it vocab.Item, iris ...vocab.IRI) erro
 	return ssm.RunParallel(context.Background(), states...)
 }
 
+// activityStreamsMediaType is the media type of the ActivityPub requests.
+const activityStreamsMediaType = `application/ld+json; profile="https://www.w3.org/ns/activitystreams"`
+
+// deliverSigned posts the "body" activity to the "col" remote collection, signing the request with the key of its
+// "actor", or with the key of the instance actor when the actor's key can't be loaded.
+func (p *P) deliverSigned(ctx context.Context, body []byte, actor vocab.Item, col vocab.IRI) error {
+	key, keyID, err := p.signingKeyFor(actor)
+	if err != nil {
+		return errors.Annotatef(err, "unable to sign delivery to %s", col)
+	}
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, col.String(), bytes.NewReader(body))
+	if err != nil {
+		return errors.Annotatef(err, "unable to create delivery request to %s", col)
+	}
+	r.Header.Set("Content-Type", activityStreamsMediaType)
+	r.Header.Set("Accept", activityStreamsMediaType)
+	if err = p.signOutgoingRequest(r, actor, key, keyID); err != nil {
+		return errors.Annotatef(err, "unable to sign delivery to %s", col)
+	}
+
+	transport := p.deliveryTransport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	resp, err := (&http.Client{Transport: transport}).Do(r)
+	if err != nil {
+		return errors.Annotatef(err, "unable to deliver to %s", col)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, p.maxRequestBodySize()))
+	if resp.StatusCode >= http.StatusBadRequest {
+		return errors.NewFromStatus(resp.StatusCode, "unable to deliver to %s: %s", col, resp.Status)
+	}
+	return nil
+}
+
 type filterFn func(vocab.Item) bool
 
 func (ff filterFn) Match(it vocab.Item) bool {
@@ -174,7 +236,7 @@ func (p P) AddItemToCollection(col vocab.IRI, it vocab.Item) error {
 	if !p.IsLocalIRI(col) {
 		return nil
 	}
-	if !p.IsLocal(it) && vocab.IsIRI(it) {
+	if !p.IsLocal(it) && vocab.IsIRI(it) && !p.IsBlockedDomain(it.GetLink()) {
 		// NOTE(marius): the fetching and saving of the remote item is a candidate for switching to async
 		deref, err := p.c.CtxLoadIRI(context.TODO(), it.GetLink())
 		if err != nil {
@@ -124,7 +124,7 @@ func ContentManagementActivityFromClient(p *P, act *vocab.Activity) (*vocab.Acti
 	case vocab.UpdateType.Match(act.Type):
 		act, err = p.UpdateActivity(act)
 	case vocab.DeleteType.Match(act.Type):
-		act, err = DeleteActivity(p.s, act)
+		act, err = p.deleteActivityWithCascade(act)
 	}
 	if err != nil && !isDuplicateKey(err) {
 		return act, err
@@ -284,6 +284,10 @@ func CreateActivityFromClient(p *P, act *vocab.Activity) (*vocab.Activity, error
 		return act, errors.Annotatef(err, "unable to create activity's object %s", act.Object.GetLink())
 	}
 
+	if err = p.processMediaContent(act.Object); err != nil {
+		return act, err
+	}
+
 	if act.Object, err = p.s.Save(vocab.FlattenProperties(act.Object)); err != nil {
 		return act, errors.Annotatef(err, "unable to save object to storage %s", act.Object.GetLink())
 	}
@@ -381,8 +385,8 @@ func (p P) saveCollectionObjectForParent(parent, colIt vocab.Item) error {
 		return nil
 	})
 
-	if _, maybePrivateCol := filters.HiddenCollections.Split(colIt.GetLink()); maybePrivateCol != vocab.Unknown {
-		// NOTE(marius): for blocked and ignored collections we forcibly remove the public collection
+	if isHiddenCollection(colIt.GetLink()) {
+		// NOTE(marius): for blocked, ignored and history collections we forcibly remove the public collection
 		to = nil
 		bto = vocab.ItemCollection{parent.GetID()}
 		cc = nil
@@ -406,6 +410,22 @@ func (p P) saveCollectionObjectForParent(parent, colIt vocab.Item) error {
 	return err
 }
 
+// hiddenCollections are the collections that, in addition to the ones in filters.HiddenCollections,
+// are accessible only to their owner.
+var hiddenCollections = vocab.CollectionPaths{
+	HistoryCollection, TombstonesCollection, QuarantineCollection,
+	ReportsCollection, ResolvedReportsCollection, DismissedReportsCollection,
+	MuteRulesCollection, SeveredCollection,
+}
+
+func isHiddenCollection(iri vocab.IRI) bool {
+	if _, maybePrivateCol := filters.HiddenCollections.Split(iri); maybePrivateCol != vocab.Unknown {
+		return true
+	}
+	_, maybePrivateCol := hiddenCollections.Split(iri)
+	return maybePrivateCol != vocab.Unknown
+}
+
 func blankOrderedCollection(iri vocab.IRI) *vocab.OrderedCollection {
 	return &vocab.OrderedCollection{ID: iri, Type: vocab.OrderedCollectionType}
 }
@@ -423,10 +443,13 @@ func (p *P) CreateCollectionsForObject(it vocab.Item) error {
 			_ = p.saveCollectionObjectForParent(a, a.Followers)
 			_ = p.saveCollectionObjectForParent(a, a.Following)
 			_ = p.saveCollectionObjectForParent(a, a.Liked)
+			// NOTE(marius): shadow creating the Disliked collection, which doesn't exist on the actor
+			_ = p.saveCollectionObjectForParent(a, blankOrderedCollection(DislikedCollection.IRI(a)))
 			// NOTE(marius): shadow creating hidden collections for Blocked and Ignored items
 			// They do not exist on the actor, so we force their creation
 			_ = p.saveCollectionObjectForParent(a, blankOrderedCollection(filters.BlockedType.IRI(a)))
 			_ = p.saveCollectionObjectForParent(a, blankOrderedCollection(filters.IgnoredType.IRI(a)))
+			_ = p.saveCollectionObjectForParent(a, blankOrderedCollection(MuteRulesCollection.IRI(a)))
 			return nil
 		})
 	}
@@ -434,6 +457,8 @@ func (p *P) CreateCollectionsForObject(it vocab.Item) error {
 		_ = p.saveCollectionObjectForParent(o, o.Replies)
 		_ = p.saveCollectionObjectForParent(o, o.Likes)
 		_ = p.saveCollectionObjectForParent(o, o.Shares)
+		// NOTE(marius): shadow creating the Dislikes collection, which doesn't exist on the object
+		_ = p.saveCollectionObjectForParent(o, blankOrderedCollection(DislikesCollection.IRI(o)))
 		return nil
 	})
 }
@@ -452,14 +477,23 @@ func deref(ctx context.Context, c client.Basic, it vocab.Item) (vocab.Item, erro
 	return it, nil
 }
 
+// derefAllowed dereferences "it" if it's an IRI that doesn't belong to a domain blocked by the processor's
+// FederationPolicy.
+func (p P) derefAllowed(ctx context.Context, it vocab.Item) (vocab.Item, error) {
+	if vocab.IsIRI(it) && p.IsBlockedDomain(it.GetLink()) {
+		return it, nil
+	}
+	return deref(ctx, p.c, it)
+}
+
 func (p P) dereferenceIntransitiveActivityProperties(receivedIn vocab.IRI) func(act *vocab.IntransitiveActivity) error {
 	return func(act *vocab.IntransitiveActivity) error {
 		ctx := context.TODO()
 		var err error
-		if act.Actor, err = deref(ctx, p.c, act.Actor); err != nil {
+		if act.Actor, err = p.derefAllowed(ctx, act.Actor); err != nil {
 			return err
 		}
-		if act.Target, err = deref(ctx, p.c, act.Target); err != nil {
+		if act.Target, err = p.derefAllowed(ctx, act.Target); err != nil {
 			return err
 		}
 		return nil
@@ -470,7 +504,7 @@ func (p P) dereferenceActivityProperties(receivedIn vocab.IRI) func(act *vocab.A
 	return func(act *vocab.Activity) error {
 		ctx := context.TODO()
 		var err error
-		if act.Object, err = deref(ctx, p.c, act.Object); err != nil {
+		if act.Object, err = p.derefAllowed(ctx, act.Object); err != nil {
 			return err
 		}
 		return vocab.OnIntransitiveActivity(act, p.dereferenceIntransitiveActivityProperties(receivedIn))
@@ -504,10 +538,17 @@ func (p *P) UpdateActivity(upd *vocab.Activity) (*vocab.Activity, error) {
 	var err error
 	ob := upd.Object
 
+	// NOTE(marius): the IRIs of the previous versions of the objects are derived from the Update's IRI.
+	if p.keepHistory {
+		if err = SetIDIfMissing(upd, nil, p.createIDFn); err != nil {
+			return upd, err
+		}
+	}
+
 	if vocab.IsItemCollection(ob) {
 		err = vocab.OnItemCollection(ob, func(col *vocab.ItemCollection) error {
 			for i, it := range *col {
-				old, err := p.loadAndUpdateSingleItem(it)
+				old, err := p.loadAndUpdateSingleItem(it, upd)
 				if err != nil {
 					return err
 				}
@@ -520,7 +561,7 @@ func (p *P) UpdateActivity(upd *vocab.Activity) (*vocab.Activity, error) {
 			return upd, err
 		}
 	} else {
-		old, err := p.loadAndUpdateSingleItem(ob)
+		old, err := p.loadAndUpdateSingleItem(ob, upd)
 		if err != nil {
 			return upd, err
 		}
@@ -529,14 +570,71 @@ func (p *P) UpdateActivity(upd *vocab.Activity) (*vocab.Activity, error) {
 	return upd, disseminateActivityObjectToLocalReplyToCollections(p, upd)
 }
 
-func (p *P) loadAndUpdateSingleItem(it vocab.Item) (vocab.Item, error) {
+// UndoUpdateActivity
+//
+// Removes the side effects of an existing Update activity
+// Currently this means restoring each of the Update's objects to the version they had before the Update was processed.
+// This is possible only if that version can be found in the object's history collection, so it requires the
+// processor to be created with the KeepObjectHistory option.
+func (p *P) UndoUpdateActivity(upd *vocab.Activity) (*vocab.Activity, error) {
+	if upd == nil {
+		return upd, InvalidActivity("nil Update activity")
+	}
+	if !p.keepHistory {
+		return upd, errors.NotImplementedf("unable to Undo Update activities without keeping the history of objects")
+	}
+	if vocab.IsNil(upd.Object) {
+		return upd, InvalidActivityObject("is nil for %T[%s]", upd, upd.GetType())
+	}
+
+	errs := make([]error, 0)
+	_ = vocab.OnItem(upd.Object, func(ob vocab.Item) error {
+		versionIRI := objectVersionIRI(ob, upd)
+		version, err := p.s.Load(versionIRI)
+		if err != nil || vocab.IsNil(version) {
+			errs = append(errs, errors.NotFoundf("no previous version of %s was stored for Update %s", ob.GetLink(), upd.GetLink()))
+			return nil
+		}
+		if _, err = p.restoreObjectVersion(ob.GetLink(), firstOrItem(version)); err != nil {
+			errs = append(errs, err)
+		}
+		return nil
+	})
+	if len(errs) > 0 {
+		return upd, errors.Annotatef(errors.Join(errs...), "failed to Undo Update activity")
+	}
+	return upd, p.removeFromLocalDissemination(upd)
+}
+
+func (p *P) loadAndUpdateSingleItem(it vocab.Item, upd *vocab.Activity) (vocab.Item, error) {
 	old, err := p.s.Load(it.GetLink())
 	if err != nil {
 		return it, err
 	}
+	if p.keepHistory {
+		if err = p.saveObjectVersion(firstOrItem(old), upd); err != nil {
+			return it, errors.Annotatef(err, "unable to save previous version of %s", it.GetLink())
+		}
+	}
+	if err = p.processMediaContent(it); err != nil {
+		return it, err
+	}
+	replaced := mediaBlobIRIs(firstOrItem(old))
 	if old, err = p.updateSingleItem(firstOrItem(old), it); err != nil {
 		return it, err
 	}
+	// NOTE(marius): when keeping the history of the objects, the replaced blobs are still used by the previous
+	// versions, and they get removed when the object is purged.
+	if !p.keepHistory && p.IsLocalIRI(it.GetLink()) {
+		kept := mediaBlobIRIs(old)
+		var freed int64
+		for _, iri := range replaced {
+			if !kept.Contains(iri) {
+				freed += p.deleteBlobs(iri)
+			}
+		}
+		p.releaseMediaQuota(old, freed)
+	}
 	return old, nil
 }
 
@@ -757,8 +855,10 @@ func removeItemCollections(st WriteStore, it vocab.Item) error {
 			_ = removeCollectionObject(a.Followers)
 			_ = removeCollectionObject(a.Following)
 			_ = removeCollectionObject(a.Liked)
+			_ = removeCollectionObject(DislikedCollection.IRI(a))
 			_ = removeCollectionObject(filters.BlockedType.IRI(a))
 			_ = removeCollectionObject(filters.IgnoredType.IRI(a))
+			_ = removeCollectionObject(MuteRulesCollection.IRI(a))
 			return nil
 		})
 	}
@@ -766,6 +866,8 @@ func removeItemCollections(st WriteStore, it vocab.Item) error {
 		_ = removeCollectionObject(o.Replies)
 		_ = removeCollectionObject(o.Likes)
 		_ = removeCollectionObject(o.Shares)
+		_ = removeCollectionObject(DislikesCollection.IRI(o))
+		_ = removeCollectionObject(HistoryCollection.IRI(o))
 		return nil
 	})
 }
@@ -781,20 +883,32 @@ func loadTombstoneForDelete(l WriteStore, toRemove *vocab.ItemCollection) func(v
 		if err != nil {
 			return err
 		}
-		if vocab.IsNil(found) {
+		if found = firstOrItem(found); vocab.IsNil(found) {
 			return errors.NotFoundf("unable to find %s %s", it.GetType(), it.GetLink())
 		}
 
-		// NOTE(marius): we don't want the object's collections to still be accessible
-		_ = removeItemCollections(l, it)
+		// NOTE(marius): we don't want the actor's collections to still be accessible. The collections of
+		// the other objects are removed when their tombstones get purged, as we need their items for cleaning
+		// up the data that the objects leave behind.
+		if vocab.ActorTypes.Match(found.GetType()) {
+			_ = removeItemCollections(l, found)
+		}
 
 		t := vocab.Tombstone{
 			ID:         found.GetLink(),
 			Type:       vocab.TombstoneType,
 			To:         vocab.ItemCollection{vocab.PublicNS},
 			Deleted:    time.Now().UTC(),
-			FormerType: it.GetType(),
+			FormerType: found.GetType(),
 		}
+		// NOTE(marius): we keep the InReplyTo of the object, so we can clean up the replies collections
+		// when the tombstone gets purged, and its AttributedTo, so the size of the tombstone can be released
+		// from its author's quota.
+		_ = vocab.OnObject(found, func(ob *vocab.Object) error {
+			t.InReplyTo = ob.InReplyTo
+			t.AttributedTo = ob.AttributedTo
+			return nil
+		})
 		*toRemove = append(*toRemove, t)
 		return nil
 	}
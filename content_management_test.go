@@ -22,6 +22,76 @@ func TestUpdateActivity(t *testing.T) {
 	t.Skipf("TODO")
 }
 
+func TestP_UndoUpdateActivity(t *testing.T) {
+	noteIRI := vocab.IRI("https://example.com/objects/1")
+	upd := func() *vocab.Activity {
+		return &vocab.Activity{
+			ID:     "https://example.com/activities/2",
+			Type:   vocab.UpdateType,
+			Actor:  defaultActorID,
+			Object: &vocab.Object{ID: noteIRI, Type: vocab.NoteType, Content: vocab.DefaultNaturalLanguage("edited")},
+		}
+	}
+	tests := []struct {
+		name        string
+		keepHistory bool
+		toUndo      *vocab.Activity
+		wantContent string
+		wantErr     bool
+	}{
+		{
+			name:    "empty",
+			toUndo:  nil,
+			wantErr: true,
+		},
+		{
+			name:        "without history",
+			toUndo:      upd(),
+			wantContent: "edited",
+			wantErr:     true,
+		},
+		{
+			name:        "with history",
+			keepHistory: true,
+			toUndo:      upd(),
+			wantContent: "original",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := mockProcessor(t, "https://example.com")
+			p.keepHistory = tt.keepHistory
+			note := &vocab.Object{ID: noteIRI, Type: vocab.NoteType, Content: vocab.DefaultNaturalLanguage("original")}
+			if _, err := p.s.Save(note); err != nil {
+				t.Fatalf("unable to save object: %s", err)
+			}
+			if tt.toUndo != nil {
+				if _, err := p.UpdateActivity(tt.toUndo); err != nil {
+					t.Fatalf("UpdateActivity() error = %s", err)
+				}
+			}
+
+			_, err := p.UndoUpdateActivity(tt.toUndo)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("UndoUpdateActivity() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if tt.toUndo == nil {
+				return
+			}
+			it, err := p.s.Load(noteIRI)
+			if err != nil {
+				t.Fatalf("unable to load object: %s", err)
+			}
+			_ = vocab.OnObject(it, func(ob *vocab.Object) error {
+				if got := string(ob.Content.First()); got != tt.wantContent {
+					t.Errorf("UndoUpdateActivity() content = %q, want %q", got, tt.wantContent)
+				}
+				return nil
+			})
+		})
+	}
+}
+
 func Test_updateCreateActivityObject(t *testing.T) {
 	type args struct {
 		o   vocab.Item
@@ -0,0 +1,251 @@
+package processing
+
+import (
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+// localActorsFromItem returns the local actors found in "it", loading them from storage if needed.
+func (p *P) localActorsFromItem(it vocab.Item) []*vocab.Actor {
+	actors := make([]*vocab.Actor, 0)
+	_ = vocab.OnItem(it, func(it vocab.Item) error {
+		if !p.IsLocal(it) {
+			return nil
+		}
+		it = p.loadLocalCopy(it)
+		if !vocab.ActorTypes.Match(it.GetType()) {
+			return nil
+		}
+		return vocab.OnActor(it, func(act *vocab.Actor) error {
+			actors = append(actors, act)
+			return nil
+		})
+	})
+	return actors
+}
+
+func isAuthoredBy(it vocab.Item, actor vocab.Item) bool {
+	authored := false
+	_ = vocab.OnObject(it, func(ob *vocab.Object) error {
+		return vocab.OnItem(ob.AttributedTo, func(author vocab.Item) error {
+			if author.GetLink().Equal(actor.GetLink()) {
+				authored = true
+			}
+			return nil
+		})
+	})
+	return authored
+}
+
+// inboxForDelivery returns the IRI where we should deliver activities to the "rec" remote actor.
+// We prefer the shared inbox of the actor if it exists.
+func (p *P) inboxForDelivery(rec vocab.Item) vocab.IRI {
+	inbox := vocab.Inbox.IRI(rec)
+	_ = vocab.OnActor(p.loadLocalCopy(rec), func(act *vocab.Actor) error {
+		if act.Endpoints != nil && !vocab.IsNil(act.Endpoints.SharedInbox) {
+			inbox = act.Endpoints.SharedInbox.GetLink()
+		} else if !vocab.IsNil(act.Inbox) {
+			inbox = act.Inbox.GetLink()
+		}
+		return nil
+	})
+	return inbox
+}
+
+// cascadeActorDelete removes the side effects of the existence of the local "actor" which is being deleted:
+//   - the objects it authored are replaced with Tombstones and removed from the replies collections of local objects.
+//   - its Like, Dislike and Announce activities are removed from the likes, dislikes and shares collections
+//     of local objects.
+//   - it is removed from the followers collections of the local actors it follows, and from the following
+//     collections of its local followers.
+//
+// It returns its remote followers, which need to be notified of the deletion.
+func (p *P) cascadeActorDelete(actor *vocab.Actor) (vocab.IRIs, error) {
+	errs := make([]error, 0)
+	removeFrom := func(colIRI vocab.IRI, it vocab.Item) {
+		if !p.IsLocalIRI(colIRI) {
+			return
+		}
+		if err := p.s.RemoveFrom(colIRI, it.GetLink()); err != nil && !errors.IsNotFound(err) {
+			errs = append(errs, errors.Annotatef(err, "unable to remove from collection %s", colIRI))
+		}
+	}
+
+	authored := make(vocab.ItemCollection, 0)
+	err := p.walkCollection(vocab.Outbox.IRI(actor), func(it vocab.Item) error {
+		return vocab.OnActivity(p.loadLocalCopy(it), func(act *vocab.Activity) error {
+			typ := act.GetType()
+			switch {
+			case vocab.CreateType.Match(typ):
+				return vocab.OnItem(act.Object, func(ob vocab.Item) error {
+					if !p.IsLocal(ob) {
+						return nil
+					}
+					if ob = p.loadLocalCopy(ob); !isAuthoredBy(ob, actor) {
+						return nil
+					}
+					_ = vocab.OnObject(ob, func(o *vocab.Object) error {
+						return vocab.OnItem(o.InReplyTo, func(replyTo vocab.Item) error {
+							removeFrom(vocab.Replies.IRI(replyTo), ob)
+							return nil
+						})
+					})
+					return authored.Append(ob)
+				})
+			case vocab.ActivityVocabularyTypes{vocab.LikeType, vocab.DislikeType}.Match(typ):
+				_, objectCol := appreciationCollections(typ)
+				return vocab.OnItem(act.Object, func(ob vocab.Item) error {
+					removeFrom(objectCol.IRI(ob), act)
+					return nil
+				})
+			case vocab.AnnounceType.Match(typ):
+				return vocab.OnItem(act.Object, func(ob vocab.Item) error {
+					removeFrom(vocab.Shares.IRI(ob), act)
+					return nil
+				})
+			}
+			return nil
+		})
+	})
+	if err != nil && !errors.IsNotFound(err) {
+		errs = append(errs, err)
+	}
+
+	if len(authored) > 0 {
+		p.deleteMediaBlobs(authored...)
+		tombstones := make(vocab.ItemCollection, 0, len(authored))
+		if err = replaceItemWithTombstone(p.s, authored, &tombstones); err != nil {
+			errs = append(errs, errors.Annotatef(err, "unable to create tombstones for objects of %s", actor.ID))
+		}
+		for _, t := range tombstones {
+			if _, err = p.s.Save(t); err != nil {
+				errs = append(errs, errors.Annotatef(err, "unable to save tombstone for object %s", t.GetLink()))
+			}
+		}
+		if err = p.applyTombstonePolicy(tombstones); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	err = p.walkCollection(vocab.Following.IRI(actor), func(followed vocab.Item) error {
+		removeFrom(vocab.Followers.IRI(followed), actor)
+		return nil
+	})
+	if err != nil && !errors.IsNotFound(err) {
+		errs = append(errs, err)
+	}
+
+	remoteFollowers := make(vocab.IRIs, 0)
+	err = p.walkCollection(vocab.Followers.IRI(actor), func(follower vocab.Item) error {
+		if p.IsLocal(follower) {
+			removeFrom(vocab.Following.IRI(follower), actor)
+			return nil
+		}
+		if !remoteFollowers.Contains(follower.GetLink()) {
+			_ = remoteFollowers.Append(follower.GetLink())
+		}
+		return nil
+	})
+	if err != nil && !errors.IsNotFound(err) {
+		errs = append(errs, err)
+	}
+
+	return remoteFollowers, errors.Join(errs...)
+}
+
+// deleteActivityWithCascade processes a Delete activity, either published by a local actor or received from
+// a remote server, and if the processor has been created with the CascadeActorDeletes option, it also cascades
+// the deletion of the actors it has as an object.
+// The resulting tombstones are handled according to the processor's TombstonePolicy.
+//
+// The remote followers of the deleted local actors are added to the blind recipients of the Delete activity,
+// so the outbox delivery reaches them after the actors' followers collections have been removed.
+func (p *P) deleteActivityWithCascade(act *vocab.Activity) (*vocab.Activity, error) {
+	// NOTE(marius): only the Delete activities of local actors can cascade to local actors
+	if p.cascadeDeletes && p.IsLocalIRI(act.GetLink()) {
+		// NOTE(marius): the cascade needs to happen before the Delete, because the actor's collections get removed
+		// together with the actor.
+		errs := make([]error, 0)
+		for _, actor := range p.localActorsFromItem(act.Object) {
+			followers, err := p.cascadeActorDelete(actor)
+			if err != nil {
+				errs = append(errs, err)
+			}
+			for _, follower := range followers {
+				if !act.BCC.Contains(follower) {
+					_ = act.BCC.Append(follower)
+				}
+			}
+		}
+		if len(errs) > 0 {
+			p.l.Warnf("unable to cascade the deletion of actors: %s", errors.Join(errs...))
+		}
+	}
+	if p.cascadeDeletes && !p.IsLocalIRI(act.GetLink()) {
+		p.cascadeRemoteActorDelete(act)
+	}
+
+	deleted := make(vocab.ItemCollection, 0)
+	if p.quotaPolicy != nil || p.blobs != nil {
+		_ = vocab.OnItem(act.Object, func(ob vocab.Item) error {
+			if full := p.loadLocalCopy(ob); !vocab.IsIRI(full) {
+				deleted = append(deleted, full)
+			}
+			return nil
+		})
+	}
+
+	act, err := DeleteActivity(p.s, act)
+	if err != nil {
+		return act, err
+	}
+	if !vocab.IsNil(act.Actor) {
+		p.releaseDeletedQuota(act.Actor.GetLink(), deleted, act.Object)
+	}
+	// NOTE(marius): the blobs are removed after the quota was released, as the size of the media is read from them
+	p.deleteMediaBlobs(deleted...)
+	if err = p.applyTombstonePolicy(act.Object); err != nil {
+		p.l.Warnf("unable to apply tombstone policy: %s", err)
+	}
+	return act, nil
+}
+
+// cascadeRemoteActorDelete removes the remote actors deleted by the received "del" activity from the followers
+// and following collections of its local recipients.
+//
+// NOTE(marius): we don't keep an index of the local actors that a remote actor interacted with, so only
+// the local actors the activity is addressed to directly are updated.
+func (p *P) cascadeRemoteActorDelete(del *vocab.Activity) {
+	deleted := make(vocab.ItemCollection, 0)
+	_ = vocab.OnItem(del.Object, func(ob vocab.Item) error {
+		// NOTE(marius): remote actors can only delete themselves
+		if !vocab.IsNil(del.Actor) && ob.GetLink().Equal(del.Actor.GetLink()) && !p.IsLocal(ob) {
+			deleted = append(deleted, ob.GetLink())
+		}
+		return nil
+	})
+	if len(deleted) == 0 {
+		return
+	}
+
+	errs := make([]error, 0)
+	for _, rec := range del.Recipients() {
+		if !p.IsLocal(rec) {
+			continue
+		}
+		owner := rec.GetLink()
+		if o, col := vocab.Split(owner); col == vocab.Inbox {
+			owner = o
+		}
+		for _, actor := range p.localActorsFromItem(owner) {
+			for _, col := range []vocab.IRI{vocab.Followers.IRI(actor), vocab.Following.IRI(actor)} {
+				if err := p.s.RemoveFrom(col, deleted...); err != nil && !errors.IsNotFound(err) {
+					errs = append(errs, errors.Annotatef(err, "unable to remove from collection %s", col))
+				}
+			}
+		}
+	}
+	if len(errs) > 0 {
+		p.l.Warnf("unable to cascade the deletion of remote actors: %s", errors.Join(errs...))
+	}
+}
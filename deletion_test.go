@@ -0,0 +1,214 @@
+package processing
+
+import (
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+)
+
+func Test_isAuthoredBy(t *testing.T) {
+	actor := vocab.IRI("https://example.com/~jdoe")
+	tests := []struct {
+		name  string
+		it    vocab.Item
+		actor vocab.Item
+		want  bool
+	}{
+		{
+			name:  "empty",
+			it:    nil,
+			actor: actor,
+			want:  false,
+		},
+		{
+			name:  "no attributedTo",
+			it:    &vocab.Object{ID: "https://example.com/1", Type: vocab.NoteType},
+			actor: actor,
+			want:  false,
+		},
+		{
+			name:  "attributed to actor",
+			it:    &vocab.Object{ID: "https://example.com/1", Type: vocab.NoteType, AttributedTo: actor},
+			actor: &vocab.Actor{ID: actor, Type: vocab.PersonType},
+			want:  true,
+		},
+		{
+			name: "attributed to multiple actors",
+			it: &vocab.Object{
+				ID:           "https://example.com/1",
+				Type:         vocab.NoteType,
+				AttributedTo: vocab.ItemCollection{vocab.IRI("https://example.com/~alice"), actor},
+			},
+			actor: actor,
+			want:  true,
+		},
+		{
+			name:  "attributed to other actor",
+			it:    &vocab.Object{ID: "https://example.com/1", Type: vocab.NoteType, AttributedTo: vocab.IRI("https://example.com/~alice")},
+			actor: actor,
+			want:  false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isAuthoredBy(tt.it, tt.actor); got != tt.want {
+				t.Errorf("isAuthoredBy() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// testCascadeActors saves a local actor with a note replying to a local object, a Like of that object, a local
+// actor it follows, a local follower and a remote follower.
+func testCascadeActors(t *testing.T, p *P) (*vocab.Actor, *vocab.Object, vocab.Item, vocab.Item) {
+	newActor := func(iri vocab.IRI) *vocab.Actor {
+		a := &vocab.Actor{
+			ID:        iri,
+			Type:      vocab.PersonType,
+			Inbox:     vocab.Inbox.IRI(iri),
+			Outbox:    vocab.Outbox.IRI(iri),
+			Followers: vocab.Followers.IRI(iri),
+			Following: vocab.Following.IRI(iri),
+		}
+		if _, err := p.s.Save(a); err != nil {
+			t.Fatalf("unable to save actor: %s", err)
+		}
+		for _, col := range []vocab.CollectionPath{vocab.Inbox, vocab.Outbox, vocab.Followers, vocab.Following} {
+			if _, err := p.s.Create(emptyCol(col.IRI(a))); err != nil {
+				t.Fatalf("unable to create collection: %s", err)
+			}
+		}
+		return a
+	}
+	addTo := func(col vocab.IRI, it vocab.Item) {
+		if err := p.s.AddTo(col, it); err != nil {
+			t.Fatalf("unable to add %s to %s: %s", it.GetLink(), col, err)
+		}
+	}
+
+	jane := newActor(defaultActorID + "/~jane")
+	alice := newActor(defaultActorID + "/~alice")
+	bob := newActor(defaultActorID + "/~bob")
+	remote := vocab.IRI("https://remote.example.com/~jdoe")
+
+	parent := &vocab.Object{ID: defaultActorID + "/objects/parent", Type: vocab.NoteType, AttributedTo: alice.ID}
+	note := &vocab.Object{ID: defaultActorID + "/objects/note", Type: vocab.NoteType, AttributedTo: jane.ID, InReplyTo: parent.ID}
+	create := &vocab.Activity{ID: defaultActorID + "/activities/create", Type: vocab.CreateType, Actor: jane.ID, Object: note.ID}
+	like := &vocab.Activity{ID: defaultActorID + "/activities/like", Type: vocab.LikeType, Actor: jane.ID, Object: parent.ID}
+	for _, it := range []vocab.Item{parent, note, create, like} {
+		if _, err := p.s.Save(it); err != nil {
+			t.Fatalf("unable to save %s: %s", it.GetLink(), err)
+		}
+	}
+	for _, col := range []vocab.IRI{vocab.Replies.IRI(parent), vocab.Likes.IRI(parent)} {
+		if _, err := p.s.Create(emptyCol(col)); err != nil {
+			t.Fatalf("unable to create collection: %s", err)
+		}
+	}
+	addTo(vocab.Replies.IRI(parent), note.ID)
+	addTo(vocab.Likes.IRI(parent), like.ID)
+	addTo(vocab.Outbox.IRI(jane), create.ID)
+	addTo(vocab.Outbox.IRI(jane), like.ID)
+	addTo(vocab.Following.IRI(jane), alice.ID)
+	addTo(vocab.Followers.IRI(alice), jane.ID)
+	addTo(vocab.Followers.IRI(jane), bob.ID)
+	addTo(vocab.Following.IRI(bob), jane.ID)
+	addTo(vocab.Followers.IRI(jane), remote)
+
+	return jane, parent, alice, bob
+}
+
+func testCollectionContains(p *P, col vocab.IRI, it vocab.Item) bool {
+	found := false
+	_ = p.walkCollection(col, func(el vocab.Item) error {
+		found = found || el.GetLink().Equal(it.GetLink())
+		return nil
+	})
+	return found
+}
+
+func TestP_cascadeActorDelete(t *testing.T) {
+	p := mockProcessor(t, defaultActorID)
+	jane, parent, alice, bob := testCascadeActors(t, p)
+
+	followers, err := p.cascadeActorDelete(jane)
+	if err != nil {
+		t.Fatalf("cascadeActorDelete() error = %s", err)
+	}
+	if want := vocab.IRI("https://remote.example.com/~jdoe"); len(followers) != 1 || !followers[0].Equal(want) {
+		t.Errorf("cascadeActorDelete() remote followers = %v, want %s", followers, want)
+	}
+
+	note, err := p.s.Load(defaultActorID + "/objects/note")
+	if err != nil || !vocab.TombstoneType.Match(firstOrItem(note).GetType()) {
+		t.Errorf("cascadeActorDelete() note = %v, %v, expected a tombstone", note, err)
+	}
+	if testCollectionContains(p, vocab.Replies.IRI(parent), note) {
+		t.Errorf("cascadeActorDelete() note is still in the replies of %s", parent.ID)
+	}
+	if testCollectionContains(p, vocab.Likes.IRI(parent), vocab.IRI(defaultActorID+"/activities/like")) {
+		t.Errorf("cascadeActorDelete() like is still in the likes of %s", parent.ID)
+	}
+	if testCollectionContains(p, vocab.Followers.IRI(alice), jane) {
+		t.Errorf("cascadeActorDelete() actor is still in the followers of %s", alice.GetLink())
+	}
+	if testCollectionContains(p, vocab.Following.IRI(bob), jane) {
+		t.Errorf("cascadeActorDelete() actor is still in the following of %s", bob.GetLink())
+	}
+}
+
+func TestP_deleteActivityWithCascade(t *testing.T) {
+	p := mockProcessor(t, defaultActorID)
+	p.cascadeDeletes = true
+	jane, _, _, _ := testCascadeActors(t, p)
+
+	del := &vocab.Activity{
+		ID:     defaultActorID + "/activities/delete",
+		Type:   vocab.DeleteType,
+		Actor:  jane.ID,
+		To:     vocab.ItemCollection{vocab.PublicNS},
+		CC:     vocab.ItemCollection{vocab.Followers.IRI(jane)},
+		Object: jane.ID,
+	}
+	del, err := p.deleteActivityWithCascade(del)
+	if err != nil {
+		t.Fatalf("deleteActivityWithCascade() error = %s", err)
+	}
+	if !del.BCC.Contains(vocab.IRI("https://remote.example.com/~jdoe")) {
+		t.Errorf("deleteActivityWithCascade() blind recipients = %v, expected the remote follower", del.BCC)
+	}
+	if it, err := p.s.Load(jane.ID); err != nil || !vocab.TombstoneType.Match(firstOrItem(it).GetType()) {
+		t.Errorf("deleteActivityWithCascade() actor = %v, %v, expected a tombstone", it, err)
+	}
+	for _, col := range []vocab.CollectionPath{vocab.Inbox, vocab.Outbox, vocab.Followers, vocab.Following} {
+		if it, err := p.s.Load(col.IRI(jane)); err == nil {
+			t.Errorf("deleteActivityWithCascade() collection %s was not removed: %v", col.IRI(jane), it)
+		}
+	}
+}
+
+func TestP_cascadeRemoteActorDelete(t *testing.T) {
+	p := mockProcessor(t, defaultActorID)
+	_, _, alice, _ := testCascadeActors(t, p)
+
+	remote := vocab.IRI("https://remote.example.com/~jdoe")
+	if err := p.s.AddTo(vocab.Followers.IRI(alice), remote); err != nil {
+		t.Fatalf("unable to add follower: %s", err)
+	}
+	if err := p.s.AddTo(vocab.Following.IRI(alice), remote); err != nil {
+		t.Fatalf("unable to add following: %s", err)
+	}
+
+	p.cascadeRemoteActorDelete(&vocab.Activity{
+		ID:     remote + "#delete",
+		Type:   vocab.DeleteType,
+		Actor:  remote,
+		To:     vocab.ItemCollection{vocab.Inbox.IRI(alice)},
+		Object: remote,
+	})
+	for _, col := range []vocab.IRI{vocab.Followers.IRI(alice), vocab.Following.IRI(alice)} {
+		if testCollectionContains(p, col, remote) {
+			t.Errorf("cascadeRemoteActorDelete() remote actor is still in %s", col)
+		}
+	}
+}
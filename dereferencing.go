@@ -2,6 +2,10 @@ package processing
 
 import (
 	"context"
+	"crypto"
+	"io"
+	"net/http"
+	"strings"
 
 	"git.sr.ht/~mariusor/lw"
 	vocab "github.com/go-ap/activitypub"
@@ -17,7 +21,7 @@ func (p P) dereferenceIRI(iri vocab.IRI) (maybeFull vocab.Item, err error) {
 	if err != nil {
 		err = errors.Annotatef(err, "unable to load IRI from local storage")
 	}
-	if !p.IsLocalIRI(iri) && vocab.IsNil(maybeFull) {
+	if !p.IsLocalIRI(iri) && vocab.IsNil(maybeFull) && !p.IsBlockedDomain(iri) {
 		if maybeFull, err = p.c.CtxLoadIRI(context.TODO(), iri); err != nil {
 			err = errors.Annotatef(err, "unable to fetch remote IRI")
 		}
@@ -94,3 +98,255 @@ func (p P) DereferenceItem(it vocab.Item) (vocab.Item, error) {
 	}
 	return firstOrItem(toKeep), nil
 }
+
+// maxCollectionPages is the maximum number of pages that walkCollection iterates through.
+const maxCollectionPages = 1000
+
+// nextCollectionPage returns the IRI of the page that follows "it" in a paginated collection.
+// For a collection that has no items of its own, this is the IRI of its first page.
+func nextCollectionPage(it vocab.Item) vocab.IRI {
+	var next vocab.IRI
+	typ := it.GetType()
+	switch {
+	case vocab.ActivityVocabularyTypes{vocab.CollectionPageType, vocab.OrderedCollectionPageType}.Match(typ):
+		_ = vocab.OnCollectionPage(it, func(p *vocab.CollectionPage) error {
+			if p.Next != nil {
+				next = p.Next.GetLink()
+			}
+			return nil
+		})
+	case vocab.ActivityVocabularyTypes{vocab.CollectionType, vocab.OrderedCollectionType}.Match(typ):
+		_ = vocab.OnCollection(it, func(p *vocab.Collection) error {
+			if p.First != nil && p.TotalItems > 0 && len(p.Items) == 0 {
+				next = p.First.GetLink()
+			}
+			return nil
+		})
+	}
+	return next
+}
+
+// walkCollection loads the collection found at "iri" from storage and calls "fn" for each of its items.
+// If the storage returns a paginated result, the following pages are loaded and iterated too.
+func (p P) walkCollection(iri vocab.IRI, fn func(vocab.Item) error) error {
+	visited := make(vocab.IRIs, 0)
+	for page := 0; len(iri) > 0 && page < maxCollectionPages; page++ {
+		if visited.Contains(iri) {
+			break
+		}
+		_ = visited.Append(iri)
+
+		col, err := p.s.Load(iri)
+		if err != nil {
+			return errors.Annotatef(err, "unable to load collection %s", iri)
+		}
+		err = vocab.OnCollectionIntf(col, func(c vocab.CollectionInterface) error {
+			for _, it := range c.Collection() {
+				if err := fn(it); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		iri = nextCollectionPage(col)
+	}
+	return nil
+}
+
+// loadLocalCopy returns the stored representation of "it" if "it" is an IRI, without trying to fetch it
+// from a remote server.
+func (p P) loadLocalCopy(it vocab.Item) vocab.Item {
+	if !vocab.IsIRI(it) {
+		return it
+	}
+	if full, err := p.s.Load(it.GetLink()); err == nil && !vocab.IsNil(full) {
+		return firstOrItem(full)
+	}
+	return it
+}
+
+// dereferenceKeyOwner loads the actor that owns the "keyID" public key, together with the parsed key.
+// The actor is loaded from storage, and if missing, it is fetched using the processor's client and saved to storage.
+// When "refresh" is true, remote actors are always fetched.
+// It returns true if the actor was loaded from storage.
+//
+// The owner of a remote key is found by fetching the document of the key, which is either the actor document, for
+// the key IDs with a fragment, or a standalone key document with an "owner", or "controller", property, like the
+// ones of GoToSocial. The actor is accepted only if the key is their public key. Otherwise, a server could present
+// the key of one of its actors as belonging to any other actor, including the local ones.
+func (p *P) dereferenceKeyOwner(keyID vocab.IRI, refresh bool) (*vocab.Actor, crypto.PublicKey, bool, error) {
+	var it vocab.Item
+	var err error
+	fromStorage := false
+	if !refresh || p.IsLocalIRI(keyID) {
+		if it = p.loadKeyOwner(keyID); !vocab.IsNil(it) {
+			fromStorage = true
+		}
+	}
+	if !fromStorage {
+		if p.IsLocalIRI(keyID) {
+			return nil, nil, false, errors.NotFoundf("unable to find the local actor of key %s", keyID)
+		}
+		if p.IsBlockedDomain(keyID) {
+			return nil, nil, false, errors.Forbiddenf("federation with the domain of %s is not allowed", keyID)
+		}
+		if it, err = p.fetchKeyOwner(context.TODO(), keyID); err != nil {
+			return nil, nil, false, err
+		}
+	}
+	if vocab.IsNil(it) || !vocab.ActorTypes.Match(it.GetType()) {
+		return nil, nil, fromStorage, errors.NotFoundf("unable to find actor for key %s", keyID)
+	}
+
+	var actor *vocab.Actor
+	if err = vocab.OnActor(it, func(a *vocab.Actor) error {
+		actor = a
+		return nil
+	}); err != nil {
+		return nil, nil, fromStorage, err
+	}
+	if !fromStorage && p.IsLocalIRI(actor.ID) {
+		return nil, nil, fromStorage, errors.Forbiddenf("remote document claims the ID of local actor %s", actor.ID)
+	}
+	if !actor.PublicKey.ID.Equal(keyID) {
+		return nil, nil, fromStorage, errors.Newf("key %s does not belong to actor %s", keyID, actor.ID)
+	}
+	if owner := actor.PublicKey.Owner; len(owner) > 0 && !owner.Equal(actor.ID) {
+		return nil, nil, fromStorage, errors.Newf("key %s is owned by %s instead of actor %s", keyID, owner, actor.ID)
+	}
+	pub, err := parsePublicKeyPEM(actor.PublicKey.PublicKeyPem)
+	if err != nil {
+		return nil, nil, fromStorage, err
+	}
+
+	if !fromStorage {
+		// NOTE(marius): we cache the remote actor, so we don't need to fetch it for each of their requests
+		if _, err = p.s.Save(actor); err != nil {
+			p.l.Warnf("unable to save remote actor %s: %s", actor.ID, err)
+		}
+	}
+	return actor, pub, fromStorage, nil
+}
+
+// keyDocumentIRI returns the IRI of the document of the "keyID" key, which is the key ID without its fragment.
+func keyDocumentIRI(keyID vocab.IRI) vocab.IRI {
+	u, err := keyID.URL()
+	if err != nil {
+		return keyID
+	}
+	u.Fragment = ""
+	return vocab.IRI(u.String())
+}
+
+// loadKeyOwner returns the stored actor whose public key is "keyID", if there is one.
+// The candidates are the document of the key, for keys embedded in the actor documents, and the parent
+// of the key's IRI, for keys with their own path under the actor, like "https://example.com/users/jdoe/main-key".
+func (p *P) loadKeyOwner(keyID vocab.IRI) vocab.Item {
+	candidates := vocab.IRIs{keyDocumentIRI(keyID)}
+	if u, err := keyID.URL(); err == nil && len(u.Fragment) == 0 {
+		if i := strings.LastIndex(strings.TrimSuffix(u.Path, "/"), "/"); i > 0 {
+			u.Path = u.Path[:i]
+			u.RawPath = ""
+			_ = candidates.Append(vocab.IRI(u.String()))
+		}
+	}
+	for _, iri := range candidates {
+		it, err := p.s.Load(iri)
+		if err != nil || vocab.IsNil(it) {
+			continue
+		}
+		var owner vocab.Item
+		_ = vocab.OnActor(firstOrItem(it), func(a *vocab.Actor) error {
+			if a.PublicKey.ID.Equal(keyID) {
+				owner = a
+			}
+			return nil
+		})
+		if !vocab.IsNil(owner) {
+			return owner
+		}
+	}
+	return nil
+}
+
+// fetchKeyOwner fetches the remote actor which owns the "keyID" public key.
+// The document of the key is fetched first: when it is an actor, it is returned, otherwise the actor in its "owner",
+// or "controller", property gets fetched.
+//
+// NOTE(marius): when the processor's client can't return the raw documents, only the keys which are embedded
+// in the actor documents can be loaded.
+func (p *P) fetchKeyOwner(ctx context.Context, keyID vocab.IRI) (vocab.Item, error) {
+	docIRI := keyDocumentIRI(keyID)
+	fetcher, ok := p.c.(rawFetcher)
+	if !ok {
+		it, err := p.c.CtxLoadIRI(ctx, docIRI)
+		if err != nil {
+			return nil, errors.Annotatef(err, "unable to fetch remote actor %s", docIRI)
+		}
+		return it, nil
+	}
+
+	resp, err := fetcher.CtxGet(ctx, docIRI.String())
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to fetch key %s", keyID)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.NotFoundf("unable to fetch key %s: %s", keyID, resp.Status)
+	}
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, p.maxRequestBodySize()))
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to fetch key %s", keyID)
+	}
+	doc, err := decodeJSONDocument(raw)
+	if err != nil {
+		return nil, errors.Annotatef(err, "invalid key document %s", keyID)
+	}
+	if typ, _ := doc["type"].(string); vocab.ActorTypes.Match(vocab.ActivityVocabularyType(typ)) {
+		if id, _ := doc["id"].(string); !docIRI.Equal(vocab.IRI(id)) {
+			return nil, errors.Newf("actor %s does not match the document %s of key %s", id, docIRI, keyID)
+		}
+		return vocab.UnmarshalJSON(raw)
+	}
+
+	var owner vocab.IRI
+	for _, prop := range []string{"owner", "controller"} {
+		if iri, _ := doc[prop].(string); len(iri) > 0 {
+			owner = vocab.IRI(iri)
+			break
+		}
+	}
+	if len(owner) == 0 {
+		return nil, errors.NotFoundf("unable to find the owner of key %s", keyID)
+	}
+	if p.IsLocalIRI(owner) {
+		return nil, errors.Forbiddenf("remote key %s claims to be owned by local actor %s", keyID, owner)
+	}
+	if p.IsBlockedDomain(owner) {
+		return nil, errors.Forbiddenf("federation with the domain of %s is not allowed", owner)
+	}
+	it, err := p.c.CtxLoadIRI(ctx, owner)
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to fetch remote actor %s", owner)
+	}
+	if !vocab.IsNil(it) && !it.GetLink().Equal(owner) {
+		return nil, errors.Newf("actor %s does not match the owner %s of key %s", it.GetLink(), owner, keyID)
+	}
+	return it, nil
+}
+
+// sameOrigin checks if the "a" and "b" IRIs have the same scheme and host.
+func sameOrigin(a, b vocab.IRI) bool {
+	ua, err := a.URL()
+	if err != nil {
+		return false
+	}
+	ub, err := b.URL()
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(ua.Scheme, ub.Scheme) && strings.EqualFold(ua.Host, ub.Host)
+}
@@ -70,3 +70,24 @@ func Test_aggregateIRIs(t *testing.T) {
 		})
 	}
 }
+
+func Test_sameOrigin(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b vocab.IRI
+		want bool
+	}{
+		{name: "same host", a: "https://example.com/~jdoe", b: "https://example.com/objects/1", want: true},
+		{name: "case insensitive", a: "https://Example.com/~jdoe", b: "https://example.com", want: true},
+		{name: "different host", a: "https://example.com/~jdoe", b: "https://evil.example.com/~jdoe", want: false},
+		{name: "different port", a: "https://example.com/~jdoe", b: "https://example.com:8443/~jdoe", want: false},
+		{name: "different scheme", a: "https://example.com/~jdoe", b: "http://example.com/~jdoe", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sameOrigin(tt.a, tt.b); got != tt.want {
+				t.Errorf("sameOrigin() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
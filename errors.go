@@ -2,6 +2,8 @@ package processing
 
 import (
 	"fmt"
+	"net/http"
+	"time"
 
 	"github.com/go-ap/errors"
 )
@@ -26,3 +28,49 @@ func wrapErr(err error, s string, args ...interface{}) errors.Err {
 var ErrDuplicateObject = func(s string, p ...interface{}) errDuplicateKey {
 	return errDuplicateKey{wrapErr(nil, fmt.Sprintf("Duplicate key: %s", s), p...)}
 }
+
+// errHTTPStatus is an error which gets rendered with an HTTP status that the errors package doesn't support,
+// like 429 Too Many Requests.
+type errHTTPStatus struct {
+	errors.Err
+	status     int
+	retryAfter time.Duration
+}
+
+func asHTTPStatusErr(e error) (*errHTTPStatus, bool) {
+	err := new(errHTTPStatus)
+	ok := errors.As(e, &err)
+	return err, ok
+}
+
+// TooManyRequestsf returns an error which is rendered as a 429 Too Many Requests HTTP response,
+// with a Retry-After header for the "retryAfter" duration.
+func TooManyRequestsf(retryAfter time.Duration, s string, args ...interface{}) error {
+	return &errHTTPStatus{Err: wrapErr(nil, s, args...), status: http.StatusTooManyRequests, retryAfter: retryAfter}
+}
+
+// RequestEntityTooLargef returns an error which is rendered as a 413 Request Entity Too Large HTTP response.
+func RequestEntityTooLargef(s string, args ...interface{}) error {
+	return &errHTTPStatus{Err: wrapErr(nil, s, args...), status: http.StatusRequestEntityTooLarge}
+}
+
+// IsTooManyRequests checks if the "e" error is a 429 Too Many Requests one.
+func IsTooManyRequests(e error) bool {
+	err, ok := asHTTPStatusErr(e)
+	return ok && err.status == http.StatusTooManyRequests
+}
+
+// RetryAfter returns the duration after which the request that caused the "e" error can be retried,
+// or 0 if it doesn't have one.
+func RetryAfter(e error) time.Duration {
+	if err, ok := asHTTPStatusErr(e); ok {
+		return err.retryAfter
+	}
+	return 0
+}
+
+// IsRequestEntityTooLarge checks if the "e" error is a 413 Request Entity Too Large one.
+func IsRequestEntityTooLarge(e error) bool {
+	err, ok := asHTTPStatusErr(e)
+	return ok && err.status == http.StatusRequestEntityTooLarge
+}
@@ -0,0 +1,146 @@
+package processing
+
+import (
+	"strings"
+
+	vocab "github.com/go-ap/activitypub"
+)
+
+// DomainAction is the action the FederationPolicy applies to the content coming from a domain.
+// The actions can be combined, eg: Silence|RejectMedia.
+type DomainAction uint8
+
+const (
+	// RejectMedia removes the attachments, icons and images of the objects from the domain.
+	RejectMedia DomainAction = 1 << iota
+	// Silence accepts the content from the domain, but it is shown only to the local actors
+	// that follow its authors or that it is addressed to.
+	Silence
+	// Block rejects all the activities from the domain, and stops all delivery and fetching to it.
+	Block
+)
+
+// FederationPolicy represents the server-wide rules for federating with other servers.
+//
+// The domains can be specified either as plain host names, eg: "example.com", which match only that host,
+// or using a wildcard, eg: "*.example.com", which matches the domain and all its subdomains.
+type FederationPolicy struct {
+	// Domains contains the actions that apply to the domains.
+	Domains map[string]DomainAction
+	// AllowList, when not empty, switches the federation to allowlist mode: all the domains which don't
+	// match any of its entries are blocked.
+	AllowList []string
+}
+
+// domainMatches checks if the "host" matches the "pattern" domain.
+func domainMatches(pattern, host string) bool {
+	pattern = strings.ToLower(strings.TrimSuffix(pattern, "."))
+	if domain, ok := strings.CutPrefix(pattern, "*."); ok {
+		return host == domain || strings.HasSuffix(host, "."+domain)
+	}
+	return host == pattern
+}
+
+// iriHost returns the lowercase host name of the "iri", without the port.
+func iriHost(iri vocab.IRI) string {
+	u, err := iri.URL()
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+}
+
+// ActionFor returns the combined actions of the policy that apply to the "host" domain.
+func (fp FederationPolicy) ActionFor(host string) DomainAction {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	var action DomainAction
+	if len(fp.AllowList) > 0 {
+		allowed := false
+		for _, pattern := range fp.AllowList {
+			if allowed = domainMatches(pattern, host); allowed {
+				break
+			}
+		}
+		if !allowed {
+			action |= Block
+		}
+	}
+	for pattern, a := range fp.Domains {
+		if domainMatches(pattern, host) {
+			action |= a
+		}
+	}
+	return action
+}
+
+// domainAction returns the actions of the processor's FederationPolicy that apply to the domain of "iri".
+// Local IRIs are never affected by the policy.
+func (p P) domainAction(iri vocab.IRI) DomainAction {
+	if len(iri) == 0 || vocab.PublicNS.Equal(iri) || p.IsLocalIRI(iri) {
+		return 0
+	}
+	host := iriHost(iri)
+	if host == "" {
+		return 0
+	}
+	return p.federation.ActionFor(host)
+}
+
+// IsBlockedDomain checks if the domain of "iri" is blocked by the processor's FederationPolicy.
+func (p P) IsBlockedDomain(iri vocab.IRI) bool {
+	return p.domainAction(iri)&Block == Block
+}
+
+// IsSilencedDomain checks if the domain of "iri" is silenced by the processor's FederationPolicy.
+func (p P) IsSilencedDomain(iri vocab.IRI) bool {
+	return p.domainAction(iri)&Silence == Silence
+}
+
+// filterBlockedDomains returns the IRIs from "iris" whose domains are not blocked.
+func (p P) filterBlockedDomains(iris vocab.IRIs) vocab.IRIs {
+	allowed := make(vocab.IRIs, 0, len(iris))
+	for _, iri := range iris {
+		if p.IsBlockedDomain(iri) {
+			p.l.Debugf("Skipping %s which belongs to a blocked domain", iri)
+			continue
+		}
+		allowed = append(allowed, iri)
+	}
+	return allowed
+}
+
+// hasBlockedDomain checks if any of the IRIs of "it", which can be an item or a collection, belongs to
+// a blocked domain.
+func (p P) hasBlockedDomain(it vocab.Item) bool {
+	blocked := false
+	_ = vocab.OnItem(it, func(it vocab.Item) error {
+		blocked = blocked || p.IsBlockedDomain(it.GetLink())
+		return nil
+	})
+	return blocked
+}
+
+// rejectMedia removes the media from the objects embedded in the "it" activity, which belong to domains
+// for which the processor's FederationPolicy has the RejectMedia action.
+func (p P) rejectMedia(it vocab.Item) {
+	strip := func(it vocab.Item) error {
+		if vocab.IsIRI(it) || p.domainAction(it.GetLink())&RejectMedia != RejectMedia {
+			return nil
+		}
+		return vocab.OnObject(it, func(ob *vocab.Object) error {
+			ob.Attachment = nil
+			ob.Icon = nil
+			ob.Image = nil
+			return nil
+		})
+	}
+	_ = vocab.OnIntransitiveActivity(it, func(act *vocab.IntransitiveActivity) error {
+		_ = vocab.OnItem(act.Actor, strip)
+		return nil
+	})
+	if vocab.ActivityTypes.Match(it.GetType()) {
+		_ = vocab.OnActivity(it, func(act *vocab.Activity) error {
+			return vocab.OnItem(act.Object, strip)
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package processing
+
+import (
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+)
+
+func Test_domainMatches(t *testing.T) {
+	tests := []struct {
+		pattern string
+		host    string
+		want    bool
+	}{
+		{pattern: "example.com", host: "example.com", want: true},
+		{pattern: "Example.com.", host: "example.com", want: true},
+		{pattern: "example.com", host: "social.example.com", want: false},
+		{pattern: "*.example.com", host: "example.com", want: true},
+		{pattern: "*.example.com", host: "social.example.com", want: true},
+		{pattern: "*.example.com", host: "a.b.example.com", want: true},
+		{pattern: "*.example.com", host: "badexample.com", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.host, func(t *testing.T) {
+			if got := domainMatches(tt.pattern, tt.host); got != tt.want {
+				t.Errorf("domainMatches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFederationPolicy_ActionFor(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy FederationPolicy
+		host   string
+		want   DomainAction
+	}{
+		{
+			name: "empty policy",
+			host: "example.com",
+		},
+		{
+			name:   "blocked domain",
+			policy: FederationPolicy{Domains: map[string]DomainAction{"*.example.com": Block}},
+			host:   "social.example.com",
+			want:   Block,
+		},
+		{
+			name: "combined actions",
+			policy: FederationPolicy{Domains: map[string]DomainAction{
+				"*.example.com":      Silence,
+				"social.example.com": RejectMedia,
+			}},
+			host: "social.example.com",
+			want: Silence | RejectMedia,
+		},
+		{
+			name:   "allowed by the allowlist",
+			policy: FederationPolicy{AllowList: []string{"*.example.com"}},
+			host:   "social.example.com",
+		},
+		{
+			name:   "missing from the allowlist",
+			policy: FederationPolicy{AllowList: []string{"*.example.com"}},
+			host:   "example.org",
+			want:   Block,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.ActionFor(tt.host); got != tt.want {
+				t.Errorf("ActionFor() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestP_filterBlockedDomains(t *testing.T) {
+	p := mockProcessor(t, defaultActorID)
+	// NOTE(marius): the allowlist doesn't contain the local domain, which is never affected by the policy
+	p.federation = FederationPolicy{
+		Domains:   map[string]DomainAction{"blocked.example.org": Block},
+		AllowList: []string{"*.example.org"},
+	}
+
+	iris := vocab.IRIs{
+		defaultActorID + "/inbox",
+		"https://social.example.org/inbox",
+		"https://blocked.example.org/inbox",
+		"https://example.net/inbox",
+	}
+	want := vocab.IRIs{
+		defaultActorID + "/inbox",
+		"https://social.example.org/inbox",
+	}
+	got := p.filterBlockedDomains(iris)
+	if len(got) != len(want) {
+		t.Fatalf("filterBlockedDomains() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if !got[i].Equal(want[i]) {
+			t.Errorf("filterBlockedDomains() = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestP_rejectMedia(t *testing.T) {
+	p := mockProcessor(t, defaultActorID)
+	p.federation = FederationPolicy{Domains: map[string]DomainAction{"media.example.org": RejectMedia}}
+
+	rejected := &vocab.Object{
+		ID:         "https://media.example.org/objects/1",
+		Type:       vocab.NoteType,
+		Attachment: &vocab.Object{Type: vocab.ImageType, URL: vocab.IRI("https://media.example.org/1.png")},
+	}
+	accepted := &vocab.Object{
+		ID:         "https://social.example.org/objects/1",
+		Type:       vocab.NoteType,
+		Attachment: &vocab.Object{Type: vocab.ImageType, URL: vocab.IRI("https://social.example.org/1.png")},
+	}
+	p.rejectMedia(&vocab.Activity{Type: vocab.AnnounceType, Object: vocab.ItemCollection{rejected, accepted}})
+
+	if rejected.Attachment != nil {
+		t.Errorf("rejectMedia() did not remove the attachment of %s", rejected.ID)
+	}
+	if accepted.Attachment == nil {
+		t.Errorf("rejectMedia() removed the attachment of %s", accepted.ID)
+	}
+}
@@ -2,6 +2,9 @@ package processing
 
 import (
 	"fmt"
+	"io"
+	"math"
+	"mime"
 	"net/http"
 	"slices"
 	"strconv"
@@ -83,6 +86,48 @@ func reqIRI(r *http.Request) vocab.IRI {
 	return vocab.IRI(fmt.Sprintf("%s://%s%s", proto, r.Host, r.RequestURI))
 }
 
+// handleError returns an HTTP handler which renders the "err" error.
+// Besides what errors.HandleError does, it supports the statuses that the errors package doesn't know about,
+// and sets the Retry-After header for the rate limiting errors.
+func handleError(err error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if mbe := new(http.MaxBytesError); errors.As(err, &mbe) {
+			err = RequestEntityTooLargef("request body exceeds maximum size of %d bytes", mbe.Limit)
+		}
+		se, ok := asHTTPStatusErr(err)
+		if !ok {
+			errors.HandleError(err).ServeHTTP(w, r)
+			return
+		}
+		if se.retryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.FormatInt(int64(math.Ceil(se.retryAfter.Seconds())), 10))
+		}
+		dat := renderHTTPStatusErr(r, err, se.status)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(se.status)
+		_, _ = w.Write(dat)
+	}
+}
+
+// renderHTTPStatusErr outputs the "err" error the same way errors.RenderErrors does, but with the "status" code,
+// which errors.RenderErrors doesn't know about, and would render as 500.
+func renderHTTPStatusErr(r *http.Request, err error, status int) []byte {
+	errs := errors.HttpErrors(err)
+	for i := range errs {
+		errs[i].Code = status
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	ctx := json.Context{{Term: "errors", IRI: json.IRI(fmt.Sprintf("%s://%s/ns#errors", scheme, r.Host))}}
+	m := struct {
+		Errors []errors.Http `jsonld:"errors"`
+	}{Errors: errs}
+	dat, _ := json.WithContext(ctx).Marshal(m)
+	return dat
+}
+
 // ServeHTTP implements the http.Handler interface for the ActivityHandlerFn type
 func (a ActivityHandlerFn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	var dat []byte
@@ -91,13 +136,13 @@ func (a ActivityHandlerFn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	var status = http.StatusInternalServerError
 
 	if status, err = a.ValidateRequest(r); err != nil {
-		errors.HandleError(err).ServeHTTP(w, r)
+		handleError(err).ServeHTTP(w, r)
 		return
 	}
 
 	receivedIn := reqIRI(r)
 	if it, status, err = a(receivedIn, r); err != nil {
-		errors.HandleError(err).ServeHTTP(w, r)
+		handleError(err).ServeHTTP(w, r)
 		return
 	}
 	needsLocation := slices.Contains([]int{http.StatusNoContent, http.StatusGone}, status)
@@ -115,7 +160,7 @@ func (a ActivityHandlerFn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			return nil
 		})
 		if err != nil {
-			errors.HandleError(err).ServeHTTP(w, r)
+			handleError(err).ServeHTTP(w, r)
 			return
 		}
 	}
@@ -137,6 +182,254 @@ func (a ActivityHandlerFn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// MediaUploadHandlerFn is the type that we're using to represent handlers that process requests to
+// the uploadMedia endpoint of an actor. It needs to implement the http.Handler interface.
+//
+// https://www.w3.org/wiki/SocialCG/ActivityPub/MediaUpload
+//
+// The request is a multipart/form-data one, containing the "file" to be uploaded and the ActivityStreams "object"
+// that describes it. Following the execution of the handler, we return the created object together with
+// an HTTP status, or an error.
+type MediaUploadHandlerFn func(vocab.IRI, vocab.Item, MediaUpload, *http.Request) (vocab.Item, int, error)
+
+// maxMediaUploadMemory is the maximum size of the uploaded file that is kept in memory while parsing the request.
+const maxMediaUploadMemory = 32 << 20
+
+// MaxMediaUploadSize is the maximum size in bytes of the media upload requests.
+// The uploaded files are further limited by the MediaSizeLimits of the processor.
+const MaxMediaUploadSize int64 = 100 << 20
+
+// ValidMethod validates if the current handler can process the current request
+func (m MediaUploadHandlerFn) ValidMethod(r *http.Request) bool {
+	return r.Method == http.MethodPost
+}
+
+// ValidateRequest validates if the current handler can process the current request
+func (m MediaUploadHandlerFn) ValidateRequest(r *http.Request) (int, error) {
+	if !m.ValidMethod(r) {
+		return http.StatusMethodNotAllowed, errors.MethodNotAllowedf("Invalid HTTP method %s", r.Method)
+	}
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt != "multipart/form-data" {
+		return http.StatusUnsupportedMediaType, errors.UnsupportedMediaTypef("Invalid content type for media upload")
+	}
+	if r.ContentLength > MaxMediaUploadSize {
+		return http.StatusRequestEntityTooLarge, RequestEntityTooLargef("media upload exceeds maximum size of %d bytes", MaxMediaUploadSize)
+	}
+	return http.StatusOK, nil
+}
+
+func loadMediaUploadFromRequest(w http.ResponseWriter, r *http.Request) (vocab.Item, MediaUpload, error) {
+	upload := MediaUpload{}
+	r.Body = http.MaxBytesReader(w, r.Body, MaxMediaUploadSize)
+	if err := r.ParseMultipartForm(maxMediaUploadMemory); err != nil {
+		return nil, upload, errors.NewBadRequest(err, "unable to parse media upload request")
+	}
+	// NOTE(marius): the files which don't fit in memory are saved in temporary files by ParseMultipartForm
+	defer r.MultipartForm.RemoveAll()
+
+	raw := r.FormValue("object")
+	if len(raw) == 0 {
+		return nil, upload, errors.BadRequestf("missing object in media upload request")
+	}
+	ob, err := vocab.UnmarshalJSON([]byte(raw))
+	if err != nil {
+		return nil, upload, errors.NewBadRequest(err, "unable to unmarshal object in media upload request")
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		return ob, upload, errors.NewBadRequest(err, "missing file in media upload request")
+	}
+	defer file.Close()
+
+	if header.Size > MaxMediaUploadSize {
+		return ob, upload, RequestEntityTooLargef("%s exceeds maximum size of %d bytes", header.Filename, MaxMediaUploadSize)
+	}
+	if upload.Data, err = io.ReadAll(io.LimitReader(file, header.Size)); err != nil {
+		return ob, upload, errors.NewBadRequest(err, "unable to read file in media upload request")
+	}
+	upload.Name = header.Filename
+	upload.MediaType = vocab.MimeType(header.Header.Get("Content-Type"))
+	if upload.MediaType == "" || upload.MediaType == "application/octet-stream" {
+		upload.MediaType = vocab.MimeType(http.DetectContentType(upload.Data))
+	}
+	return ob, upload, nil
+}
+
+// ServeHTTP implements the http.Handler interface for the MediaUploadHandlerFn type
+func (m MediaUploadHandlerFn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var it vocab.Item
+	var err error
+	var status = http.StatusInternalServerError
+
+	if status, err = m.ValidateRequest(r); err != nil {
+		handleError(err).ServeHTTP(w, r)
+		return
+	}
+
+	ob, upload, err := loadMediaUploadFromRequest(w, r)
+	if err != nil {
+		handleError(err).ServeHTTP(w, r)
+		return
+	}
+
+	if it, status, err = m(reqIRI(r), ob, upload, r); err != nil {
+		handleError(err).ServeHTTP(w, r)
+		return
+	}
+
+	if location := it.GetLink(); status == http.StatusCreated && len(location) > 0 {
+		w.Header().Add("Location", location.String())
+	}
+	dat, _ := vocab.MarshalJSON(it)
+	if dat != nil {
+		w.Header().Add("Content-Type", json.ContentType)
+	}
+
+	w.WriteHeader(status)
+	if dat != nil {
+		_, _ = w.Write(dat)
+	}
+}
+
+// ProxyHandlerFn is the type that we're using to represent handlers that process requests to the proxyUrl endpoint
+// of an actor. It needs to implement the http.Handler interface.
+//
+// https://www.w3.org/TR/activitypub/#proxyUrl
+//
+// The request is a form-encoded POST with an "id" parameter containing the IRI of the object to be dereferenced
+// on behalf of the actor. Following the execution of the handler we return the dereferenced object, or an error.
+type ProxyHandlerFn func(vocab.IRI, *http.Request) (vocab.Item, error)
+
+// ValidMethod validates if the current handler can process the current request
+func (p ProxyHandlerFn) ValidMethod(r *http.Request) bool {
+	return r.Method == http.MethodPost
+}
+
+// ValidateRequest validates if the current handler can process the current request.
+// The proxyUrl endpoint can be used only by authenticated actors, as resolved by the HTTPSignatureVerifierMw
+// middleware, or set with ContextWithAuthor by the token authorization of the application.
+func (p ProxyHandlerFn) ValidateRequest(r *http.Request) (int, error) {
+	if !p.ValidMethod(r) {
+		return http.StatusMethodNotAllowed, errors.MethodNotAllowedf("Invalid HTTP method %s", r.Method)
+	}
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt != "application/x-www-form-urlencoded" {
+		return http.StatusUnsupportedMediaType, errors.UnsupportedMediaTypef("Invalid content type for proxy request")
+	}
+	if author, ok := AuthorFromRequest(r); !ok || vocab.PublicNS.Equal(author.ID) {
+		return http.StatusUnauthorized, errors.Unauthorizedf("proxy requests need to be authenticated")
+	}
+	return http.StatusOK, nil
+}
+
+// ServeHTTP implements the http.Handler interface for the ProxyHandlerFn type
+func (p ProxyHandlerFn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var dat []byte
+	var err error
+	status := http.StatusInternalServerError
+
+	if status, err = p.ValidateRequest(r); err != nil {
+		handleError(err).ServeHTTP(w, r)
+		return
+	}
+
+	id := vocab.IRI(r.PostFormValue("id"))
+	if len(id) == 0 {
+		handleError(errors.BadRequestf("missing id in proxy request")).ServeHTTP(w, r)
+		return
+	}
+
+	it, err := p(id, r)
+	if err != nil {
+		handleError(err).ServeHTTP(w, r)
+		return
+	}
+	if vocab.IsNil(it) {
+		handleError(errors.NotFoundf("")).ServeHTTP(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", json.ContentType)
+	if w.Header().Get("Cache-Control") == "" {
+		w.Header().Set("Cache-Control", "private, no-store")
+	}
+	if dat, err = json.WithContext(json.IRI(vocab.ActivityBaseURI), json.IRI(vocab.SecurityContextURI)).Marshal(it); err != nil {
+		handleError(err).ServeHTTP(w, r)
+		return
+	}
+
+	status = http.StatusOK
+	if vocab.TombstoneType.Match(it.GetType()) {
+		status = http.StatusGone
+	}
+	w.WriteHeader(status)
+	_, _ = w.Write(dat)
+}
+
+// BlobHandlerFn is the type that we're using to represent handlers that serve the binary content of the media
+// saved in a BlobStore, from the IRIs the store returned for it. It needs to implement the http.Handler interface.
+//
+// Following the execution of the handler we return the binary content together with its media type, or an error.
+type BlobHandlerFn func(vocab.IRI, *http.Request) ([]byte, vocab.MimeType, error)
+
+// BlobStoreHandler returns a BlobHandlerFn which serves the blobs of the "b" BlobStore.
+func BlobStoreHandler(b BlobStore) BlobHandlerFn {
+	return func(iri vocab.IRI, _ *http.Request) ([]byte, vocab.MimeType, error) {
+		return b.Get(iri)
+	}
+}
+
+// ValidMethod validates if the current handler can process the current request
+func (b BlobHandlerFn) ValidMethod(r *http.Request) bool {
+	return r.Method == http.MethodGet || r.Method == http.MethodHead
+}
+
+// ValidateRequest validates if the current handler can process the current request
+func (b BlobHandlerFn) ValidateRequest(r *http.Request) (int, error) {
+	if !b.ValidMethod(r) {
+		return http.StatusMethodNotAllowed, errors.MethodNotAllowedf("Invalid HTTP method %s", r.Method)
+	}
+	return http.StatusOK, nil
+}
+
+// ServeHTTP implements the http.Handler interface for the BlobHandlerFn type
+func (b BlobHandlerFn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if _, err := b.ValidateRequest(r); err != nil {
+		handleError(err).ServeHTTP(w, r)
+		return
+	}
+
+	iri := reqIRI(r)
+	if u, err := iri.URL(); err == nil {
+		u.RawQuery = ""
+		iri = vocab.IRI(u.String())
+	}
+	data, mediaType, err := b(iri, r)
+	if err != nil {
+		handleError(err).ServeHTTP(w, r)
+		return
+	}
+	if mediaType == "" {
+		mediaType = "application/octet-stream"
+	}
+
+	w.Header().Set("Content-Type", string(mediaType))
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	// NOTE(marius): the blobs are content uploaded by the users, which must not be able to run scripts
+	// in our origin, eg: in SVG images
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; sandbox")
+	if w.Header().Get("Cache-Control") == "" {
+		// NOTE(marius): the content of a blob never changes, new content gets saved to a new blob
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d, immutable", int(year.Seconds())))
+	}
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+	_, _ = w.Write(data)
+}
+
 // CollectionHandlerFn is the type that we're using to represent handlers that will return ActivityStreams
 // Collection or OrderedCollection objects. It needs to implement the http.Handler interface.
 type CollectionHandlerFn func(vocab.CollectionPath, *http.Request) (vocab.CollectionInterface, error)
@@ -193,7 +486,7 @@ func (c CollectionHandlerFn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", json.ContentType)
 	if w.Header().Get("Cache-Control") == "" {
 		cacheType := "public"
-		if r.Header.Get("Authorization") != "" {
+		if isAuthorizedRequest(r) {
 			cacheType = "private"
 		}
 		w.Header().Set("Cache-Control", fmt.Sprintf("%s, max-age=%d", cacheType, int(24*time.Hour.Seconds())))
@@ -218,6 +511,16 @@ func (c CollectionHandlerFn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	_, _ = w.Write(dat)
 }
 
+// isAuthorizedRequest checks if the "r" request has been made by an authenticated actor, in which case
+// the responses to it must not be stored in shared caches.
+func isAuthorizedRequest(r *http.Request) bool {
+	if r.Header.Get("Authorization") != "" || r.Header.Get("Signature") != "" {
+		return true
+	}
+	_, ok := AuthorFromRequest(r)
+	return ok
+}
+
 // ItemHandlerFn is the type that we're using to represent handlers that return ActivityStreams
 // objects. It needs to implement the http.Handler interface
 type ItemHandlerFn func(*http.Request) (vocab.Item, error)
@@ -300,7 +603,7 @@ func (i ItemHandlerFn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 		if w.Header().Get("Cache-Control") == "" {
 			cacheType := "public"
-			if r.Header.Get("Authorization") != "" {
+			if isAuthorizedRequest(r) {
 				cacheType = "private"
 			}
 			allActivities := append(vocab.IntransitiveActivityTypes, vocab.ActivityTypes...)
@@ -1,6 +1,19 @@
 package processing
 
-import "testing"
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
 
 func TestActivityHandlerFn_ServeHTTP(t *testing.T) {
 	t.Skipf("TODO")
@@ -49,3 +62,260 @@ func TestCollectionHandlerFn_Storage(t *testing.T) {
 func TestItemHandlerFn_Storage(t *testing.T) {
 	t.Skipf("TODO")
 }
+
+func TestMediaUploadHandlerFn_ServeHTTP(t *testing.T) {
+	body := bytes.Buffer{}
+	mw := multipart.NewWriter(&body)
+	_ = mw.WriteField("object", `{"type":"Image","name":"test"}`)
+	fw, _ := mw.CreateFormFile("file", "test.png")
+	_, _ = fw.Write([]byte("\x89PNG\r\n\x1a\n"))
+	_ = mw.Close()
+
+	var gotUpload MediaUpload
+	var gotObject vocab.Item
+	handler := MediaUploadHandlerFn(func(_ vocab.IRI, ob vocab.Item, upload MediaUpload, _ *http.Request) (vocab.Item, int, error) {
+		gotObject = ob
+		gotUpload = upload
+		return &vocab.Object{ID: "https://example.com/objects/1", Type: ob.GetType()}, http.StatusCreated, nil
+	})
+
+	r := httptest.NewRequest(http.MethodPost, "https://example.com/uploadMedia", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("ServeHTTP() status = %d, want %d", w.Code, http.StatusCreated)
+	}
+	if loc := w.Header().Get("Location"); loc != "https://example.com/objects/1" {
+		t.Errorf("ServeHTTP() Location = %q, want %q", loc, "https://example.com/objects/1")
+	}
+	if vocab.IsNil(gotObject) || !vocab.ImageType.Match(gotObject.GetType()) {
+		t.Errorf("ServeHTTP() received object %v, want an Image", gotObject)
+	}
+	if gotUpload.Name != "test.png" || gotUpload.MediaType != "image/png" {
+		t.Errorf("ServeHTTP() received upload %s of type %s, want test.png of type image/png", gotUpload.Name, gotUpload.MediaType)
+	}
+}
+
+func TestMediaUploadHandlerFn_ValidateRequest(t *testing.T) {
+	tests := []struct {
+		name          string
+		method        string
+		contentType   string
+		contentLength int64
+		want          int
+	}{
+		{
+			name:        "invalid method",
+			method:      http.MethodGet,
+			contentType: "multipart/form-data; boundary=test",
+			want:        http.StatusMethodNotAllowed,
+		},
+		{
+			name:        "invalid content type",
+			method:      http.MethodPost,
+			contentType: "application/activity+json",
+			want:        http.StatusUnsupportedMediaType,
+		},
+		{
+			name:          "too large",
+			method:        http.MethodPost,
+			contentType:   "multipart/form-data; boundary=test",
+			contentLength: MaxMediaUploadSize + 1,
+			want:          http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:          "valid",
+			method:        http.MethodPost,
+			contentType:   "multipart/form-data; boundary=test",
+			contentLength: 1024,
+			want:          http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "https://example.com/uploadMedia", nil)
+			r.Header.Set("Content-Type", tt.contentType)
+			r.ContentLength = tt.contentLength
+
+			var m MediaUploadHandlerFn
+			got, err := m.ValidateRequest(r)
+			if got != tt.want {
+				t.Errorf("ValidateRequest() status = %d, want %d", got, tt.want)
+			}
+			if (err != nil) != (tt.want != http.StatusOK) {
+				t.Errorf("ValidateRequest() error = %v, for status %d", err, got)
+			}
+		})
+	}
+}
+
+func TestProxyHandlerFn_ServeHTTP(t *testing.T) {
+	remote := vocab.IRI("https://remote.example.com/objects/1")
+	handler := ProxyHandlerFn(func(iri vocab.IRI, _ *http.Request) (vocab.Item, error) {
+		if !iri.Equal(remote) {
+			t.Errorf("ProxyHandlerFn received IRI %s, want %s", iri, remote)
+		}
+		return &vocab.Object{ID: iri, Type: vocab.NoteType}, nil
+	})
+
+	form := url.Values{"id": []string{remote.String()}}
+	r := httptest.NewRequest(http.MethodPost, "https://example.com/proxy", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r = r.WithContext(ContextWithAuthor(r.Context(), *defaultActor))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("ServeHTTP() status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if !strings.Contains(w.Body.String(), remote.String()) {
+		t.Errorf("ServeHTTP() body %s doesn't contain the proxied object", w.Body.String())
+	}
+}
+
+func TestBlobHandlerFn_ServeHTTP(t *testing.T) {
+	blobs := NewFSBlobStore(t.TempDir(), "https://example.com/blobs")
+	data := []byte("\x89PNG\r\n\x1a\n")
+	iri, _, err := blobs.Put("image/png", data)
+	if err != nil {
+		t.Fatalf("Put() error = %s", err)
+	}
+	handler := BlobStoreHandler(blobs)
+
+	tests := []struct {
+		name       string
+		method     string
+		iri        vocab.IRI
+		wantStatus int
+		wantBody   []byte
+	}{
+		{name: "existing blob", method: http.MethodGet, iri: iri, wantStatus: http.StatusOK, wantBody: data},
+		{name: "HEAD", method: http.MethodHead, iri: iri, wantStatus: http.StatusOK},
+		{name: "missing blob", method: http.MethodGet, iri: "https://example.com/blobs/" + vocab.IRI(strings.Repeat("0", blobKeySize*2)), wantStatus: http.StatusNotFound},
+		{name: "invalid method", method: http.MethodPost, iri: iri, wantStatus: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(tt.method, tt.iri.String(), nil))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("ServeHTTP() status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "image/png" {
+				t.Errorf("ServeHTTP() Content-Type = %s, want image/png", ct)
+			}
+			if !bytes.Equal(w.Body.Bytes(), tt.wantBody) {
+				t.Errorf("ServeHTTP() body = %q, want %q", w.Body.Bytes(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestProxyHandlerFn_ValidateRequest(t *testing.T) {
+	tests := []struct {
+		name        string
+		method      string
+		contentType string
+		author      *vocab.Actor
+		want        int
+	}{
+		{
+			name:        "invalid method",
+			method:      http.MethodGet,
+			contentType: "application/x-www-form-urlencoded",
+			author:      defaultActor,
+			want:        http.StatusMethodNotAllowed,
+		},
+		{
+			name:        "invalid content type",
+			method:      http.MethodPost,
+			contentType: "application/activity+json",
+			author:      defaultActor,
+			want:        http.StatusUnsupportedMediaType,
+		},
+		{
+			name:        "anonymous",
+			method:      http.MethodPost,
+			contentType: "application/x-www-form-urlencoded",
+			want:        http.StatusUnauthorized,
+		},
+		{
+			name:        "public author",
+			method:      http.MethodPost,
+			contentType: "application/x-www-form-urlencoded",
+			author:      &vocab.Actor{ID: vocab.PublicNS},
+			want:        http.StatusUnauthorized,
+		},
+		{
+			name:        "authenticated",
+			method:      http.MethodPost,
+			contentType: "application/x-www-form-urlencoded; charset=utf-8",
+			author:      defaultActor,
+			want:        http.StatusOK,
+		},
+	}
+	handler := ProxyHandlerFn(func(iri vocab.IRI, _ *http.Request) (vocab.Item, error) {
+		return iri, nil
+	})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "https://example.com/proxy", strings.NewReader("id=https://remote.example.com"))
+			r.Header.Set("Content-Type", tt.contentType)
+			if tt.author != nil {
+				r = r.WithContext(ContextWithAuthor(r.Context(), *tt.author))
+			}
+			got, err := handler.ValidateRequest(r)
+			if got != tt.want {
+				t.Errorf("ValidateRequest() = %d, want %d", got, tt.want)
+			}
+			if (err != nil) != (tt.want != http.StatusOK) {
+				t.Errorf("ValidateRequest() error = %v", err)
+			}
+		})
+	}
+}
+
+func Test_handleError(t *testing.T) {
+	tests := []struct {
+		name           string
+		err            error
+		wantStatus     int
+		wantRetryAfter string
+	}{
+		{name: "not found", err: errors.NotFoundf("missing"), wantStatus: http.StatusNotFound},
+		{name: "too many requests", err: TooManyRequestsf(1500*time.Millisecond, "slow down"), wantStatus: http.StatusTooManyRequests, wantRetryAfter: "2"},
+		{name: "wrapped too many requests", err: errors.Annotatef(TooManyRequestsf(time.Minute, "slow down"), "rejected"), wantStatus: http.StatusTooManyRequests, wantRetryAfter: "60"},
+		{name: "request body too large", err: errors.Annotatef(&http.MaxBytesError{Limit: 10}, "unable to read body"), wantStatus: http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "https://example.com/inbox", nil)
+			w := httptest.NewRecorder()
+			handleError(tt.err).ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Errorf("handleError() status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get("Retry-After"); got != tt.wantRetryAfter {
+				t.Errorf("handleError() Retry-After = %q, want %q", got, tt.wantRetryAfter)
+			}
+			body := struct {
+				Errors []struct {
+					Status int `json:"status"`
+				} `json:"errors"`
+			}{}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("handleError() body %s is not valid JSON: %s", w.Body.String(), err)
+			}
+			for _, e := range body.Errors {
+				if e.Status != tt.wantStatus {
+					t.Errorf("handleError() body status = %d, want %d", e.Status, tt.wantStatus)
+				}
+			}
+		})
+	}
+}
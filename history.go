@@ -0,0 +1,184 @@
+package processing
+
+import (
+	"encoding/base64"
+	"strings"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+// HistoryCollection is the hidden collection where the previous versions of an object are stored.
+const HistoryCollection = vocab.CollectionPath("history")
+
+// ObjectVersion represents a previous version of an object, together with the Update activity that superseded it.
+type ObjectVersion struct {
+	Object vocab.Item
+	Update vocab.IRI
+}
+
+// objectVersionIRI returns the IRI of the version of "ob" that has been superseded by the "upd" Update activity.
+// The IRI is derived from the Update's IRI so that we can always find the version that an Update replaced,
+// and the other way around.
+func objectVersionIRI(ob, upd vocab.Item) vocab.IRI {
+	return HistoryCollection.IRI(ob).AddPath(base64.RawURLEncoding.EncodeToString([]byte(upd.GetLink())))
+}
+
+// versionUpdateIRI returns the IRI of the Update activity that superseded the object version stored at "version".
+func versionUpdateIRI(version vocab.IRI) vocab.IRI {
+	s := version.String()
+	idx := strings.LastIndex(s, "/")
+	if idx < 0 {
+		return ""
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(s[idx+1:])
+	if err != nil {
+		return ""
+	}
+	return vocab.IRI(raw)
+}
+
+// versionOwnerIRI returns the IRI of the object that the version stored at "version" belongs to.
+func versionOwnerIRI(version vocab.IRI) vocab.IRI {
+	s := version.String()
+	idx := strings.LastIndex(s, "/"+string(HistoryCollection)+"/")
+	if idx <= 0 {
+		return ""
+	}
+	return vocab.IRI(s[:idx])
+}
+
+// copyItem returns a deep copy of "it".
+func copyItem(it vocab.Item) (vocab.Item, error) {
+	raw, err := vocab.MarshalJSON(it)
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to copy %s", it.GetLink())
+	}
+	cp, err := vocab.UnmarshalJSON(raw)
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to copy %s", it.GetLink())
+	}
+	return cp, nil
+}
+
+// saveObjectVersion stores a snapshot of "old", as it was before being modified by the "upd" Update activity,
+// in the history collection of the object. The "upd" activity must already have an ID.
+func (p *P) saveObjectVersion(old vocab.Item, upd *vocab.Activity) error {
+	if vocab.IsNil(old) || vocab.IsItemCollection(old) {
+		return nil
+	}
+	if len(upd.GetLink()) == 0 {
+		return errors.BadRequestf("unable to save a version of %s for an Update without an ID", old.GetLink())
+	}
+
+	// NOTE(marius): we need a deep copy of the stored object, because the update operates on it in place.
+	version, err := copyItem(old)
+	if err != nil {
+		return err
+	}
+
+	historyIRI := HistoryCollection.IRI(old)
+	if err = p.saveCollectionObjectForParent(old, blankOrderedCollection(historyIRI)); err != nil {
+		return errors.Annotatef(err, "unable to create history collection %s", historyIRI)
+	}
+
+	versionIRI := objectVersionIRI(old, upd)
+	if err = vocab.OnObject(version, setID(versionIRI)); err != nil {
+		return err
+	}
+	if version, err = p.s.Save(vocab.FlattenProperties(version)); err != nil {
+		return errors.Annotatef(err, "unable to save version %s", versionIRI)
+	}
+	if err = p.s.AddTo(historyIRI, version.GetLink()); err != nil && !errors.IsConflict(err) {
+		return errors.Annotatef(err, "unable to add version %s to history collection", versionIRI)
+	}
+	return nil
+}
+
+// ObjectHistory returns the previous versions of the object identified by "iri", each one together with the
+// Update activity that superseded it.
+//
+// The versions are available only if the processor has been created with the KeepObjectHistory option.
+func (p P) ObjectHistory(iri vocab.IRI) ([]ObjectVersion, error) {
+	// NOTE(marius): the versions of deleted objects are kept only until their tombstones get purged,
+	// and they must not be accessible in the meantime.
+	if ob, err := p.s.Load(iri); err == nil {
+		if ob = firstOrItem(ob); !vocab.IsNil(ob) && vocab.TombstoneType.Match(ob.GetType()) {
+			return nil, errors.NotFoundf("%s has been deleted", iri)
+		}
+	}
+	col, err := p.s.Load(HistoryCollection.IRI(iri))
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to load history for %s", iri)
+	}
+
+	versions := make([]ObjectVersion, 0)
+	err = vocab.OnCollectionIntf(col, func(c vocab.CollectionInterface) error {
+		for _, it := range c.Collection() {
+			if vocab.IsIRI(it) {
+				iri := it.GetLink()
+				if it, err = p.s.Load(iri); err != nil {
+					p.l.Warnf("unable to load version %s: %s", iri, err)
+					continue
+				}
+			}
+			if vocab.IsNil(it) {
+				continue
+			}
+			versions = append(versions, ObjectVersion{Object: it, Update: versionUpdateIRI(it.GetLink())})
+		}
+		return nil
+	})
+	return versions, err
+}
+
+// RestoreObjectVersion replaces the current state of an object with the one of its previous versions
+// identified by "version".
+// The current state is saved as a new version first, superseded by an Update which stands for the restore,
+// so that the restore can itself be reverted.
+func (p *P) RestoreObjectVersion(version vocab.IRI) (vocab.Item, error) {
+	owner := versionOwnerIRI(version)
+	if len(owner) == 0 {
+		return nil, errors.BadRequestf("%s is not a valid object version IRI", version)
+	}
+	it, err := p.s.Load(version)
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to load version %s", version)
+	}
+	current, err := p.s.Load(owner)
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to load object to restore %s", owner)
+	}
+	current = firstOrItem(current)
+
+	restore := &vocab.Activity{Type: vocab.UpdateType, Object: owner}
+	if err = SetIDIfMissing(restore, nil, p.createIDFn); err != nil {
+		return nil, errors.Annotatef(err, "unable to restore version %s", version)
+	}
+	if err = p.saveObjectVersion(current, restore); err != nil {
+		return nil, errors.Annotatef(err, "unable to save the current version of %s", owner)
+	}
+	return p.restoreObjectVersion(owner, firstOrItem(it))
+}
+
+// restoreObjectVersion replaces the object stored at "iri" with the "version" snapshot.
+func (p *P) restoreObjectVersion(iri vocab.IRI, version vocab.Item) (vocab.Item, error) {
+	if vocab.IsNil(version) {
+		return nil, errors.NotFoundf("unable to restore nil version of %s", iri)
+	}
+	if vocab.IsItemCollection(version) {
+		return version, errors.Conflictf("IRI %s does not point to a single object", version.GetLink())
+	}
+	if _, err := p.s.Load(iri); err != nil {
+		return version, errors.Annotatef(err, "unable to load object to restore %s", iri)
+	}
+	// NOTE(marius): we restore a copy, so the stored version doesn't change with the object it got restored to.
+	version, err := copyItem(version)
+	if err != nil {
+		return nil, err
+	}
+	if err = vocab.OnObject(version, setID(iri)); err != nil {
+		return version, errors.Annotatef(err, "unable to restore version of %s", iri)
+	}
+	return p.s.Save(vocab.FlattenProperties(version))
+}
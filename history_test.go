@@ -0,0 +1,181 @@
+package processing
+
+import (
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+func Test_objectVersionIRI(t *testing.T) {
+	tests := []struct {
+		name      string
+		ob        vocab.Item
+		upd       vocab.Item
+		wantOwner vocab.IRI
+	}{
+		{
+			name:      "note",
+			ob:        vocab.IRI("https://example.com/objects/1"),
+			upd:       vocab.IRI("https://example.com/activities/2"),
+			wantOwner: "https://example.com/objects/1",
+		},
+		{
+			name:      "actor",
+			ob:        &vocab.Actor{ID: "https://example.com/~jdoe"},
+			upd:       &vocab.Activity{ID: "https://example.com/~jdoe/outbox/1", Type: vocab.UpdateType},
+			wantOwner: "https://example.com/~jdoe",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := objectVersionIRI(tt.ob, tt.upd)
+			if !got.Contains(HistoryCollection.IRI(tt.ob), false) {
+				t.Errorf("objectVersionIRI() = %s, is not part of %s", got, HistoryCollection.IRI(tt.ob))
+			}
+			if owner := versionOwnerIRI(got); owner != tt.wantOwner {
+				t.Errorf("versionOwnerIRI() = %s, want %s", owner, tt.wantOwner)
+			}
+			if upd := versionUpdateIRI(got); upd != tt.upd.GetLink() {
+				t.Errorf("versionUpdateIRI() = %s, want %s", upd, tt.upd.GetLink())
+			}
+		})
+	}
+}
+
+func TestP_ObjectHistory(t *testing.T) {
+	noteIRI := vocab.IRI("https://example.com/objects/1")
+	upd := &vocab.Activity{
+		ID:     "https://example.com/activities/2",
+		Type:   vocab.UpdateType,
+		Actor:  defaultActorID,
+		Object: &vocab.Object{ID: noteIRI, Type: vocab.NoteType, Content: vocab.DefaultNaturalLanguage("edited")},
+	}
+
+	p := mockProcessor(t, "https://example.com")
+	p.keepHistory = true
+	note := &vocab.Object{ID: noteIRI, Type: vocab.NoteType, Content: vocab.DefaultNaturalLanguage("original")}
+	if _, err := p.s.Save(note); err != nil {
+		t.Fatalf("unable to save object: %s", err)
+	}
+	if _, err := p.UpdateActivity(upd); err != nil {
+		t.Fatalf("UpdateActivity() error = %s", err)
+	}
+
+	versions, err := p.ObjectHistory(noteIRI)
+	if err != nil {
+		t.Fatalf("ObjectHistory() error = %s", err)
+	}
+	if len(versions) != 1 {
+		t.Fatalf("ObjectHistory() returned %d versions, want 1", len(versions))
+	}
+	version := versions[0]
+	if !version.Update.Equal(upd.ID) {
+		t.Errorf("ObjectHistory() version superseded by %s, want %s", version.Update, upd.ID)
+	}
+	if want := objectVersionIRI(noteIRI, upd); !version.Object.GetLink().Equal(want) {
+		t.Errorf("ObjectHistory() version IRI = %s, want %s", version.Object.GetLink(), want)
+	}
+	_ = vocab.OnObject(version.Object, func(ob *vocab.Object) error {
+		if got := string(ob.Content.First()); got != "original" {
+			t.Errorf("ObjectHistory() version content = %q, want %q", got, "original")
+		}
+		return nil
+	})
+
+	restored, err := p.RestoreObjectVersion(version.Object.GetLink())
+	if err != nil {
+		t.Fatalf("RestoreObjectVersion() error = %s", err)
+	}
+	if !restored.GetLink().Equal(noteIRI) {
+		t.Errorf("RestoreObjectVersion() restored %s, want %s", restored.GetLink(), noteIRI)
+	}
+	it, err := p.s.Load(noteIRI)
+	if err != nil {
+		t.Fatalf("unable to load object: %s", err)
+	}
+	_ = vocab.OnObject(firstOrItem(it), func(ob *vocab.Object) error {
+		if got := string(ob.Content.First()); got != "original" {
+			t.Errorf("RestoreObjectVersion() content = %q, want %q", got, "original")
+		}
+		return nil
+	})
+}
+
+func TestP_RestoreObjectVersion_isReversible(t *testing.T) {
+	noteIRI := vocab.IRI("https://example.com/objects/1")
+	upd := &vocab.Activity{
+		ID:     "https://example.com/activities/2",
+		Type:   vocab.UpdateType,
+		Actor:  defaultActorID,
+		Object: &vocab.Object{ID: noteIRI, Type: vocab.NoteType, Content: vocab.DefaultNaturalLanguage("edited")},
+	}
+
+	p := mockProcessor(t, "https://example.com")
+	p.keepHistory = true
+	note := &vocab.Object{ID: noteIRI, Type: vocab.NoteType, Content: vocab.DefaultNaturalLanguage("original")}
+	if _, err := p.s.Save(note); err != nil {
+		t.Fatalf("unable to save object: %s", err)
+	}
+	if _, err := p.UpdateActivity(upd); err != nil {
+		t.Fatalf("UpdateActivity() error = %s", err)
+	}
+	if _, err := p.RestoreObjectVersion(objectVersionIRI(noteIRI, upd)); err != nil {
+		t.Fatalf("RestoreObjectVersion() error = %s", err)
+	}
+
+	versions, err := p.ObjectHistory(noteIRI)
+	if err != nil {
+		t.Fatalf("ObjectHistory() error = %s", err)
+	}
+	if len(versions) != 2 {
+		t.Fatalf("ObjectHistory() returned %d versions after restoring, want 2", len(versions))
+	}
+	var latest vocab.IRI
+	for _, version := range versions {
+		if !version.Update.Equal(upd.ID) {
+			latest = version.Object.GetLink()
+		}
+	}
+	if latest == "" {
+		t.Fatalf("RestoreObjectVersion() didn't save the version it replaced")
+	}
+
+	if _, err = p.RestoreObjectVersion(latest); err != nil {
+		t.Fatalf("RestoreObjectVersion() error = %s", err)
+	}
+	it, err := p.s.Load(noteIRI)
+	if err != nil {
+		t.Fatalf("unable to load object: %s", err)
+	}
+	_ = vocab.OnObject(firstOrItem(it), func(ob *vocab.Object) error {
+		if got := string(ob.Content.First()); got != "edited" {
+			t.Errorf("RestoreObjectVersion() content = %q, want %q after reverting the restore", got, "edited")
+		}
+		return nil
+	})
+}
+
+func TestP_ObjectHistory_withoutHistory(t *testing.T) {
+	noteIRI := vocab.IRI("https://example.com/objects/1")
+	p := mockProcessor(t, "https://example.com")
+	note := &vocab.Object{ID: noteIRI, Type: vocab.NoteType, Content: vocab.DefaultNaturalLanguage("original")}
+	if _, err := p.s.Save(note); err != nil {
+		t.Fatalf("unable to save object: %s", err)
+	}
+	upd := &vocab.Activity{
+		ID:     "https://example.com/activities/2",
+		Type:   vocab.UpdateType,
+		Actor:  defaultActorID,
+		Object: &vocab.Object{ID: noteIRI, Type: vocab.NoteType, Content: vocab.DefaultNaturalLanguage("edited")},
+	}
+	if _, err := p.UpdateActivity(upd); err != nil {
+		t.Fatalf("UpdateActivity() error = %s", err)
+	}
+	if _, err := p.ObjectHistory(noteIRI); !errors.IsNotFound(err) {
+		t.Errorf("ObjectHistory() error = %v, expected not found without KeepObjectHistory", err)
+	}
+	if _, err := p.RestoreObjectVersion(noteIRI); !errors.IsBadRequest(err) {
+		t.Errorf("RestoreObjectVersion() error = %v, expected bad request for an IRI which is not a version", err)
+	}
+}
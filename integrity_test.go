@@ -0,0 +1,378 @@
+package processing
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+func testMultikey(pub ed25519.PublicKey) string {
+	return string(multibaseBase58BTC) + encodeBase58(append(append([]byte{}, multicodecEd25519Pub...), pub...))
+}
+
+func Test_canonicalizeJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{
+			name: "sorted properties",
+			raw:  `{"b": 1, "a": {"d": true, "c": null}}`,
+			want: `{"a":{"c":null,"d":true},"b":1}`,
+		},
+		{
+			name: "numbers",
+			raw:  `{"n": [333333333.33333329, 1E30, 4.50, 2e-3, 0.000000000000000000000000001, -0, 1e21, 1e-7, 100]}`,
+			want: `{"n":[333333333.3333333,1e+30,4.5,0.002,1e-27,0,1e+21,1e-7,100]}`,
+		},
+		{
+			name: "strings",
+			raw:  `{"s": "€$\u000F\u000aA'\u0042\u0022\u005c\\\"\/"}`,
+			want: `{"s":"€$\u000f\nA'B\"\\\\\"/"}`,
+		},
+		{
+			name: "UTF-16 ordering",
+			raw:  `{"\ud83d\ude00": 1, "\ufb33": 2, "\u20ac": 3}`,
+			want: "{\"\u20ac\":3,\"\U0001F600\":1,\"\ufb33\":2}",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := decodeJSONDocument([]byte(tt.raw))
+			if err != nil {
+				t.Fatalf("decodeJSONDocument() error = %s", err)
+			}
+			got, err := canonicalizeJSON(doc)
+			if err != nil {
+				t.Fatalf("canonicalizeJSON() error = %s", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("canonicalizeJSON() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_base58(t *testing.T) {
+	tests := []struct {
+		data []byte
+		want string
+	}{
+		{data: []byte{}, want: ""},
+		{data: []byte{0, 0, 1}, want: "112"},
+		{data: []byte("Hello World!"), want: "2NEpo7TZRRrLZSi2U"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := encodeBase58(tt.data); got != tt.want {
+				t.Errorf("encodeBase58() = %s, want %s", got, tt.want)
+			}
+			got, err := decodeBase58(tt.want)
+			if err != nil {
+				t.Fatalf("decodeBase58() error = %s", err)
+			}
+			if !bytes.Equal(got, tt.data) {
+				t.Errorf("decodeBase58() = %v, want %v", got, tt.data)
+			}
+		})
+	}
+}
+
+func TestP_VerifyIntegrityProof(t *testing.T) {
+	base := vocab.IRI("https://example.com")
+	pub, key, _ := ed25519.GenerateKey(rand.Reader)
+
+	p := mockProcessor(t, base)
+	actor := testSignedActor(t, p, base+"/~jdoe", pub)
+
+	raw := []byte(`{"@context":"https://www.w3.org/ns/activitystreams","id":"https://example.com/1","type":"Create","actor":"https://example.com/~jdoe","object":{"type":"Note","content":"Hello"}}`)
+	doc, err := decodeJSONDocument(raw)
+	if err != nil {
+		t.Fatalf("decodeJSONDocument() error = %s", err)
+	}
+	proof, err := createIntegrityProof(doc, key, actor.PublicKey.ID, time.Now())
+	if err != nil {
+		t.Fatalf("createIntegrityProof() error = %s", err)
+	}
+	doc["proof"] = proof
+	signed, _ := json.Marshal(doc)
+
+	t.Run("without proof", func(t *testing.T) {
+		signer, err := p.VerifyIntegrityProof(raw)
+		if err != nil || signer != nil {
+			t.Errorf("VerifyIntegrityProof() = %v, %v, expected no signer and no error", signer, err)
+		}
+	})
+	t.Run("valid proof", func(t *testing.T) {
+		signer, err := p.VerifyIntegrityProof(signed)
+		if err != nil {
+			t.Fatalf("VerifyIntegrityProof() error = %s", err)
+		}
+		if signer == nil || !signer.ID.Equal(actor.ID) {
+			t.Errorf("VerifyIntegrityProof() signer = %v, want %s", signer, actor.ID)
+		}
+	})
+	t.Run("tampered document", func(t *testing.T) {
+		tampered := bytes.Replace(signed, []byte("Hello"), []byte("Goodbye"), 1)
+		if _, err := p.VerifyIntegrityProof(tampered); err == nil {
+			t.Errorf("VerifyIntegrityProof() expected error for tampered document")
+		}
+	})
+}
+
+func Test_decodeMultikeyEd25519(t *testing.T) {
+	pub, _, _ := ed25519.GenerateKey(rand.Reader)
+	got, err := decodeMultikeyEd25519(testMultikey(pub))
+	if err != nil {
+		t.Fatalf("decodeMultikeyEd25519() error = %s", err)
+	}
+	if !pub.Equal(got) {
+		t.Errorf("decodeMultikeyEd25519() = %v, want %v", got, pub)
+	}
+	for _, invalid := range []string{"", "z", "u" + testMultikey(pub)[1:], "z" + encodeBase58(pub)} {
+		if _, err = decodeMultikeyEd25519(invalid); err == nil {
+			t.Errorf("decodeMultikeyEd25519(%q) expected error", invalid)
+		}
+	}
+}
+
+func TestP_VerifyIntegrityProof_assertionMethod(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	pub, key, _ := ed25519.GenerateKey(rand.Reader)
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actorID := "https://" + r.Host + r.URL.Path
+		doc := map[string]any{
+			"@context": []any{"https://www.w3.org/ns/activitystreams", "https://w3id.org/security/data-integrity/v1"},
+			"id":       actorID,
+			"type":     "Person",
+			"publicKey": map[string]any{
+				"id":           actorID + "#main-key",
+				"owner":        actorID,
+				"publicKeyPem": testPublicKeyPEM(t, rsaKey.Public()),
+			},
+			"assertionMethod": []any{
+				map[string]any{
+					"id":                 actorID + "#ed25519-key",
+					"type":               MultikeyType,
+					"controller":         actorID,
+					"publicKeyMultibase": testMultikey(pub),
+				},
+			},
+		}
+		raw, _ := json.Marshal(doc)
+		w.Header().Set("Content-Type", "application/activity+json")
+		_, _ = w.Write(raw)
+	}))
+	defer srv.Close()
+	actorID := vocab.IRI(srv.URL + "/~alice")
+
+	p := mockProcessor(t, "https://example.com")
+	sign := func(keyID vocab.IRI) []byte {
+		doc := map[string]any{
+			"@context": "https://www.w3.org/ns/activitystreams",
+			"id":       actorID.String() + "/1",
+			"type":     "Create",
+			"actor":    actorID.String(),
+			"object":   map[string]any{"type": "Note", "content": "Hello"},
+		}
+		proof, err := createIntegrityProof(doc, key, keyID, time.Now())
+		if err != nil {
+			t.Fatalf("createIntegrityProof() error = %s", err)
+		}
+		doc["proof"] = proof
+		raw, _ := json.Marshal(doc)
+		return raw
+	}
+
+	t.Run("multikey", func(t *testing.T) {
+		signer, err := p.VerifyIntegrityProof(sign(actorID + "#ed25519-key"))
+		if err != nil {
+			t.Fatalf("VerifyIntegrityProof() error = %s", err)
+		}
+		if signer == nil || !signer.ID.Equal(actorID) {
+			t.Errorf("VerifyIntegrityProof() signer = %v, want %s", signer, actorID)
+		}
+	})
+	t.Run("RSA public key", func(t *testing.T) {
+		if _, err := p.VerifyIntegrityProof(sign(actorID + "#main-key")); err == nil {
+			t.Errorf("VerifyIntegrityProof() expected error for a proof with a RSA verification method")
+		}
+	})
+	t.Run("unknown key", func(t *testing.T) {
+		if _, err := p.VerifyIntegrityProof(sign(actorID + "#other-key")); !errors.IsUnauthorized(err) {
+			t.Errorf("VerifyIntegrityProof() error = %v, expected unauthorized", err)
+		}
+	})
+}
+
+type mockProofKeyLoader map[vocab.IRI]ed25519.PrivateKey
+
+func (m mockProofKeyLoader) LoadProofKey(iri vocab.IRI) (ed25519.PrivateKey, vocab.IRI, error) {
+	if key, ok := m[iri]; ok {
+		return key, iri + "#ed25519-key", nil
+	}
+	return nil, "", errors.NotFoundf("no proof key for %s", iri)
+}
+
+func TestP_integrityProofKeyFor(t *testing.T) {
+	base := vocab.IRI("https://example.com")
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	_, proofKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	p := mockProcessor(t, base)
+	p.proofKeyLoader = mockProofKeyLoader{base + "/~jdoe": proofKey}
+
+	tests := []struct {
+		name      string
+		actor     vocab.IRI
+		key       any
+		keyID     vocab.IRI
+		want      ed25519.PrivateKey
+		wantKeyID vocab.IRI
+	}{
+		{
+			name:      "proof key separate from the RSA main key",
+			actor:     base + "/~jdoe",
+			key:       rsaKey,
+			keyID:     base + "/~jdoe#main-key",
+			want:      proofKey,
+			wantKeyID: base + "/~jdoe#ed25519-key",
+		},
+		{
+			name:      "Ed25519 main key",
+			actor:     base + "/~alice",
+			key:       edKey,
+			keyID:     base + "/~alice#main-key",
+			want:      edKey,
+			wantKeyID: base + "/~alice#main-key",
+		},
+		{
+			name:  "RSA main key without proof key",
+			actor: base + "/~alice",
+			key:   rsaKey,
+			keyID: base + "/~alice#main-key",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, gotKeyID, ok := p.integrityProofKeyFor(tt.actor, tt.key, tt.keyID)
+			if ok != (tt.want != nil) {
+				t.Fatalf("integrityProofKeyFor() ok = %t, want %t", ok, tt.want != nil)
+			}
+			if !ok {
+				return
+			}
+			if !tt.want.Equal(got) {
+				t.Errorf("integrityProofKeyFor() returned the wrong key")
+			}
+			if !gotKeyID.Equal(tt.wantKeyID) {
+				t.Errorf("integrityProofKeyFor() key ID = %s, want %s", gotKeyID, tt.wantKeyID)
+			}
+		})
+	}
+}
+
+func TestP_createActivityIntegrityProof(t *testing.T) {
+	base := vocab.IRI("https://example.com")
+	pub, proofKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	p := mockProcessor(t, base)
+	p.proofKeyLoader = mockProofKeyLoader{base + "/~jdoe": proofKey}
+
+	act := &vocab.Activity{
+		ID:     base + "/activities/1",
+		Type:   vocab.CreateType,
+		Actor:  base + "/~jdoe",
+		Object: &vocab.Object{Type: vocab.NoteType, Content: vocab.DefaultNaturalLanguage("Hello")},
+	}
+	if err := p.createActivityIntegrityProof(act); err != nil {
+		t.Fatalf("createActivityIntegrityProof() error = %s", err)
+	}
+	rawProof := p.loadIntegrityProof(act.ID)
+	if rawProof == nil {
+		t.Fatalf("createActivityIntegrityProof() didn't store the proof of %s", act.ID)
+	}
+
+	raw, _ := vocab.MarshalJSON(act)
+	doc, _ := decodeJSONDocument(raw)
+	proof := make(map[string]any)
+	if err := json.Unmarshal(rawProof, &proof); err != nil {
+		t.Fatalf("invalid stored proof: %s", err)
+	}
+	if err := verifyIntegrityProof(doc, proof, pub); err != nil {
+		t.Errorf("the stored proof is not valid for the activity: %s", err)
+	}
+
+	t.Run("remote actor", func(t *testing.T) {
+		remote := &vocab.Activity{ID: "https://remote.example.com/activities/1", Type: vocab.LikeType, Actor: vocab.IRI("https://remote.example.com/~jdoe")}
+		if err := p.createActivityIntegrityProof(remote); err != nil {
+			t.Fatalf("createActivityIntegrityProof() error = %s", err)
+		}
+		if p.loadIntegrityProof(remote.ID) != nil {
+			t.Errorf("createActivityIntegrityProof() stored a proof for a remote actor's activity")
+		}
+	})
+}
+
+func TestP_integrityProof_forwarding(t *testing.T) {
+	base := vocab.IRI("https://example.com")
+	pub, key, _ := ed25519.GenerateKey(rand.Reader)
+
+	p := mockProcessor(t, base)
+	remoteActor := testSignedActor(t, p, "https://remote.example.com/~jdoe", pub)
+
+	doc := map[string]any{
+		"id":     "https://remote.example.com/activities/1",
+		"type":   "Create",
+		"actor":  remoteActor.ID.String(),
+		"object": map[string]any{"type": "Note", "content": "Hello"},
+	}
+	proof, err := createIntegrityProof(doc, key, remoteActor.PublicKey.ID, time.Now())
+	if err != nil {
+		t.Fatalf("createIntegrityProof() error = %s", err)
+	}
+	withoutProof, _ := json.Marshal(doc)
+	doc["proof"] = proof
+	received, _ := json.Marshal(doc)
+	receivedProof, _ := json.Marshal(proof)
+
+	r := httptest.NewRequest(http.MethodPost, base.String()+"/~alice/inbox", bytes.NewReader(received))
+	signer := p.integrityProofSigner(r)
+	if signer == nil || !signer.ID.Equal(remoteActor.ID) {
+		t.Fatalf("integrityProofSigner() = %v, want %s", signer, remoteActor.ID)
+	}
+	if stored := p.loadIntegrityProof("https://remote.example.com/activities/1"); !bytes.Equal(stored, receivedProof) {
+		t.Fatalf("integrityProofSigner() stored proof %s, want %s", stored, receivedProof)
+	}
+
+	fw := httptest.NewRequest(http.MethodPost, "https://other.example.com/~bob/inbox", bytes.NewReader(withoutProof))
+	if err = p.attachIntegrityProof(fw, remoteActor, nil, ""); err != nil {
+		t.Fatalf("attachIntegrityProof() error = %s", err)
+	}
+	forwarded, _ := io.ReadAll(fw.Body)
+	got := struct {
+		Proof json.RawMessage `json:"proof"`
+	}{}
+	if err = json.Unmarshal(forwarded, &got); err != nil {
+		t.Fatalf("invalid forwarded activity: %s", err)
+	}
+	if !bytes.Equal(got.Proof, receivedProof) {
+		t.Errorf("attachIntegrityProof() forwarded proof %s, want the received one %s", got.Proof, receivedProof)
+	}
+	if signer, err = p.VerifyIntegrityProof(forwarded); err != nil || signer == nil || !signer.ID.Equal(remoteActor.ID) {
+		t.Errorf("VerifyIntegrityProof() of the forwarded activity = %v, %v, want %s", signer, err, remoteActor.ID)
+	}
+}
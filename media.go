@@ -0,0 +1,330 @@
+package processing
+
+import (
+	"bytes"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"net/http"
+	"slices"
+	"strings"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+// MediaSizeLimits represents the maximum sizes in bytes for media content.
+// The keys can be full media types, eg: "image/png", or only the top level type, eg: "image".
+type MediaSizeLimits map[vocab.MimeType]int64
+
+func topLevelMediaType(mt vocab.MimeType) vocab.MimeType {
+	typ, _, _ := strings.Cut(string(mt), "/")
+	return vocab.MimeType(strings.ToLower(strings.TrimSpace(typ)))
+}
+
+func baseMediaType(mt vocab.MimeType) vocab.MimeType {
+	typ, _, _ := strings.Cut(string(mt), ";")
+	return vocab.MimeType(strings.ToLower(strings.TrimSpace(typ)))
+}
+
+// limitFor returns the size limit for "mt", or -1 if there's no limit.
+func (m MediaSizeLimits) limitFor(mt vocab.MimeType) int64 {
+	if limit, ok := m[baseMediaType(mt)]; ok {
+		return limit
+	}
+	if limit, ok := m[topLevelMediaType(mt)]; ok {
+		return limit
+	}
+	return -1
+}
+
+var mediaTypesForObjectTypes = map[vocab.ActivityVocabularyType]vocab.MimeType{
+	vocab.ImageType: "image",
+	vocab.VideoType: "video",
+	vocab.AudioType: "audio",
+}
+
+// mediaObjectTypes are the types of objects which can have binary content.
+var mediaObjectTypes = vocab.ActivityVocabularyTypes{vocab.ImageType, vocab.VideoType, vocab.AudioType, vocab.DocumentType}
+
+// equivalentSniffedTypes contains, for the media types that http.DetectContentType can't recognize,
+// the types it detects for their content instead.
+var equivalentSniffedTypes = map[vocab.MimeType][]vocab.MimeType{
+	"audio/ogg":     {"application/ogg"},
+	"video/ogg":     {"application/ogg"},
+	"audio/mp4":     {"video/mp4"},
+	"audio/m4a":     {"video/mp4"},
+	"audio/x-m4a":   {"video/mp4"},
+	"image/svg+xml": {"text/xml", "text/plain"},
+}
+
+// validateMedia checks that the binary content in "data" matches the declared "mediaType", that it is compatible
+// with the type of the object, and that it doesn't exceed the size limits configured for the processor.
+func (p *P) validateMedia(typ vocab.ActivityVocabularyType, mediaType vocab.MimeType, data []byte) error {
+	if limit := p.mediaLimits.limitFor(mediaType); limit >= 0 && int64(len(data)) > limit {
+		return errors.BadRequestf("%s content exceeds maximum size of %d bytes", mediaType, limit)
+	}
+
+	declared := topLevelMediaType(mediaType)
+	if expected, ok := mediaTypesForObjectTypes[typ]; ok && declared != expected {
+		return errors.BadRequestf("%s content is not valid for an %s object", mediaType, typ)
+	}
+
+	// NOTE(marius): http.DetectContentType returns "application/octet-stream" when it can't determine
+	// the type of the content, in which case we trust the declared media type.
+	sniffed := baseMediaType(vocab.MimeType(http.DetectContentType(data)))
+	if sniffed == "application/octet-stream" {
+		return nil
+	}
+	if topLevelMediaType(sniffed) == declared || slices.Contains(equivalentSniffedTypes[baseMediaType(mediaType)], sniffed) {
+		return nil
+	}
+	return errors.BadRequestf("%s content does not match the declared media type %s", sniffed, mediaType)
+}
+
+// mediaMetadata contains information about the media content, that we expose on the objects.
+type mediaMetadata struct {
+	width  uint
+	height uint
+}
+
+// extractMediaMetadata decodes the configuration of image content and returns its dimensions.
+// For media types other than images it returns empty metadata.
+func extractMediaMetadata(mediaType vocab.MimeType, data []byte) mediaMetadata {
+	meta := mediaMetadata{}
+	if topLevelMediaType(mediaType) != "image" {
+		return meta
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return meta
+	}
+	meta.width = uint(cfg.Width)
+	meta.height = uint(cfg.Height)
+	return meta
+}
+
+// mediaLink returns the Link to the "url" of a blob with "mediaType" content, with the dimensions
+// from "meta", for the media which has them.
+//
+// NOTE(marius): ActivityStreams doesn't have a vocabulary property for the size in bytes of the content,
+// so it isn't exposed on the Link. The BlobStore keeps track of it.
+func mediaLink(url vocab.IRI, mediaType vocab.MimeType, meta mediaMetadata) *vocab.Link {
+	return &vocab.Link{
+		Type:      vocab.LinkType,
+		Href:      url,
+		MediaType: mediaType,
+		Width:     meta.width,
+		Height:    meta.height,
+	}
+}
+
+// mediaBlobIRI returns the IRI of the blob that the URL of the "it" media object links to, if it has one.
+func mediaBlobIRI(it vocab.Item) vocab.IRI {
+	var iri vocab.IRI
+	_ = vocab.OnObject(it, func(o *vocab.Object) error {
+		if vocab.IsNil(o.URL) || !vocab.LinkTypes.Match(o.URL.GetType()) {
+			return nil
+		}
+		return vocab.OnLink(o.URL, func(u *vocab.Link) error {
+			iri = u.Href
+			return nil
+		})
+	})
+	return iri
+}
+
+// mediaSize returns the total size of the "iris" blobs in the processor's BlobStore.
+func (p P) mediaSize(iris ...vocab.IRI) int64 {
+	if p.blobs == nil {
+		return 0
+	}
+	var size int64
+	for _, iri := range iris {
+		if blobSize, err := p.blobs.Size(iri); err == nil {
+			size += blobSize
+		}
+	}
+	return size
+}
+
+// mediaBlobIRIs returns the IRIs of the blobs that the "it" media objects, and their attachments, link to.
+func mediaBlobIRIs(it vocab.Item) vocab.IRIs {
+	iris := make(vocab.IRIs, 0)
+	_ = vocab.OnItem(it, func(it vocab.Item) error {
+		if vocab.IsIRI(it) || vocab.ActivityTypes.Match(it.GetType()) {
+			return nil
+		}
+		if iri := mediaBlobIRI(it); len(iri) > 0 && !iris.Contains(iri) {
+			_ = iris.Append(iri)
+		}
+		return vocab.OnObject(it, func(o *vocab.Object) error {
+			for _, iri := range mediaBlobIRIs(o.Attachment) {
+				if !iris.Contains(iri) {
+					_ = iris.Append(iri)
+				}
+			}
+			return nil
+		})
+	})
+	return iris
+}
+
+// deleteMediaBlobs removes from the processor's BlobStore the blobs that the "deleted" local objects link to.
+//
+// NOTE(marius): the blobs of remote objects are never removed, as the IRIs they link to are not under our control.
+func (p P) deleteMediaBlobs(deleted ...vocab.Item) {
+	for _, ob := range deleted {
+		if !vocab.IsNil(ob) && p.IsLocalIRI(ob.GetLink()) {
+			p.deleteBlobs(mediaBlobIRIs(ob)...)
+		}
+	}
+}
+
+// deleteBlobs removes the "iris" blobs from the processor's BlobStore, and returns their total size.
+func (p P) deleteBlobs(iris ...vocab.IRI) int64 {
+	if p.blobs == nil {
+		return 0
+	}
+	var size int64
+	for _, iri := range iris {
+		blobSize := p.mediaSize(iri)
+		if err := p.blobs.Delete(iri); err != nil {
+			if !errors.IsNotFound(err) {
+				p.l.Warnf("unable to delete blob %s: %s", iri, err)
+			}
+			continue
+		}
+		size += blobSize
+	}
+	return size
+}
+
+// processMediaContent validates the binary content of media objects, which is encoded in their Content as a data URI.
+// The content is moved to the processor's BlobStore, the object's URL is replaced with a Link to the IRI of the blob,
+// and the metadata of the content is stored on the object. Without a BlobStore, the binary content would end up in
+// the objects storage, so it gets rejected.
+func (p *P) processMediaContent(it vocab.Item) error {
+	if vocab.IsNil(it) {
+		return nil
+	}
+	if vocab.IsItemCollection(it) {
+		return vocab.OnItemCollection(it, func(col *vocab.ItemCollection) error {
+			for _, ob := range *col {
+				if err := p.processMediaContent(ob); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	if vocab.IsIRI(it) || vocab.ActivityTypes.Match(it.GetType()) {
+		return nil
+	}
+	return vocab.OnObject(it, func(o *vocab.Object) error {
+		if !mediaObjectTypes.Match(o.GetType()) {
+			// NOTE(marius): only the media objects can have binary content, but other objects can have them as attachments
+			return p.processMediaContent(o.Attachment)
+		}
+		for _, nv := range o.Content {
+			if !bytes.HasPrefix(nv, []byte("data:")) {
+				continue
+			}
+			mediaType, data, err := decodeDataURI(nv)
+			if err != nil {
+				return errors.NewBadRequest(err, "unable to decode media content for %s", o.ID)
+			}
+			if err = p.validateMedia(o.GetType(), mediaType, data); err != nil {
+				return err
+			}
+			if p.blobs == nil {
+				return errors.NotImplementedf("unable to save media content for %s, media content is not supported", o.ID)
+			}
+
+			url, _, err := p.blobs.Put(mediaType, data)
+			if err != nil {
+				return errors.Annotatef(err, "unable to save media content for %s", o.ID)
+			}
+			if o.MediaType == "" {
+				o.MediaType = mediaType
+			}
+			o.Content = nil
+			o.URL = mediaLink(url, mediaType, extractMediaMetadata(mediaType, data))
+			break
+		}
+		return p.processMediaContent(o.Attachment)
+	})
+}
+
+// MediaUpload represents the file received in an uploadMedia request.
+type MediaUpload struct {
+	Name      string
+	MediaType vocab.MimeType
+	Data      []byte
+}
+
+// UploadMedia processes the "upload" file received at the uploadMedia endpoint of the "author" actor.
+//
+// https://www.w3.org/wiki/SocialCG/ActivityPub/MediaUpload
+//
+// The file is validated and saved in the processor's BlobStore, and the "ob" object gets its URL pointing to it.
+// Then the object is wrapped in a Create activity which goes through the regular client to server processing.
+// It returns the created object.
+func (p *P) UploadMedia(ob vocab.Item, upload MediaUpload, author vocab.Actor) (vocab.Item, error) {
+	if p.blobs == nil {
+		return ob, errors.NotImplementedf("media uploads are not supported")
+	}
+	if vocab.IsNil(ob) {
+		return ob, InvalidActivityObject("is nil")
+	}
+	if vocab.IsIRI(ob) || vocab.ActivityTypes.Match(ob.GetType()) {
+		return ob, InvalidActivityObject("%s is not a valid media object", ob.GetType())
+	}
+
+	create := &vocab.Activity{Type: vocab.CreateType, Actor: author.GetLink()}
+	usage := Usage{}
+	var blob vocab.IRI
+	err := vocab.OnObject(ob, func(o *vocab.Object) error {
+		if err := p.validateMedia(o.GetType(), upload.MediaType, upload.Data); err != nil {
+			return err
+		}
+		var err error
+		if usage, err = p.reserveMediaQuota(author.GetLink(), int64(len(upload.Data))); err != nil {
+			return err
+		}
+		if blob, _, err = p.blobs.Put(upload.MediaType, upload.Data); err != nil {
+			return errors.Annotatef(err, "unable to save uploaded media %s", upload.Name)
+		}
+		if o.MediaType == "" {
+			o.MediaType = upload.MediaType
+		}
+		o.URL = mediaLink(blob, upload.MediaType, extractMediaMetadata(upload.MediaType, upload.Data))
+
+		// NOTE(marius): the Create activity is addressed to the same recipients as its object
+		create.To = o.To
+		create.Bto = o.Bto
+		create.CC = o.CC
+		create.BCC = o.BCC
+		create.Audience = o.Audience
+		return nil
+	})
+	if err != nil {
+		p.releaseQuota(author.GetLink(), usage)
+		return ob, err
+	}
+	create.Object = ob
+
+	it, err := p.ProcessClientActivity(create, author, vocab.Outbox.IRI(author))
+	if err != nil {
+		// NOTE(marius): the object hasn't been created, so nothing links to the uploaded media
+		p.deleteBlobs(blob)
+		p.releaseQuota(author.GetLink(), usage)
+		return ob, err
+	}
+	err = vocab.OnActivity(it, func(act *vocab.Activity) error {
+		ob = act.Object
+		return nil
+	})
+	return ob, err
+}
@@ -0,0 +1,173 @@
+package processing
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"io/fs"
+	"path/filepath"
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+)
+
+func testPNG(t *testing.T, w, h int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for x := 0; x < w; x++ {
+		for y := 0; y < h; y++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 255 / w), G: uint8(y * 255 / h), B: 128, A: 255})
+		}
+	}
+	buf := bytes.Buffer{}
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("unable to encode test image: %s", err)
+	}
+	return buf.Bytes()
+}
+
+func TestMediaSizeLimits_limitFor(t *testing.T) {
+	limits := MediaSizeLimits{"image": 100, "image/gif": 10}
+	tests := []struct {
+		mediaType vocab.MimeType
+		want      int64
+	}{
+		{mediaType: "image/png", want: 100},
+		{mediaType: "image/gif", want: 10},
+		{mediaType: "IMAGE/GIF; charset=binary", want: 10},
+		{mediaType: "video/mp4", want: -1},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.mediaType), func(t *testing.T) {
+			if got := limits.limitFor(tt.mediaType); got != tt.want {
+				t.Errorf("limitFor() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestP_validateMedia(t *testing.T) {
+	pngData := testPNG(t, 4, 4)
+	tests := []struct {
+		name      string
+		limits    MediaSizeLimits
+		typ       vocab.ActivityVocabularyType
+		mediaType vocab.MimeType
+		data      []byte
+		wantErr   bool
+	}{
+		{
+			name:      "valid png",
+			typ:       vocab.ImageType,
+			mediaType: "image/png",
+			data:      pngData,
+		},
+		{
+			name:      "png declared as video",
+			typ:       vocab.VideoType,
+			mediaType: "video/mp4",
+			data:      pngData,
+			wantErr:   true,
+		},
+		{
+			name:      "png with mismatched object type",
+			typ:       vocab.AudioType,
+			mediaType: "image/png",
+			data:      pngData,
+			wantErr:   true,
+		},
+		{
+			name:      "unknown content is trusted",
+			typ:       vocab.DocumentType,
+			mediaType: "application/x-custom",
+			data:      []byte{0x00, 0x01, 0x02},
+		},
+		{
+			name:      "ogg audio sniffed as application/ogg",
+			typ:       vocab.AudioType,
+			mediaType: "audio/ogg",
+			data:      []byte("OggS\x00\x02\x00\x00\x00\x00\x00\x00\x00\x00"),
+		},
+		{
+			name:      "m4a audio sniffed as video/mp4",
+			typ:       vocab.AudioType,
+			mediaType: "audio/mp4",
+			data:      []byte("\x00\x00\x00\x18ftypmp42\x00\x00\x00\x00mp42isom"),
+		},
+		{
+			name:      "svg sniffed as text/xml",
+			typ:       vocab.ImageType,
+			mediaType: "image/svg+xml",
+			data:      []byte(`<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg"></svg>`),
+		},
+		{
+			name:      "svg sniffed as text/plain",
+			typ:       vocab.ImageType,
+			mediaType: "image/svg+xml",
+			data:      []byte(`<svg xmlns="http://www.w3.org/2000/svg"></svg>`),
+		},
+		{
+			name:      "html declared as svg",
+			typ:       vocab.ImageType,
+			mediaType: "image/svg+xml",
+			data:      []byte(`<html><body></body></html>`),
+			wantErr:   true,
+		},
+		{
+			name:      "exceeds size limit",
+			limits:    MediaSizeLimits{"image": 10},
+			typ:       vocab.ImageType,
+			mediaType: "image/png",
+			data:      pngData,
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &P{mediaLimits: tt.limits}
+			if err := p.validateMedia(tt.typ, tt.mediaType, tt.data); (err != nil) != tt.wantErr {
+				t.Errorf("validateMedia() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_extractMediaMetadata(t *testing.T) {
+	meta := extractMediaMetadata("image/png", testPNG(t, 32, 16))
+	if meta.width != 32 || meta.height != 16 {
+		t.Errorf("extractMediaMetadata() dimensions = %dx%d, want 32x16", meta.width, meta.height)
+	}
+
+	if meta = extractMediaMetadata("video/mp4", []byte{0x00}); meta != (mediaMetadata{}) {
+		t.Errorf("extractMediaMetadata() = %v, expected empty metadata for video", meta)
+	}
+}
+
+func TestP_UploadMedia_removesBlobOnError(t *testing.T) {
+	p := mockProcessor(t, defaultActorID)
+	root := t.TempDir()
+	p.blobs = NewFSBlobStore(root, defaultActorID+"/blobs")
+
+	existing := &vocab.Object{ID: defaultActorID + "/objects/1", Type: vocab.ImageType}
+	if _, err := p.s.Save(existing); err != nil {
+		t.Fatalf("unable to save object: %s", err)
+	}
+
+	// NOTE(marius): the Create activity fails, as its object already exists
+	ob := &vocab.Object{ID: existing.ID, Type: vocab.ImageType, To: vocab.ItemCollection{vocab.PublicNS}}
+	upload := MediaUpload{Name: "test.png", MediaType: "image/png", Data: testPNG(t, 4, 4)}
+	if _, err := p.UploadMedia(ob, upload, *defaultActor); err == nil {
+		t.Fatalf("UploadMedia() expected error for an existing object")
+	}
+
+	blobs := 0
+	_ = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			blobs++
+		}
+		return nil
+	})
+	if blobs > 0 {
+		t.Errorf("UploadMedia() left %d blob files after failing", blobs)
+	}
+}
@@ -1,12 +1,15 @@
 package processing
 
 import (
+	"context"
 	"net/http"
 	"net/http/httputil"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
+
+	vocab "github.com/go-ap/activitypub"
 )
 
 func RequestToDiskMw(outPath string, checkDebugEnabledFn func() bool) func(next http.Handler) http.Handler {
@@ -40,3 +43,50 @@ func RequestToDiskMw(outPath string, checkDebugEnabledFn func() bool) func(next
 		})
 	}
 }
+
+const authorKey = ctxKey("__author")
+
+// AuthorFromRequest returns the actor which signed the "r" request, as resolved by the
+// HTTPSignatureVerifierMw middleware.
+// It can be passed as the author to the ProcessServerActivity and ProcessClientActivity methods.
+func AuthorFromRequest(r *http.Request) (vocab.Actor, bool) {
+	author, ok := r.Context().Value(authorKey).(vocab.Actor)
+	return author, ok
+}
+
+// ContextWithAuthor returns a context that carries the "author" actor which made the request, and which is
+// returned by AuthorFromRequest. It can be used by applications that authorize requests based on tokens.
+func ContextWithAuthor(ctx context.Context, author vocab.Actor) context.Context {
+	return context.WithValue(ctx, authorKey, author)
+}
+
+// HTTPSignatureVerifierMw returns a middleware that verifies the HTTP signatures of the incoming requests
+// using the P.VerifyHTTPSignature method, and stores the actor that signed them in the request context.
+// When the activity in the request body has a valid FEP-8b32 integrity proof created by its actor, the actor
+// is stored instead, and the proof gets saved.
+// Unsigned requests are passed through, while requests with invalid signatures are rejected.
+// The bodies of all the requests are limited to the maximum size set with WithMaxBodySize.
+func HTTPSignatureVerifierMw(p *P) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Body != nil && r.Body != http.NoBody {
+				r.Body = http.MaxBytesReader(w, r.Body, p.maxRequestBodySize())
+			}
+			author, err := p.VerifyHTTPSignature(r)
+			if err != nil {
+				p.l.Warnf("invalid HTTP signature: %s", err)
+				handleError(err).ServeHTTP(w, r)
+				return
+			}
+			// NOTE(marius): activities forwarded by other servers than the one of their actor can be
+			// authenticated using their integrity proofs.
+			if signer := p.integrityProofSigner(r); signer != nil {
+				author = signer
+			}
+			if author != nil {
+				r = r.WithContext(ContextWithAuthor(r.Context(), *author))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
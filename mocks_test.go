@@ -1,6 +1,7 @@
 package processing
 
 import (
+	"encoding/json"
 	"sync"
 
 	vocab "github.com/go-ap/activitypub"
@@ -91,3 +92,43 @@ func (m mockStore) RemoveFrom(col vocab.IRI, items ...vocab.Item) error {
 }
 
 var _ Store = &mockStore{}
+
+// proofKey is the key under which mockStore keeps the integrity proof of an activity.
+type proofKey vocab.IRI
+
+func (m mockStore) SaveProof(iri vocab.IRI, proof json.RawMessage) error {
+	m.Map.Store(proofKey(iri), proof)
+	return nil
+}
+
+func (m mockStore) LoadProof(iri vocab.IRI) (json.RawMessage, error) {
+	proof, ok := m.Map.Load(proofKey(iri))
+	if !ok {
+		return nil, errors.NotFoundf("proof of %s not found in mock storage", iri)
+	}
+	return proof.(json.RawMessage), nil
+}
+
+// loadCountingStore is a Store which counts the loads of each IRI.
+type loadCountingStore struct {
+	Store
+	m     *sync.Mutex
+	loads map[vocab.IRI]int
+}
+
+func newLoadCountingStore(s Store) loadCountingStore {
+	return loadCountingStore{Store: s, m: &sync.Mutex{}, loads: make(map[vocab.IRI]int)}
+}
+
+func (s loadCountingStore) Load(iri vocab.IRI, f ...filters.Check) (vocab.Item, error) {
+	s.m.Lock()
+	s.loads[iri]++
+	s.m.Unlock()
+	return s.Store.Load(iri, f...)
+}
+
+func (s loadCountingStore) count(iri vocab.IRI) int {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.loads[iri]
+}
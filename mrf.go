@@ -0,0 +1,324 @@
+package processing
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+// MRFAction is the verdict of an MRFPolicy for an inbound activity.
+type MRFAction uint8
+
+const (
+	// MRFAccept lets the activity continue through the pipeline, and then through the regular processing.
+	MRFAccept MRFAction = iota
+	// MRFReject stops the processing of the activity, which is refused with a forbidden error.
+	MRFReject
+	// MRFQuarantine stops the processing of the activity, which is held in the quarantine collection
+	// of the server until a moderator decides what happens with it.
+	MRFQuarantine
+)
+
+// MRFPolicy is a Message Rewrite Facility policy, which gets applied to the activities received from other
+// servers, after they have been validated, and before any of their side effects are executed.
+//
+// A policy can return a modified version of the activity, which is passed to the next policies in the chain,
+// and which gets processed instead of the received one.
+type MRFPolicy interface {
+	Filter(it vocab.Item) (vocab.Item, MRFAction)
+}
+
+// MRFPolicyFn is a function that implements the MRFPolicy interface.
+type MRFPolicyFn func(it vocab.Item) (vocab.Item, MRFAction)
+
+func (fn MRFPolicyFn) Filter(it vocab.Item) (vocab.Item, MRFAction) {
+	return fn(it)
+}
+
+// applyMRFPolicies runs the "it" activity through the processor's chain of MRF policies, in order.
+// The chain stops at the first policy that doesn't accept the activity.
+func (p P) applyMRFPolicies(it vocab.Item) (vocab.Item, MRFAction, error) {
+	if len(p.mrf) == 0 {
+		return it, MRFAccept, nil
+	}
+
+	// NOTE(marius): the policies are allowed to modify the activity in place, so we're giving them a copy, as some
+	// of the embedded objects might have been loaded from the local storage.
+	raw, err := vocab.MarshalJSON(it)
+	if err != nil {
+		return it, MRFReject, errors.Annotatef(err, "unable to copy %s", it.GetLink())
+	}
+	filtered, err := vocab.UnmarshalJSON(raw)
+	if err != nil {
+		return it, MRFReject, errors.Annotatef(err, "unable to copy %s", it.GetLink())
+	}
+
+	for i, policy := range p.mrf {
+		var action MRFAction
+		if filtered, action = policy.Filter(filtered); action != MRFAccept {
+			p.l.Debugf("Activity %s was not accepted by MRF policy %d (%T)", it.GetLink(), i, policy)
+			return it, action, nil
+		}
+		if vocab.IsNil(filtered) {
+			return it, MRFReject, errors.Newf("MRF policy %d (%T) returned an empty activity", i, policy)
+		}
+	}
+	return filtered, MRFAccept, nil
+}
+
+// mrfObjects calls "fn" for each of the objects of the "it" activity which are embedded in it.
+// For intransitive activities, like Questions, it's the activity itself that gets passed.
+func mrfObjects(it vocab.Item, fn func(ob *vocab.Object) error) {
+	if vocab.IsNil(it) {
+		return
+	}
+	if !vocab.ActivityTypes.Match(it.GetType()) {
+		_ = vocab.OnObject(it, fn)
+		return
+	}
+	_ = vocab.OnActivity(it, func(act *vocab.Activity) error {
+		return vocab.OnItem(act.Object, func(ob vocab.Item) error {
+			if vocab.IsIRI(ob) {
+				return nil
+			}
+			_ = vocab.OnObject(ob, fn)
+			return nil
+		})
+	})
+}
+
+// objectText returns the natural language values of the name, summary and content of the "ob" object.
+func objectText(ob *vocab.Object) []string {
+	text := make([]string, 0)
+	for _, nlv := range []vocab.NaturalLanguageValues{ob.Name, ob.Summary, ob.Content} {
+		for _, v := range nlv {
+			text = append(text, string(v))
+		}
+	}
+	return text
+}
+
+// tagName returns the name of the "tag" object or link, eg: "#fediverse" for a hashtag.
+func tagName(tag vocab.Item) string {
+	var name vocab.NaturalLanguageValues
+	if vocab.MentionType.Match(tag.GetType()) || vocab.LinkType.Match(tag.GetType()) {
+		_ = vocab.OnLink(tag, func(l *vocab.Link) error {
+			name = l.Name
+			return nil
+		})
+	} else {
+		_ = vocab.OnObject(tag, func(ob *vocab.Object) error {
+			name = ob.Name
+			return nil
+		})
+	}
+	for _, v := range name {
+		return string(v)
+	}
+	return ""
+}
+
+// HashtagType is the type used by most ActivityPub servers for the hashtags of an object.
+const HashtagType = vocab.ActivityVocabularyType("Hashtag")
+
+func isHashtag(tag vocab.Item) bool {
+	return HashtagType.Match(tag.GetType()) || strings.HasPrefix(tagName(tag), "#")
+}
+
+func isMention(tag vocab.Item) bool {
+	return vocab.MentionType.Match(tag.GetType())
+}
+
+func countTags(ob *vocab.Object, match func(vocab.Item) bool) int {
+	count := 0
+	for _, tag := range ob.Tag {
+		if !vocab.IsNil(tag) && match(tag) {
+			count++
+		}
+	}
+	return count
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+// containsWord checks if "text" contains "word" as a whole word, ignoring the case.
+func containsWord(text, word string) bool {
+	if len(word) == 0 {
+		return false
+	}
+	text = strings.ToLower(text)
+	word = strings.ToLower(word)
+	for start := 0; start < len(text); {
+		i := strings.Index(text[start:], word)
+		if i < 0 {
+			return false
+		}
+		i += start
+		end := i + len(word)
+		prev, _ := utf8.DecodeLastRuneInString(text[:i])
+		next, _ := utf8.DecodeRuneInString(text[end:])
+		startsWord := i == 0 || !isWordRune(prev)
+		endsWord := end == len(text) || !isWordRune(next)
+		if startsWord && endsWord {
+			return true
+		}
+		start = i + 1
+	}
+	return false
+}
+
+// matchesTextFn returns an MRFPolicy which applies "action" to the activities that have any objects for which
+// the "match" function returns true for any of their name, summary or content values.
+func matchesTextFn(action MRFAction, match func(string) bool) MRFPolicy {
+	return MRFPolicyFn(func(it vocab.Item) (vocab.Item, MRFAction) {
+		matched := false
+		mrfObjects(it, func(ob *vocab.Object) error {
+			for _, text := range objectText(ob) {
+				if matched = match(text); matched {
+					return errors.Newf("matched")
+				}
+			}
+			return nil
+		})
+		if matched {
+			return it, action
+		}
+		return it, MRFAccept
+	})
+}
+
+// KeywordMRFPolicy returns an MRFPolicy which applies "action" to the activities whose objects contain any of
+// the "keywords" as whole words, ignoring the case.
+func KeywordMRFPolicy(action MRFAction, keywords ...string) MRFPolicy {
+	return matchesTextFn(action, func(text string) bool {
+		for _, k := range keywords {
+			if containsWord(text, k) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// RegexMRFPolicy returns an MRFPolicy which applies "action" to the activities whose objects match any
+// of the "patterns" regular expressions.
+func RegexMRFPolicy(action MRFAction, patterns ...*regexp.Regexp) MRFPolicy {
+	return matchesTextFn(action, func(text string) bool {
+		for _, r := range patterns {
+			if r != nil && r.MatchString(text) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// tagCountFn returns an MRFPolicy which applies "action" to the activities that have objects with more
+// than "max" tags for which "match" returns true.
+func tagCountFn(max int, action MRFAction, match func(vocab.Item) bool) MRFPolicy {
+	return MRFPolicyFn(func(it vocab.Item) (vocab.Item, MRFAction) {
+		exceeded := false
+		mrfObjects(it, func(ob *vocab.Object) error {
+			exceeded = exceeded || countTags(ob, match) > max
+			return nil
+		})
+		if exceeded {
+			return it, action
+		}
+		return it, MRFAccept
+	})
+}
+
+// HashtagCountMRFPolicy returns an MRFPolicy which applies "action" to the activities whose objects have
+// more than "max" hashtags.
+func HashtagCountMRFPolicy(max int, action MRFAction) MRFPolicy {
+	return tagCountFn(max, action, isHashtag)
+}
+
+// MentionCountMRFPolicy returns an MRFPolicy which applies "action" to the activities whose objects have
+// more than "max" mentions.
+func MentionCountMRFPolicy(max int, action MRFAction) MRFPolicy {
+	return tagCountFn(max, action, isMention)
+}
+
+// StripMediaMRFPolicy removes the attachments of the objects of the activities.
+var StripMediaMRFPolicy = MRFPolicyFn(func(it vocab.Item) (vocab.Item, MRFAction) {
+	mrfObjects(it, func(ob *vocab.Object) error {
+		ob.Attachment = nil
+		return nil
+	})
+	return it, MRFAccept
+})
+
+// ContentWarningMRFPolicy returns an MRFPolicy which marks the objects of the activities as sensitive,
+// by setting their summary to "warning", when they don't have one already.
+//
+// NOTE(marius): the ActivityPub vocabulary doesn't have a "sensitive" property, but most servers
+// display the summary of an object as a content warning.
+func ContentWarningMRFPolicy(warning string) MRFPolicy {
+	return MRFPolicyFn(func(it vocab.Item) (vocab.Item, MRFAction) {
+		mrfObjects(it, func(ob *vocab.Object) error {
+			if len(ob.Summary) == 0 {
+				ob.Summary = vocab.DefaultNaturalLanguage(warning)
+			}
+			return nil
+		})
+		return it, MRFAccept
+	})
+}
+
+func withoutIRIs(col vocab.ItemCollection, iris vocab.IRIs) vocab.ItemCollection {
+	if len(col) == 0 {
+		return col
+	}
+	result := make(vocab.ItemCollection, 0, len(col))
+	for _, it := range col {
+		if !iris.Contains(it.GetLink()) {
+			result = append(result, it)
+		}
+	}
+	return result
+}
+
+// DropMentionsMRFPolicy removes the mentions from the objects of the activities, and the mentioned actors
+// from the recipients of both the activities and their objects, so they don't get notified.
+var DropMentionsMRFPolicy = MRFPolicyFn(func(it vocab.Item) (vocab.Item, MRFAction) {
+	mentioned := make(vocab.IRIs, 0)
+	mrfObjects(it, func(ob *vocab.Object) error {
+		tags := make(vocab.ItemCollection, 0, len(ob.Tag))
+		for _, tag := range ob.Tag {
+			if vocab.IsNil(tag) || !isMention(tag) {
+				tags = append(tags, tag)
+				continue
+			}
+			_ = vocab.OnLink(tag, func(l *vocab.Link) error {
+				if len(l.Href) > 0 && !mentioned.Contains(l.Href) {
+					_ = mentioned.Append(l.Href)
+				}
+				return nil
+			})
+		}
+		ob.Tag = tags
+		return nil
+	})
+	if len(mentioned) == 0 {
+		return it, MRFAccept
+	}
+
+	dropRecipients := func(ob *vocab.Object) error {
+		ob.To = withoutIRIs(ob.To, mentioned)
+		ob.CC = withoutIRIs(ob.CC, mentioned)
+		ob.Bto = withoutIRIs(ob.Bto, mentioned)
+		ob.BCC = withoutIRIs(ob.BCC, mentioned)
+		return nil
+	}
+	mrfObjects(it, dropRecipients)
+	_ = vocab.OnObject(it, dropRecipients)
+	return it, MRFAccept
+})
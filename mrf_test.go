@@ -0,0 +1,199 @@
+package processing
+
+import (
+	"regexp"
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_containsWord(t *testing.T) {
+	tests := []struct {
+		text string
+		word string
+		want bool
+	}{
+		{text: "Buy cheap pills", word: "cheap", want: true},
+		{text: "Buy CHEAP pills", word: "cheap", want: true},
+		{text: "cheapest pills", word: "cheap", want: false},
+		{text: "not so cheap", word: "cheap", want: true},
+		{text: "cheap-ish, cheaper, cheap!", word: "cheap", want: true},
+		{text: "ölçek", word: "çek", want: false},
+		{text: "anything", word: "", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			if got := containsWord(tt.text, tt.word); got != tt.want {
+				t.Errorf("containsWord(%q, %q) = %v, want %v", tt.text, tt.word, got, tt.want)
+			}
+		})
+	}
+}
+
+func mrfCreate(ob vocab.Item) *vocab.Activity {
+	return &vocab.Activity{
+		ID:     "https://remote.example.com/activities/1",
+		Type:   vocab.CreateType,
+		Actor:  vocab.IRI("https://remote.example.com/~jdoe"),
+		To:     vocab.ItemCollection{vocab.PublicNS},
+		Object: ob,
+	}
+}
+
+func hashtag(name string) vocab.Item {
+	return &vocab.Object{Type: HashtagType, Name: vocab.DefaultNaturalLanguage(name)}
+}
+
+func mention(href vocab.IRI) vocab.Item {
+	return &vocab.Link{Type: vocab.MentionType, Href: href, Name: vocab.DefaultNaturalLanguage("@" + href.String())}
+}
+
+func TestMRFPolicy_Filter(t *testing.T) {
+	spam := &vocab.Object{
+		ID:      "https://remote.example.com/objects/1",
+		Type:    vocab.NoteType,
+		Content: vocab.DefaultNaturalLanguage("Buy cheap pills now!"),
+		Tag:     vocab.ItemCollection{hashtag("#pills"), hashtag("#cheap"), mention(defaultActorID)},
+	}
+
+	tests := []struct {
+		name   string
+		policy MRFPolicy
+		it     vocab.Item
+		want   MRFAction
+	}{
+		{
+			name:   "keyword matches",
+			policy: KeywordMRFPolicy(MRFReject, "pills"),
+			it:     mrfCreate(spam),
+			want:   MRFReject,
+		},
+		{
+			name:   "keyword doesn't match partial words",
+			policy: KeywordMRFPolicy(MRFReject, "pill"),
+			it:     mrfCreate(spam),
+			want:   MRFAccept,
+		},
+		{
+			name:   "regex matches",
+			policy: RegexMRFPolicy(MRFReject, regexp.MustCompile(`(?i)buy\s+cheap`)),
+			it:     mrfCreate(spam),
+			want:   MRFReject,
+		},
+		{
+			name:   "object IRI is not matched",
+			policy: KeywordMRFPolicy(MRFReject, "pills"),
+			it:     mrfCreate(spam.ID),
+			want:   MRFAccept,
+		},
+		{
+			name:   "hashtag count under limit",
+			policy: HashtagCountMRFPolicy(2, MRFReject),
+			it:     mrfCreate(spam),
+			want:   MRFAccept,
+		},
+		{
+			name:   "hashtag count over limit",
+			policy: HashtagCountMRFPolicy(1, MRFReject),
+			it:     mrfCreate(spam),
+			want:   MRFReject,
+		},
+		{
+			name:   "mention count over limit",
+			policy: MentionCountMRFPolicy(0, MRFReject),
+			it:     mrfCreate(spam),
+			want:   MRFReject,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, got := tt.policy.Filter(tt.it); got != tt.want {
+				t.Errorf("Filter() action = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDropMentionsMRFPolicy(t *testing.T) {
+	mentioned := vocab.IRI("https://example.com/~alice")
+	tag := hashtag("#fediverse")
+	ob := &vocab.Object{
+		ID:   "https://remote.example.com/objects/1",
+		Type: vocab.NoteType,
+		To:   vocab.ItemCollection{vocab.PublicNS, mentioned},
+		Tag:  vocab.ItemCollection{tag, mention(mentioned)},
+	}
+	act := mrfCreate(ob)
+	act.To = vocab.ItemCollection{vocab.PublicNS, mentioned}
+
+	it, action := DropMentionsMRFPolicy.Filter(act)
+	if action != MRFAccept {
+		t.Fatalf("Filter() action = %v, want %v", action, MRFAccept)
+	}
+	_ = vocab.OnActivity(it, func(act *vocab.Activity) error {
+		if want := (vocab.ItemCollection{vocab.PublicNS}); !cmp.Equal(act.To, want) {
+			t.Errorf("activity recipients = %s", cmp.Diff(want, act.To))
+		}
+		return nil
+	})
+	if want := (vocab.ItemCollection{vocab.PublicNS}); !cmp.Equal(ob.To, want) {
+		t.Errorf("object recipients = %s", cmp.Diff(want, ob.To))
+	}
+	if want := (vocab.ItemCollection{tag}); !cmp.Equal(ob.Tag, want) {
+		t.Errorf("object tags = %s", cmp.Diff(want, ob.Tag))
+	}
+}
+
+func TestP_applyMRFPolicies(t *testing.T) {
+	base := vocab.IRI("https://example.com")
+
+	t.Run("rewrites a copy of the activity", func(t *testing.T) {
+		p := mockProcessor(t, base)
+		p.mrf = []MRFPolicy{StripMediaMRFPolicy, ContentWarningMRFPolicy("spoilers")}
+
+		ob := &vocab.Object{
+			ID:         "https://remote.example.com/objects/1",
+			Type:       vocab.NoteType,
+			Attachment: vocab.IRI("https://remote.example.com/media/1.png"),
+		}
+		act := mrfCreate(ob)
+
+		it, action, err := p.applyMRFPolicies(act)
+		if err != nil || action != MRFAccept {
+			t.Fatalf("applyMRFPolicies() = %v, %s, want %v", action, err, MRFAccept)
+		}
+		_ = vocab.OnActivity(it, func(act *vocab.Activity) error {
+			return vocab.OnObject(act.Object, func(filtered *vocab.Object) error {
+				if filtered.Attachment != nil {
+					t.Errorf("attachment has not been removed: %v", filtered.Attachment)
+				}
+				if summary := string(filtered.Summary.First()); summary != "spoilers" {
+					t.Errorf("summary = %q, want %q", summary, "spoilers")
+				}
+				return nil
+			})
+		})
+		if ob.Attachment == nil || len(ob.Summary) > 0 {
+			t.Errorf("the received object has been modified: %v", ob)
+		}
+	})
+
+	t.Run("stops at the first rejection", func(t *testing.T) {
+		p := mockProcessor(t, base)
+		called := false
+		p.mrf = []MRFPolicy{
+			MRFPolicyFn(func(it vocab.Item) (vocab.Item, MRFAction) { return it, MRFReject }),
+			MRFPolicyFn(func(it vocab.Item) (vocab.Item, MRFAction) {
+				called = true
+				return it, MRFAccept
+			}),
+		}
+		if _, action, _ := p.applyMRFPolicies(mrfCreate(vocab.IRI("https://remote.example.com/objects/1"))); action != MRFReject {
+			t.Errorf("applyMRFPolicies() action = %v, want %v", action, MRFReject)
+		}
+		if called {
+			t.Errorf("the policies after the rejection have been applied")
+		}
+	})
+}
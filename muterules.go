@@ -0,0 +1,319 @@
+package processing
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+// MuteRulesCollection is the hidden collection of an actor which contains its mute rules.
+const MuteRulesCollection = vocab.CollectionPath("filters")
+
+// MuteRuleType is the type of a mute rule, which determines how its value is matched against the activities.
+type MuteRuleType string
+
+const (
+	// MuteWord rules match the activities whose objects contain the value as a whole word, ignoring the case.
+	MuteWord MuteRuleType = "word"
+	// MuteRegex rules match the activities whose objects match the value as a regular expression.
+	MuteRegex MuteRuleType = "regex"
+	// MuteHashtag rules match the activities whose objects are tagged with the value as a hashtag.
+	MuteHashtag MuteRuleType = "hashtag"
+	// MuteContext rules match the activities which belong to the value as a thread: they have it as
+	// their object, as their context, or they are replying to it.
+	MuteContext MuteRuleType = "context"
+)
+
+const (
+	// maxMuteRuleLength is the maximum length of the value of a mute rule.
+	maxMuteRuleLength = 512
+	// maxMuteRuleRegexLength is the maximum length of the regular expression of a MuteRegex rule.
+	maxMuteRuleRegexLength = 128
+)
+
+// MuteRule is a rule of a local actor for hiding the activities delivered to its inbox, based on their content.
+type MuteRule struct {
+	// ID is the IRI of the object that stores the rule.
+	ID vocab.IRI
+	// Type determines how the Value is matched.
+	Type MuteRuleType
+	// Value is the word, regular expression, hashtag or context IRI the rule matches.
+	Value string
+	// Expires is the moment after which the rule isn't applied anymore. A zero value means it never expires.
+	Expires time.Time
+
+	re *regexp.Regexp
+}
+
+// Expired returns true if the rule is expired at the "now" moment.
+func (r MuteRule) Expired(now time.Time) bool {
+	return !r.Expires.IsZero() && r.Expires.Before(now)
+}
+
+func (r *MuteRule) validate() error {
+	r.Value = strings.TrimSpace(r.Value)
+	if r.Type == MuteHashtag {
+		r.Value = strings.TrimPrefix(r.Value, "#")
+	}
+	if len(r.Value) == 0 {
+		return errors.BadRequestf("empty mute rule value")
+	}
+	if len(r.Value) > maxMuteRuleLength {
+		return errors.BadRequestf("mute rule value exceeds the maximum length of %d", maxMuteRuleLength)
+	}
+	switch r.Type {
+	case MuteWord, MuteHashtag, MuteContext:
+	case MuteRegex:
+		if len(r.Value) > maxMuteRuleRegexLength {
+			return errors.BadRequestf("mute rule expression exceeds the maximum length of %d", maxMuteRuleRegexLength)
+		}
+		re, err := regexp.Compile(r.Value)
+		if err != nil {
+			return errors.NewBadRequest(err, "invalid mute rule expression %s", r.Value)
+		}
+		r.re = re
+	default:
+		return errors.BadRequestf("invalid mute rule type %q", r.Type)
+	}
+	return nil
+}
+
+// Matches checks if the "it" activity matches the rule.
+func (r MuteRule) Matches(it vocab.Item) bool {
+	if vocab.IsNil(it) {
+		return false
+	}
+	return r.matches(it, muteTargets(it))
+}
+
+// matches checks if the "it" activity, which is related to the "targets" items, matches the rule.
+// See muteTargets.
+func (r MuteRule) matches(it vocab.Item, targets vocab.IRIs) bool {
+	if r.Type == MuteContext {
+		return targets.Contains(vocab.IRI(r.Value))
+	}
+
+	matched := false
+	mrfObjects(it, func(ob *vocab.Object) error {
+		switch r.Type {
+		case MuteWord:
+			for _, text := range objectText(ob) {
+				if matched = containsWord(text, r.Value); matched {
+					break
+				}
+			}
+		case MuteRegex:
+			for _, text := range objectText(ob) {
+				if matched = r.re != nil && r.re.MatchString(text); matched {
+					break
+				}
+			}
+		case MuteHashtag:
+			for _, tag := range ob.Tag {
+				if matched = !vocab.IsNil(tag) && isHashtag(tag) && strings.EqualFold(strings.TrimPrefix(tagName(tag), "#"), r.Value); matched {
+					break
+				}
+			}
+		}
+		if matched {
+			return errors.Newf("matched")
+		}
+		return nil
+	})
+	return matched
+}
+
+// muteRulesCache keeps the mute rules of the local actors, with their expressions compiled, so they don't
+// get loaded from storage for every activity delivered to the actors.
+type muteRulesCache struct {
+	m     sync.RWMutex
+	rules map[vocab.IRI][]MuteRule
+}
+
+func newMuteRulesCache() *muteRulesCache {
+	return &muteRulesCache{rules: make(map[vocab.IRI][]MuteRule)}
+}
+
+func (c *muteRulesCache) get(actor vocab.IRI) ([]MuteRule, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.m.RLock()
+	defer c.m.RUnlock()
+	rules, ok := c.rules[actor]
+	return rules, ok
+}
+
+func (c *muteRulesCache) set(actor vocab.IRI, rules []MuteRule) {
+	if c == nil {
+		return
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.rules[actor] = rules
+}
+
+func (c *muteRulesCache) invalidate(actor vocab.IRI) {
+	if c == nil {
+		return
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+	delete(c.rules, actor)
+}
+
+// muteRuleObject returns the ActivityStreams object which stores the "r" rule of the "actor".
+//
+// NOTE(marius): the vocabulary doesn't have a type for this, so we're using a plain Object, with the value
+// of the rule as its name, its type as its summary, and its expiration as its end time.
+func muteRuleObject(actor vocab.IRI, r MuteRule) *vocab.Object {
+	return &vocab.Object{
+		ID:           r.ID,
+		Type:         vocab.ObjectType,
+		AttributedTo: actor,
+		Name:         vocab.DefaultNaturalLanguage(r.Value),
+		Summary:      vocab.DefaultNaturalLanguage(string(r.Type)),
+		EndTime:      r.Expires,
+		Published:    time.Now().UTC(),
+	}
+}
+
+// muteRuleFromObject is the reverse of muteRuleObject.
+func muteRuleFromObject(it vocab.Item) (MuteRule, error) {
+	r := MuteRule{}
+	err := vocab.OnObject(it, func(ob *vocab.Object) error {
+		r.ID = ob.ID
+		r.Value = string(ob.Name.First())
+		r.Type = MuteRuleType(ob.Summary.First())
+		r.Expires = ob.EndTime
+		return nil
+	})
+	if err != nil {
+		return r, err
+	}
+	return r, r.validate()
+}
+
+// AddMuteRule saves the "rule" in the mute rules collection of the local "actor", and returns it with its ID set.
+// Adding a rule which has the same type and value as an existing one replaces it.
+func (p P) AddMuteRule(actor vocab.IRI, rule MuteRule) (MuteRule, error) {
+	if !p.IsLocalIRI(actor) {
+		return rule, errors.BadRequestf("%s is not a local actor", actor)
+	}
+	if err := rule.validate(); err != nil {
+		return rule, err
+	}
+
+	col := MuteRulesCollection.IRI(actor)
+	if err := p.saveCollectionObjectForParent(actor, blankOrderedCollection(col)); err != nil {
+		return rule, errors.Annotatef(err, "unable to create mute rules collection %s", col)
+	}
+	hash := sha256.Sum256([]byte(string(rule.Type) + ":" + rule.Value))
+	rule.ID = col.AddPath(fmt.Sprintf("%x", hash[:8]))
+
+	if _, err := p.s.Save(muteRuleObject(actor, rule)); err != nil {
+		return rule, errors.Annotatef(err, "unable to save mute rule")
+	}
+	p.muteRules.invalidate(actor)
+	if err := p.s.AddTo(col, rule.ID); err != nil && !errors.IsConflict(err) {
+		return rule, errors.Annotatef(err, "unable to add mute rule to %s", col)
+	}
+	return rule, nil
+}
+
+// RemoveMuteRule removes the "rule" from the mute rules of the local "actor".
+func (p P) RemoveMuteRule(actor vocab.IRI, rule vocab.IRI) error {
+	col := MuteRulesCollection.IRI(actor)
+	p.muteRules.invalidate(actor)
+	if err := p.s.RemoveFrom(col, rule); err != nil && !errors.IsNotFound(err) {
+		return errors.Annotatef(err, "unable to remove mute rule from %s", col)
+	}
+	if err := p.s.Delete(rule); err != nil && !errors.IsNotFound(err) {
+		return errors.Annotatef(err, "unable to delete mute rule %s", rule)
+	}
+	return nil
+}
+
+// loadMuteRules returns all the mute rules of the local "actor", including the expired ones.
+// The rules are loaded from storage only if they're missing from the processor's cache.
+func (p P) loadMuteRules(actor vocab.IRI) ([]MuteRule, error) {
+	if rules, ok := p.muteRules.get(actor); ok {
+		return rules, nil
+	}
+	rules := make([]MuteRule, 0)
+	err := p.walkCollection(MuteRulesCollection.IRI(actor), func(it vocab.Item) error {
+		r, err := muteRuleFromObject(p.loadLocalCopy(it))
+		if err != nil {
+			p.l.Warnf("invalid mute rule %s: %s", it.GetLink(), err)
+			return nil
+		}
+		rules = append(rules, r)
+		return nil
+	})
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, err
+	}
+	p.muteRules.set(actor, rules)
+	return rules, nil
+}
+
+// MuteRules returns the mute rules of the local "actor" which have not expired.
+// The expired ones get removed by PurgeExpiredMutes.
+func (p P) MuteRules(actor vocab.IRI) ([]MuteRule, error) {
+	all, err := p.loadMuteRules(actor)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	rules := make([]MuteRule, 0, len(all))
+	for _, r := range all {
+		if !r.Expired(now) {
+			rules = append(rules, r)
+		}
+	}
+	return rules, nil
+}
+
+// purgeExpiredMuteRules removes the mute rules of the local "actor" which have expired.
+func (p P) purgeExpiredMuteRules(actor vocab.IRI) error {
+	all, err := p.loadMuteRules(actor)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	errs := make([]error, 0)
+	for _, r := range all {
+		if !r.Expired(now) {
+			continue
+		}
+		if err = p.RemoveMuteRule(actor, r.ID); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// matchesMuteRules checks if the "it" activity, which is related to the "targets" items, matches any of
+// the mute rules of the local "actor".
+// The activities of the actor itself are never muted.
+func (p P) matchesMuteRules(actor vocab.IRI, it vocab.Item, targets vocab.IRIs) bool {
+	if itemOwners(it).Contains(actor) {
+		return false
+	}
+	rules, err := p.MuteRules(actor)
+	if err != nil {
+		return false
+	}
+	for _, r := range rules {
+		if r.matches(it, targets) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,152 @@
+package processing
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+func TestMuteRule_Matches(t *testing.T) {
+	note := &vocab.Object{
+		ID:        "https://remote.example.com/objects/2",
+		Type:      vocab.NoteType,
+		Content:   vocab.DefaultNaturalLanguage("Spoilers for the season finale"),
+		Tag:       vocab.ItemCollection{hashtag("#TVShows")},
+		InReplyTo: vocab.IRI("https://remote.example.com/objects/1"),
+		Context:   vocab.IRI("https://remote.example.com/contexts/1"),
+	}
+	act := mrfCreate(note)
+
+	tests := []struct {
+		name string
+		rule MuteRule
+		want bool
+	}{
+		{name: "word", rule: MuteRule{Type: MuteWord, Value: "spoilers"}, want: true},
+		{name: "partial word", rule: MuteRule{Type: MuteWord, Value: "spoil"}, want: false},
+		{name: "regex", rule: MuteRule{Type: MuteRegex, Value: `season\s+finale`}, want: true},
+		{name: "regex not matching", rule: MuteRule{Type: MuteRegex, Value: `^finale`}, want: false},
+		{name: "hashtag", rule: MuteRule{Type: MuteHashtag, Value: "#tvshows"}, want: true},
+		{name: "hashtag without sign", rule: MuteRule{Type: MuteHashtag, Value: "tvshows"}, want: true},
+		{name: "other hashtag", rule: MuteRule{Type: MuteHashtag, Value: "movies"}, want: false},
+		{name: "context", rule: MuteRule{Type: MuteContext, Value: "https://remote.example.com/contexts/1"}, want: true},
+		{name: "thread root", rule: MuteRule{Type: MuteContext, Value: "https://remote.example.com/objects/1"}, want: true},
+		{name: "other context", rule: MuteRule{Type: MuteContext, Value: "https://remote.example.com/contexts/2"}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.rule.validate(); err != nil {
+				t.Fatalf("validate() error = %s", err)
+			}
+			if got := tt.rule.Matches(act); got != tt.want {
+				t.Errorf("Matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMuteRule_validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    MuteRule
+		wantErr bool
+	}{
+		{name: "word", rule: MuteRule{Type: MuteWord, Value: "spoilers"}},
+		{name: "empty value", rule: MuteRule{Type: MuteWord, Value: " "}, wantErr: true},
+		{name: "empty hashtag", rule: MuteRule{Type: MuteHashtag, Value: "#"}, wantErr: true},
+		{name: "invalid regex", rule: MuteRule{Type: MuteRegex, Value: "(spoilers"}, wantErr: true},
+		{name: "value too long", rule: MuteRule{Type: MuteWord, Value: strings.Repeat("a", maxMuteRuleLength+1)}, wantErr: true},
+		{name: "regex too long", rule: MuteRule{Type: MuteRegex, Value: strings.Repeat("a", maxMuteRuleRegexLength+1)}, wantErr: true},
+		{name: "invalid type", rule: MuteRule{Type: "actor", Value: "https://remote.example.com/~jdoe"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.rule.validate()
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.IsBadRequest(err) {
+				t.Errorf("validate() error = %v, expected bad request", err)
+			}
+		})
+	}
+}
+
+func TestP_MuteRules(t *testing.T) {
+	p := mockProcessor(t, defaultActorID)
+	p.muteRules = newMuteRulesCache()
+
+	word, err := p.AddMuteRule(defaultActorID, MuteRule{Type: MuteWord, Value: "spoilers"})
+	if err != nil {
+		t.Fatalf("AddMuteRule() error = %s", err)
+	}
+	if _, err = p.AddMuteRule(defaultActorID, MuteRule{Type: MuteHashtag, Value: "movies", Expires: time.Now().Add(-time.Hour)}); err != nil {
+		t.Fatalf("AddMuteRule() error = %s", err)
+	}
+	if _, err = p.AddMuteRule(vocab.IRI("https://remote.example.com/~jdoe"), MuteRule{Type: MuteWord, Value: "spoilers"}); err == nil {
+		t.Errorf("AddMuteRule() for remote actor expected error")
+	}
+
+	rules, err := p.MuteRules(defaultActorID)
+	if err != nil {
+		t.Fatalf("MuteRules() error = %s", err)
+	}
+	if len(rules) != 1 || !rules[0].ID.Equal(word.ID) || rules[0].Type != MuteWord || rules[0].Value != "spoilers" {
+		t.Fatalf("MuteRules() = %v, want only %s", rules, word.ID)
+	}
+	countRules := func() int {
+		count := 0
+		_ = p.walkCollection(MuteRulesCollection.IRI(defaultActor), func(it vocab.Item) error {
+			count++
+			return nil
+		})
+		return count
+	}
+	if count := countRules(); count != 2 {
+		t.Errorf("MuteRules() expected the expired rule to be kept until purged, collection has %d items", count)
+	}
+	if err = p.PurgeExpiredMutes(defaultActorID); err != nil {
+		t.Fatalf("PurgeExpiredMutes() error = %s", err)
+	}
+	if count := countRules(); count != 1 {
+		t.Errorf("PurgeExpiredMutes() expected expired rule to be removed, collection has %d items", count)
+	}
+
+	if err = p.RemoveMuteRule(defaultActorID, word.ID); err != nil {
+		t.Fatalf("RemoveMuteRule() error = %s", err)
+	}
+	if rules, _ = p.MuteRules(defaultActorID); len(rules) != 0 {
+		t.Errorf("MuteRules() = %v after removal, want none", rules)
+	}
+}
+
+func TestP_removeMutingRecipients_muteRules(t *testing.T) {
+	p := mockProcessor(t, defaultActorID)
+	if _, err := p.AddMuteRule(defaultActorID, MuteRule{Type: MuteRegex, Value: regexp.QuoteMeta("finale")}); err != nil {
+		t.Fatalf("AddMuteRule() error = %s", err)
+	}
+
+	muted := mrfCreate(&vocab.Object{
+		ID:      "https://remote.example.com/objects/1",
+		Type:    vocab.NoteType,
+		Content: vocab.DefaultNaturalLanguage("Spoilers for the season finale"),
+	})
+	allowed := mrfCreate(&vocab.Object{
+		ID:      "https://remote.example.com/objects/2",
+		Type:    vocab.NoteType,
+		Content: vocab.DefaultNaturalLanguage("Hello world"),
+	})
+	allowed.ID = "https://remote.example.com/activities/2"
+
+	inbox := vocab.Inbox.IRI(defaultActor)
+	if recipients := p.removeMutingRecipients(muted, vocab.ItemCollection{inbox}); recipients.Contains(inbox) {
+		t.Errorf("removeMutingRecipients() kept recipient %s for muted activity %s", inbox, muted.ID)
+	}
+	if recipients := p.removeMutingRecipients(allowed, vocab.ItemCollection{inbox}); !recipients.Contains(inbox) {
+		t.Errorf("removeMutingRecipients() removed recipient %s for activity %s", inbox, allowed.ID)
+	}
+}
@@ -0,0 +1,266 @@
+package processing
+
+import (
+	"sync"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+// maxMuteThreadDepth is the maximum number of ancestors of an object that get checked for muted conversations.
+const maxMuteThreadDepth = 32
+
+// mute is an item muted by a local actor, until the "expires" moment, if that is not zero.
+type mute struct {
+	target  vocab.IRI
+	expires time.Time
+}
+
+// mutesCache keeps the items muted by the local actors, so their ignored collections don't get loaded
+// from storage for every activity delivered to the actors.
+type mutesCache struct {
+	m     sync.RWMutex
+	mutes map[vocab.IRI][]mute
+}
+
+func newMutesCache() *mutesCache {
+	return &mutesCache{mutes: make(map[vocab.IRI][]mute)}
+}
+
+func (c *mutesCache) get(actor vocab.IRI) ([]mute, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.m.RLock()
+	defer c.m.RUnlock()
+	mutes, ok := c.mutes[actor]
+	return mutes, ok
+}
+
+func (c *mutesCache) set(actor vocab.IRI, mutes []mute) {
+	if c == nil {
+		return
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.mutes[actor] = mutes
+}
+
+func (c *mutesCache) invalidate(actor vocab.IRI) {
+	if c == nil {
+		return
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+	delete(c.mutes, actor)
+}
+
+// loadMutes loads the items that the local "actor" has muted using Ignore activities from its ignored collection.
+func (p P) loadMutes(actor vocab.IRI) []mute {
+	if mutes, ok := p.mutes.get(actor); ok {
+		return mutes
+	}
+	mutes := make([]mute, 0)
+	_ = p.walkCollection(IgnoredCollection.IRI(actor), func(it vocab.Item) error {
+		full := p.loadLocalCopy(it)
+		if !vocab.IgnoreType.Match(full.GetType()) {
+			mutes = append(mutes, mute{target: it.GetLink()})
+			return nil
+		}
+		// NOTE(marius): the Ignore activities which have an end time are saved in the ignored collection
+		// instead of their objects. See IgnoreActivity.
+		return vocab.OnActivity(full, func(ignore *vocab.Activity) error {
+			return vocab.OnItem(ignore.Object, func(ob vocab.Item) error {
+				mutes = append(mutes, mute{target: ob.GetLink(), expires: ignore.EndTime})
+				return nil
+			})
+		})
+	})
+	p.mutes.set(actor, mutes)
+	return mutes
+}
+
+// mutedItems returns the IRIs of the items that the local "actor" has muted using Ignore activities:
+// actors, objects, or conversations.
+// The mutes which have expired at the "now" moment are skipped, they get removed by PurgeExpiredMutes.
+func (p P) mutedItems(actor vocab.IRI, now time.Time) vocab.IRIs {
+	muted := make(vocab.IRIs, 0)
+	for _, m := range p.loadMutes(actor) {
+		if !m.expires.IsZero() && m.expires.Before(now) {
+			continue
+		}
+		if !muted.Contains(m.target) {
+			_ = muted.Append(m.target)
+		}
+	}
+	return muted
+}
+
+// PurgeExpiredMutes removes the mutes which have expired from the ignored collection of the local "actor",
+// and the mute rules of the actor which have expired.
+// It is meant to be called periodically as a maintenance task.
+func (p P) PurgeExpiredMutes(actor vocab.IRI) error {
+	now := time.Now().UTC()
+	col := IgnoredCollection.IRI(actor)
+	expired := make(vocab.IRIs, 0)
+	err := p.walkCollection(col, func(it vocab.Item) error {
+		full := p.loadLocalCopy(it)
+		if !vocab.IgnoreType.Match(full.GetType()) {
+			return nil
+		}
+		return vocab.OnActivity(full, func(ignore *vocab.Activity) error {
+			if !ignore.EndTime.IsZero() && ignore.EndTime.Before(now) {
+				return expired.Append(ignore.GetLink())
+			}
+			return nil
+		})
+	})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	errs := make([]error, 0)
+	for _, iri := range expired {
+		if err = p.s.RemoveFrom(col, iri); err != nil && !errors.IsNotFound(err) {
+			errs = append(errs, errors.Annotatef(err, "unable to remove expired mute %s from %s", iri, col))
+		}
+	}
+	if len(expired) > 0 {
+		p.mutes.invalidate(actor)
+	}
+	if err = p.purgeExpiredMuteRules(actor); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// muteTargets returns the IRIs of the items which can be muted for hiding the "it" activity: its actor, its
+// object, the authors of its object, the object it is replying to, and the conversation it is part of.
+func muteTargets(it vocab.Item) vocab.IRIs {
+	targets := make(vocab.IRIs, 0)
+	appendTargets := func(items ...vocab.Item) {
+		for _, it := range items {
+			if vocab.IsNil(it) {
+				continue
+			}
+			_ = vocab.OnItem(it, func(it vocab.Item) error {
+				if iri := it.GetLink(); len(iri) > 0 && !targets.Contains(iri) {
+					_ = targets.Append(iri)
+				}
+				return nil
+			})
+		}
+	}
+	objectTargets := func(ob *vocab.Object) error {
+		appendTargets(ob.AttributedTo, ob.InReplyTo, ob.Context)
+		return nil
+	}
+
+	_ = vocab.OnObject(it, objectTargets)
+	_ = vocab.OnIntransitiveActivity(it, func(act *vocab.IntransitiveActivity) error {
+		appendTargets(act.Actor, act.Target)
+		return nil
+	})
+	if vocab.ActivityTypes.Match(it.GetType()) {
+		_ = vocab.OnActivity(it, func(act *vocab.Activity) error {
+			appendTargets(act.Object)
+			return vocab.OnItem(act.Object, func(ob vocab.Item) error {
+				if !vocab.IsIRI(ob) {
+					_ = vocab.OnObject(ob, objectTargets)
+				}
+				return nil
+			})
+		})
+	}
+	return targets
+}
+
+// threadMuteTargets returns the muteTargets of the "it" activity, together with the ancestors of its object
+// which we have stored locally, and their conversations, so the replies to the replies of a muted object
+// are muted too. At most maxMuteThreadDepth ancestors are looked up.
+func (p P) threadMuteTargets(it vocab.Item) vocab.IRIs {
+	targets := muteTargets(it)
+
+	parents := make(vocab.IRIs, 0)
+	appendParents := func(ob *vocab.Object) error {
+		return vocab.OnItem(ob.InReplyTo, func(parent vocab.Item) error {
+			if iri := parent.GetLink(); len(iri) > 0 && !parents.Contains(iri) {
+				_ = parents.Append(iri)
+				if !targets.Contains(iri) {
+					_ = targets.Append(iri)
+				}
+			}
+			return nil
+		})
+	}
+	mrfObjects(it, appendParents)
+
+	for i := 0; i < len(parents) && i < maxMuteThreadDepth; i++ {
+		parent := p.loadLocalCopy(parents[i])
+		if vocab.IsIRI(parent) {
+			continue
+		}
+		_ = vocab.OnObject(parent, func(ob *vocab.Object) error {
+			if ctx := ob.Context; !vocab.IsNil(ctx) && !targets.Contains(ctx.GetLink()) {
+				_ = targets.Append(ctx.GetLink())
+			}
+			return appendParents(ob)
+		})
+	}
+	return targets
+}
+
+// mutesAny checks if the local "actor" has muted any of the "targets" at the "now" moment.
+func (p P) mutesAny(actor vocab.IRI, targets vocab.IRIs, now time.Time) bool {
+	if !p.IsLocalIRI(actor) {
+		return false
+	}
+	muted := p.mutedItems(actor, now)
+	for _, target := range targets {
+		if muted.Contains(target) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsMutedBy checks if the local "actor" has muted any of the actors, objects or conversations
+// that the "it" activity is related to.
+func (p P) IsMutedBy(it vocab.Item, actor vocab.IRI) bool {
+	if vocab.IsNil(it) {
+		return false
+	}
+	return p.mutesAny(actor, p.threadMuteTargets(it), time.Now().UTC())
+}
+
+// removeMutingRecipients removes from the "recipients" of the "it" activity the inboxes of the local actors
+// which have muted it, or whose mute rules it matches.
+// The mutes of each actor are loaded only once, even if the activity is delivered to more of its collections.
+func (p P) removeMutingRecipients(it vocab.Item, recipients vocab.ItemCollection) vocab.ItemCollection {
+	if vocab.IsNil(it) {
+		return recipients
+	}
+	now := time.Now().UTC()
+	targets := p.threadMuteTargets(it)
+	mutedBy := make(map[vocab.IRI]bool)
+
+	result := make(vocab.ItemCollection, 0, len(recipients))
+	for _, rec := range recipients {
+		owner, col := vocab.Split(rec.GetLink())
+		if col != vocab.Inbox {
+			result = append(result, rec)
+			continue
+		}
+		muted, ok := mutedBy[owner]
+		if !ok {
+			muted = p.mutesAny(owner, targets, now) || p.matchesMuteRules(owner, it, targets)
+			mutedBy[owner] = muted
+		}
+		if muted {
+			p.l.Debugf("Skipping recipient %s which has muted %s", rec.GetLink(), it.GetLink())
+			continue
+		}
+		result = append(result, rec)
+	}
+	return result
+}
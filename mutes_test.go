@@ -0,0 +1,263 @@
+package processing
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_muteTargets(t *testing.T) {
+	reply := &vocab.Object{
+		ID:           "https://remote.example.com/objects/2",
+		Type:         vocab.NoteType,
+		AttributedTo: vocab.IRI("https://remote.example.com/~author"),
+		InReplyTo:    vocab.IRI("https://remote.example.com/objects/1"),
+		Context:      vocab.IRI("https://remote.example.com/contexts/1"),
+	}
+	act := &vocab.Activity{
+		ID:     "https://remote.example.com/activities/1",
+		Type:   vocab.CreateType,
+		Actor:  vocab.IRI("https://remote.example.com/~author"),
+		Object: reply,
+	}
+	want := vocab.IRIs{
+		"https://remote.example.com/~author",
+		"https://remote.example.com/objects/2",
+		"https://remote.example.com/objects/1",
+		"https://remote.example.com/contexts/1",
+	}
+	if got := muteTargets(act); !cmp.Equal(got, want) {
+		t.Errorf("muteTargets() = %s", cmp.Diff(want, got))
+	}
+}
+
+func TestP_IsMutedBy(t *testing.T) {
+	author := vocab.IRI("https://remote.example.com/~author")
+	conversation := vocab.IRI("https://remote.example.com/contexts/1")
+	act := &vocab.Activity{
+		ID:    "https://remote.example.com/activities/1",
+		Type:  vocab.CreateType,
+		Actor: author,
+		Object: &vocab.Object{
+			ID:      "https://remote.example.com/objects/1",
+			Type:    vocab.NoteType,
+			Context: conversation,
+		},
+	}
+
+	tests := []struct {
+		name   string
+		ignore *vocab.Activity
+		want   bool
+	}{
+		{
+			name: "nothing muted",
+			want: false,
+		},
+		{
+			name:   "muted actor",
+			ignore: &vocab.Activity{ID: defaultActorID + "/outbox/1", Type: vocab.IgnoreType, Actor: defaultActorID, Object: author},
+			want:   true,
+		},
+		{
+			name:   "muted conversation",
+			ignore: &vocab.Activity{ID: defaultActorID + "/outbox/1", Type: vocab.IgnoreType, Actor: defaultActorID, Object: conversation},
+			want:   true,
+		},
+		{
+			name: "mute not expired",
+			ignore: &vocab.Activity{
+				ID:      defaultActorID + "/outbox/1",
+				Type:    vocab.IgnoreType,
+				Actor:   defaultActorID,
+				Object:  author,
+				EndTime: time.Now().Add(time.Hour),
+			},
+			want: true,
+		},
+		{
+			name: "expired mute",
+			ignore: &vocab.Activity{
+				ID:      defaultActorID + "/outbox/1",
+				Type:    vocab.IgnoreType,
+				Actor:   defaultActorID,
+				Object:  author,
+				EndTime: time.Now().Add(-time.Hour),
+			},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := mockProcessor(t, defaultActorID)
+			if _, err := p.s.Create(emptyCol(IgnoredCollection.IRI(defaultActor))); err != nil {
+				t.Fatalf("unable to create ignored collection: %s", err)
+			}
+			if tt.ignore != nil {
+				if _, err := p.s.Save(tt.ignore); err != nil {
+					t.Fatalf("unable to save Ignore: %s", err)
+				}
+				if _, err := IgnoreActivity(p, tt.ignore); err != nil {
+					t.Fatalf("IgnoreActivity() error = %s", err)
+				}
+			}
+			if got := p.IsMutedBy(act, defaultActorID); got != tt.want {
+				t.Errorf("IsMutedBy() = %v, want %v", got, tt.want)
+			}
+
+			inbox := vocab.Inbox.IRI(defaultActor)
+			recipients := p.removeMutingRecipients(act, vocab.ItemCollection{inbox})
+			if delivered := recipients.Contains(inbox); delivered == tt.want {
+				t.Errorf("removeMutingRecipients() delivered = %v, want %v", delivered, !tt.want)
+			}
+		})
+	}
+}
+
+func TestP_IsMutedBy_undo(t *testing.T) {
+	author := vocab.IRI("https://remote.example.com/~author")
+	act := &vocab.Activity{ID: "https://remote.example.com/activities/1", Type: vocab.LikeType, Actor: author}
+
+	p := mockProcessor(t, defaultActorID)
+	p.mutes = newMutesCache()
+	if _, err := p.s.Create(emptyCol(IgnoredCollection.IRI(defaultActor))); err != nil {
+		t.Fatalf("unable to create ignored collection: %s", err)
+	}
+	if _, err := p.s.Save(&vocab.Actor{ID: author, Type: vocab.PersonType}); err != nil {
+		t.Fatalf("unable to save actor: %s", err)
+	}
+	ignore := &vocab.Activity{
+		ID:      defaultActorID + "/outbox/1",
+		Type:    vocab.IgnoreType,
+		Actor:   defaultActorID,
+		Object:  author,
+		EndTime: time.Now().Add(time.Hour),
+	}
+	if _, err := p.s.Save(ignore); err != nil {
+		t.Fatalf("unable to save Ignore: %s", err)
+	}
+	if p.IsMutedBy(act, defaultActorID) {
+		t.Fatalf("IsMutedBy() = true before Ignore")
+	}
+	if _, err := IgnoreActivity(p, ignore); err != nil {
+		t.Fatalf("IgnoreActivity() error = %s", err)
+	}
+	if !p.IsMutedBy(act, defaultActorID) {
+		t.Fatalf("IsMutedBy() = false after Ignore")
+	}
+	if _, err := p.UndoRelationshipManagementActivity(ignore); err != nil {
+		t.Fatalf("UndoRelationshipManagementActivity() error = %s", err)
+	}
+	if p.IsMutedBy(act, defaultActorID) {
+		t.Errorf("IsMutedBy() = true after Undo")
+	}
+}
+
+func TestP_removeMutingRecipients_cachesMutes(t *testing.T) {
+	author := vocab.IRI("https://remote.example.com/~author")
+	muted := vocab.IRI("https://remote.example.com/~muted")
+
+	p := mockProcessor(t, defaultActorID)
+	p.mutes = newMutesCache()
+	store := newLoadCountingStore(p.s)
+	p.s = store
+
+	ignored := IgnoredCollection.IRI(defaultActor)
+	if _, err := p.s.Create(emptyCol(ignored)); err != nil {
+		t.Fatalf("unable to create ignored collection: %s", err)
+	}
+	if err := p.s.AddTo(ignored, muted); err != nil {
+		t.Fatalf("unable to mute actor: %s", err)
+	}
+
+	recipients := vocab.ItemCollection{vocab.Inbox.IRI(defaultActor)}
+	for i := range 3 {
+		act := &vocab.Activity{ID: vocab.IRI(fmt.Sprintf("https://remote.example.com/activities/%d", i)), Type: vocab.LikeType, Actor: author}
+		if got := p.removeMutingRecipients(act, recipients); len(got) != 1 {
+			t.Fatalf("removeMutingRecipients() = %v, expected the recipient to be kept", got)
+		}
+	}
+	if loads := store.count(ignored); loads != 1 {
+		t.Errorf("removeMutingRecipients() loaded the ignored collection %d times, want 1", loads)
+	}
+
+	ignore := &vocab.Activity{ID: defaultActorID + "/outbox/1", Type: vocab.IgnoreType, Actor: defaultActorID, Object: author}
+	if _, err := IgnoreActivity(p, ignore); err != nil {
+		t.Fatalf("IgnoreActivity() error = %s", err)
+	}
+	act := &vocab.Activity{ID: "https://remote.example.com/activities/4", Type: vocab.LikeType, Actor: author}
+	if got := p.removeMutingRecipients(act, recipients); len(got) != 0 {
+		t.Errorf("removeMutingRecipients() = %v, expected the recipient which muted the author to be removed", got)
+	}
+}
+
+func TestP_IsMutedBy_thread(t *testing.T) {
+	root := vocab.IRI("https://remote.example.com/objects/1")
+	reply := &vocab.Object{
+		ID:        "https://remote.example.com/objects/2",
+		Type:      vocab.NoteType,
+		InReplyTo: root,
+	}
+	act := mrfCreate(&vocab.Object{
+		ID:        "https://remote.example.com/objects/3",
+		Type:      vocab.NoteType,
+		InReplyTo: reply.ID,
+	})
+
+	p := mockProcessor(t, defaultActorID)
+	if _, err := p.s.Create(emptyCol(IgnoredCollection.IRI(defaultActor))); err != nil {
+		t.Fatalf("unable to create ignored collection: %s", err)
+	}
+	if _, err := p.s.Save(reply); err != nil {
+		t.Fatalf("unable to save reply: %s", err)
+	}
+	ignore := &vocab.Activity{ID: defaultActorID + "/outbox/1", Type: vocab.IgnoreType, Actor: defaultActorID, Object: root}
+	if _, err := p.s.Save(ignore); err != nil {
+		t.Fatalf("unable to save Ignore: %s", err)
+	}
+	if _, err := IgnoreActivity(p, ignore); err != nil {
+		t.Fatalf("IgnoreActivity() error = %s", err)
+	}
+	if !p.IsMutedBy(act, defaultActorID) {
+		t.Errorf("IsMutedBy() = false for a reply to a reply of a muted object")
+	}
+}
+
+func TestP_PurgeExpiredMutes(t *testing.T) {
+	author := vocab.IRI("https://remote.example.com/~author")
+	p := mockProcessor(t, defaultActorID)
+	if _, err := p.s.Create(emptyCol(IgnoredCollection.IRI(defaultActor))); err != nil {
+		t.Fatalf("unable to create ignored collection: %s", err)
+	}
+	ends := map[string]time.Time{"1": time.Now().Add(-time.Hour), "2": time.Now().Add(time.Hour)}
+	for id, end := range ends {
+		ignore := &vocab.Activity{
+			ID:      vocab.Outbox.IRI(defaultActor).AddPath(id),
+			Type:    vocab.IgnoreType,
+			Actor:   defaultActorID,
+			Object:  author,
+			EndTime: end,
+		}
+		if _, err := p.s.Save(ignore); err != nil {
+			t.Fatalf("unable to save Ignore: %s", err)
+		}
+		if _, err := IgnoreActivity(p, ignore); err != nil {
+			t.Fatalf("IgnoreActivity() error = %s", err)
+		}
+	}
+
+	if err := p.PurgeExpiredMutes(defaultActorID); err != nil {
+		t.Fatalf("PurgeExpiredMutes() error = %s", err)
+	}
+	remaining := make(vocab.IRIs, 0)
+	_ = p.walkCollection(IgnoredCollection.IRI(defaultActor), func(it vocab.Item) error {
+		return remaining.Append(it.GetLink())
+	})
+	want := vocab.IRIs{vocab.Outbox.IRI(defaultActor).AddPath("2")}
+	if !cmp.Equal(remaining, want) {
+		t.Errorf("PurgeExpiredMutes() ignored collection = %s", cmp.Diff(want, remaining))
+	}
+}
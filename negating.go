@@ -6,7 +6,8 @@ import (
 )
 
 // ValidUndoActivityTypes are the types we currently support operating Undo on
-var ValidUndoActivityTypes = append(UndoableRelationshipActivityTypes, vocab.CreateType, vocab.AnnounceType)
+var ValidUndoActivityTypes = append(UndoableRelationshipActivityTypes, vocab.CreateType, vocab.UpdateType,
+	vocab.AnnounceType, vocab.AddType, vocab.RemoveType, vocab.MoveType)
 
 // ValidateClientNegatingActivity
 func (p P) ValidateClientNegatingActivity(act *vocab.Activity) error {
@@ -90,10 +91,11 @@ func (p *P) undoThisActivity(toUndo vocab.Item) error {
 		switch {
 		case vocab.CreateType.Match(typ):
 			_, err = p.UndoCreateActivity(toUndo)
-		case vocab.DislikeType.Match(typ):
-			// TODO(marius): Dislikes should not trigger a removal from Likes/Liked collections
-			fallthrough
-		case vocab.LikeType.Match(typ):
+		case vocab.UpdateType.Match(typ):
+			_, err = p.UndoUpdateActivity(toUndo)
+		case vocab.CollectionManagementActivityTypes.Match(typ):
+			_, err = p.UndoCollectionManagementActivity(toUndo)
+		case vocab.DislikeType.Match(typ), vocab.LikeType.Match(typ):
 			_, err = p.UndoAppreciationActivity(toUndo)
 		case UndoableRelationshipActivityTypes.Match(typ):
 			_, err = p.UndoRelationshipManagementActivity(toUndo)
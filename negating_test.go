@@ -1,6 +1,10 @@
 package processing
 
-import "testing"
+import (
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+)
 
 func TestNegatingActivity(t *testing.T) {
 	t.Skipf("TODO")
@@ -11,5 +15,30 @@ func TestUndoActivity(t *testing.T) {
 }
 
 func TestUndoAppreciationActivity(t *testing.T) {
-	t.Skipf("TODO")
+	p, ob := testAppreciationSetup(t)
+
+	like := &vocab.Activity{ID: defaultActorID + "/activities/like", Type: vocab.LikeType, Actor: defaultActor, Object: ob.ID}
+	dislike := &vocab.Activity{ID: defaultActorID + "/activities/dislike", Type: vocab.DislikeType, Actor: defaultActor, Object: ob.ID}
+	for _, act := range []*vocab.Activity{like, dislike} {
+		if _, err := AppreciationActivity(p, act); err != nil {
+			t.Fatalf("AppreciationActivity() error = %s", err)
+		}
+	}
+
+	if _, err := p.UndoAppreciationActivity(dislike); err != nil {
+		t.Fatalf("UndoAppreciationActivity() error = %s", err)
+	}
+
+	if testCollectionContains(p, DislikedCollection.IRI(defaultActor), ob) {
+		t.Errorf("UndoAppreciationActivity() object is still in the actor's disliked collection")
+	}
+	if testCollectionContains(p, DislikesCollection.IRI(ob), dislike) {
+		t.Errorf("UndoAppreciationActivity() Dislike is still in the object's dislikes collection")
+	}
+	if !testCollectionContains(p, vocab.Liked.IRI(defaultActor), ob) {
+		t.Errorf("UndoAppreciationActivity() of a Dislike removed the object from the actor's liked collection")
+	}
+	if !testCollectionContains(p, vocab.Likes.IRI(ob), like) {
+		t.Errorf("UndoAppreciationActivity() of a Dislike removed the Like from the object's likes collection")
+	}
 }
@@ -0,0 +1,160 @@
+package processing
+
+import (
+	vocab "github.com/go-ap/activitypub"
+)
+
+// PayloadLimits contains the limits for the activities received by the server, which protect it against
+// payloads crafted to exhaust its resources. A zero value for any of them means that it is not limited.
+type PayloadLimits struct {
+	// MaxDepth is the maximum nesting level of the objects embedded in an activity, eg: a Create activity
+	// with an embedded Note, which is in reply to an embedded Note, has a depth of 3.
+	MaxDepth int
+	// MaxRecipients is the maximum number of entries in each of the to, cc, bto, bcc and audience properties.
+	MaxRecipients int
+	// MaxTags is the maximum number of entries in the tag property.
+	MaxTags int
+	// MaxAttachments is the maximum number of entries in the attachment property.
+	MaxAttachments int
+	// MaxContentLength is the maximum length in bytes of the content of an object, for each of its languages.
+	// The media content encoded as valid data URIs is limited separately, by the MediaSizeLimits of its media type,
+	// if there is one.
+	MaxContentLength int
+}
+
+// DefaultPayloadLimits are generous limits which don't affect the activities of regular use.
+var DefaultPayloadLimits = PayloadLimits{
+	MaxDepth:         16,
+	MaxRecipients:    1000,
+	MaxTags:          200,
+	MaxAttachments:   50,
+	MaxContentLength: 1 << 20,
+}
+
+// itemsCount returns the number of items in the "it" property value, which can be a single item or a collection.
+func itemsCount(it vocab.Item) int {
+	if vocab.IsNil(it) {
+		return 0
+	}
+	if vocab.IsIRIs(it) || vocab.IsItemCollection(it) {
+		count := 0
+		_ = vocab.OnCollectionIntf(it, func(col vocab.CollectionInterface) error {
+			count = len(col.Collection())
+			return nil
+		})
+		return count
+	}
+	return 1
+}
+
+// validateContent checks the content of the "ob" object against the maximum content length, or for content which
+// is a valid data URI, against the size limit of its media type in "media".
+func (l PayloadLimits) validateContent(ob *vocab.Object, media MediaSizeLimits) error {
+	if l.MaxContentLength <= 0 {
+		return nil
+	}
+	for _, nv := range ob.Content {
+		if len(nv) <= l.MaxContentLength {
+			continue
+		}
+		mediaType, data, err := decodeDataURI(nv)
+		if err != nil {
+			return RequestEntityTooLargef("%s content exceeds maximum length of %d bytes", ob.ID, l.MaxContentLength)
+		}
+		limit := media.limitFor(mediaType)
+		if limit < 0 {
+			return RequestEntityTooLargef("%s %s content exceeds maximum length of %d bytes", ob.ID, mediaType, l.MaxContentLength)
+		}
+		if int64(len(data)) > limit {
+			return RequestEntityTooLargef("%s %s content exceeds maximum size of %d bytes", ob.ID, mediaType, limit)
+		}
+	}
+	return nil
+}
+
+func (l PayloadLimits) validateObject(ob *vocab.Object, media MediaSizeLimits) error {
+	if l.MaxRecipients > 0 {
+		props := []string{"to", "cc", "bto", "bcc", "audience"}
+		for i, rec := range []vocab.Item{ob.To, ob.CC, ob.Bto, ob.BCC, ob.Audience} {
+			if count := itemsCount(rec); count > l.MaxRecipients {
+				return RequestEntityTooLargef("%s has %d %s recipients, more than the maximum of %d", ob.ID, count, props[i], l.MaxRecipients)
+			}
+		}
+	}
+	if count := itemsCount(ob.Tag); l.MaxTags > 0 && count > l.MaxTags {
+		return RequestEntityTooLargef("%s has %d tags, more than the maximum of %d", ob.ID, count, l.MaxTags)
+	}
+	if count := itemsCount(ob.Attachment); l.MaxAttachments > 0 && count > l.MaxAttachments {
+		return RequestEntityTooLargef("%s has %d attachments, more than the maximum of %d", ob.ID, count, l.MaxAttachments)
+	}
+	return l.validateContent(ob, media)
+}
+
+// validate checks the "it" item, found at "depth" nesting level, and the items embedded in it against the limits.
+// The media content encoded as data URIs is checked against the "media" size limits.
+func (l PayloadLimits) validate(it vocab.Item, depth int, media MediaSizeLimits) error {
+	if vocab.IsNil(it) || vocab.IsIRI(it) || vocab.IsIRIs(it) {
+		return nil
+	}
+	if vocab.IsItemCollection(it) {
+		// NOTE(marius): the items of a property with multiple values are on the same level as a single value would be
+		return vocab.OnCollectionIntf(it, func(col vocab.CollectionInterface) error {
+			for _, ob := range col.Collection() {
+				if err := l.validate(ob, depth, media); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	if l.MaxDepth > 0 && depth > l.MaxDepth {
+		return RequestEntityTooLargef("embedded objects exceed the maximum depth of %d", l.MaxDepth)
+	}
+	if vocab.LinkTypes.Match(it.GetType()) {
+		return nil
+	}
+
+	embedded := make([]vocab.Item, 0)
+	var err error
+	_ = vocab.OnObject(it, func(ob *vocab.Object) error {
+		if err = l.validateObject(ob, media); err != nil {
+			return err
+		}
+		embedded = append(embedded, ob.AttributedTo, ob.Attachment, ob.Tag, ob.InReplyTo, ob.Context, ob.Generator,
+			ob.Icon, ob.Image, ob.Location, ob.Preview, ob.Replies, ob.Likes, ob.Shares,
+			ob.To, ob.CC, ob.Bto, ob.BCC, ob.Audience)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if vocab.IntransitiveActivityTypes.Match(it.GetType()) || vocab.ActivityTypes.Match(it.GetType()) {
+		_ = vocab.OnIntransitiveActivity(it, func(act *vocab.IntransitiveActivity) error {
+			embedded = append(embedded, act.Actor, act.Target, act.Result, act.Origin, act.Instrument)
+			return nil
+		})
+	}
+	if vocab.ActivityTypes.Match(it.GetType()) {
+		_ = vocab.OnActivity(it, func(act *vocab.Activity) error {
+			embedded = append(embedded, act.Object)
+			return nil
+		})
+	}
+	if collectionTypes.Match(it.GetType()) {
+		_ = vocab.OnCollectionIntf(it, func(col vocab.CollectionInterface) error {
+			embedded = append(embedded, col.Collection())
+			return nil
+		})
+	}
+	for _, emb := range embedded {
+		if err = l.validate(emb, depth+1, media); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validatePayload checks that the "it" activity doesn't exceed the payload limits of the processor.
+func (p P) validatePayload(it vocab.Item) error {
+	return p.payloadLimits.validate(it, 1, p.mediaLimits)
+}
@@ -0,0 +1,85 @@
+package processing
+
+import (
+	"strings"
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+)
+
+func TestPayloadLimits_validate(t *testing.T) {
+	limits := PayloadLimits{MaxDepth: 3, MaxRecipients: 2, MaxTags: 1, MaxAttachments: 1, MaxContentLength: 10}
+	media := MediaSizeLimits{"image": 100, "text/plain": 5}
+
+	reply := func(depth int) vocab.Item {
+		var ob vocab.Item = vocab.IRI("https://remote.example.com/objects/0")
+		for i := 0; i < depth; i++ {
+			ob = &vocab.Object{Type: vocab.NoteType, InReplyTo: ob}
+		}
+		return ob
+	}
+	recipients := vocab.ItemCollection{vocab.PublicNS, vocab.IRI("https://remote.example.com/~jdoe"), vocab.IRI("https://remote.example.com/~jane")}
+
+	tests := []struct {
+		name     string
+		it       vocab.Item
+		noLimits bool
+		wantErr  bool
+	}{
+		{name: "nil", it: nil},
+		{name: "iri", it: vocab.IRI("https://remote.example.com/activities/1")},
+		{name: "within limits", it: mrfCreate(&vocab.Object{Type: vocab.NoteType, Content: vocab.DefaultNaturalLanguage("hello")})},
+		{name: "max depth", it: mrfCreate(reply(2))},
+		{name: "exceeded depth", it: mrfCreate(reply(3)), wantErr: true},
+		{name: "too many recipients", it: &vocab.Activity{Type: vocab.CreateType, CC: recipients}, wantErr: true},
+		{name: "too many embedded recipients", it: mrfCreate(&vocab.Object{Type: vocab.NoteType, BCC: recipients}), wantErr: true},
+		{name: "too many tags", it: mrfCreate(&vocab.Object{Type: vocab.NoteType, Tag: vocab.ItemCollection{hashtag("#one"), hashtag("#two")}}), wantErr: true},
+		{
+			name: "too many attachments",
+			it: mrfCreate(&vocab.Object{
+				Type:       vocab.NoteType,
+				Attachment: vocab.ItemCollection{vocab.IRI("https://remote.example.com/media/1"), vocab.IRI("https://remote.example.com/media/2")},
+			}),
+			wantErr: true,
+		},
+		{name: "content too long", it: mrfCreate(&vocab.Object{Type: vocab.NoteType, Content: vocab.DefaultNaturalLanguage(strings.Repeat("a", 11))}), wantErr: true},
+		{name: "media content", it: mrfCreate(&vocab.Object{Type: vocab.ImageType, Content: vocab.DefaultNaturalLanguage("data:image/png;base64,iVBORw0KGgo=")})},
+		{name: "media content too large", it: mrfCreate(&vocab.Object{Type: vocab.DocumentType, Content: vocab.DefaultNaturalLanguage("data:text/plain,hello world")}), wantErr: true},
+		{name: "media content without limit", it: mrfCreate(&vocab.Object{Type: vocab.DocumentType, Content: vocab.DefaultNaturalLanguage("data:application/pdf,hello world")}), wantErr: true},
+		{name: "invalid data URI", it: mrfCreate(&vocab.Object{Type: vocab.NoteType, Content: vocab.DefaultNaturalLanguage("data:hello world")}), wantErr: true},
+		{name: "no limits", it: mrfCreate(reply(10)), noLimits: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := limits
+			if tt.noLimits {
+				l = PayloadLimits{}
+			}
+			err := l.validate(tt.it, 1, media)
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !IsRequestEntityTooLarge(err) {
+				t.Errorf("validate() error = %v, expected request entity too large", err)
+			}
+		})
+	}
+}
+
+func TestP_ValidateServerActivity_payloadLimits(t *testing.T) {
+	p := mockProcessor(t, defaultActorID)
+	p.payloadLimits = PayloadLimits{MaxRecipients: 1}
+
+	author := vocab.Actor{ID: "https://remote.example.com/~jdoe", Type: vocab.PersonType}
+	act := &vocab.Activity{
+		ID:     "https://remote.example.com/activities/1",
+		Type:   vocab.CreateType,
+		Actor:  author.ID,
+		To:     vocab.ItemCollection{vocab.PublicNS, vocab.Inbox.IRI(defaultActor)},
+		Object: &vocab.Object{ID: "https://remote.example.com/objects/1", Type: vocab.NoteType},
+	}
+	err := p.ValidateServerActivity(act, author, vocab.Inbox.IRI(defaultActor))
+	if !IsRequestEntityTooLarge(err) {
+		t.Errorf("ValidateServerActivity() error = %v, expected request entity too large", err)
+	}
+}
@@ -2,6 +2,8 @@ package processing
 
 import (
 	"crypto"
+	"crypto/ed25519"
+	"net/http"
 	"time"
 
 	"git.sr.ht/~mariusor/lw"
@@ -19,6 +21,77 @@ type P struct {
 	retries int
 	async   bool
 
+	// keepHistory determines if the processing of Update activities stores the previous versions of the objects
+	// in their history collection.
+	keepHistory bool
+
+	// cascadeDeletes determines if the processing of Delete activities for local actors also removes
+	// the content and the relationships of the deleted actors.
+	cascadeDeletes bool
+
+	// clockSkew is the maximum difference accepted between the moment an HTTP request was signed and the current time.
+	clockSkew time.Duration
+	// maxBodySize is the maximum size of the request bodies that get read by the processor and its middlewares.
+	maxBodySize int64
+
+	// keyLoader loads the private keys of the local actors, for signing the requests made on their behalf.
+	keyLoader KeyLoader
+	// proofKeyLoader loads the Ed25519 keys of the local actors, for creating the integrity proofs of their activities.
+	proofKeyLoader ProofKeyLoader
+	// instanceActor is the actor whose key is used for signing requests when the key of the actor on whose behalf
+	// they're made can't be loaded.
+	instanceActor vocab.IRI
+	// signRFC9421 determines if the requests get signed using RFC9421 HTTP signatures instead of draft-cavage ones.
+	signRFC9421 bool
+	// deliveryTransport is the transport used for delivering the activities signed by the processor.
+	deliveryTransport http.RoundTripper
+
+	// requireSignedFetch determines if the handlers wrapped with P.AuthorizedItemHandler and
+	// P.AuthorizedCollectionHandler reject the anonymous requests.
+	requireSignedFetch bool
+
+	// federation contains the server-wide rules for federating with other servers.
+	federation FederationPolicy
+
+	// mrf is the chain of policies that get applied, in order, to the activities received from other servers.
+	mrf []MRFPolicy
+	// quarantineCheck decides which of the activities received from other servers are held in quarantine.
+	quarantineCheck QuarantineCheckFn
+
+	// muteRules caches the mute rules of the local actors.
+	muteRules *muteRulesCache
+	// mutes caches the items muted by the local actors.
+	mutes *mutesCache
+
+	// actorStatusPolicy resolves which actors are suspended or silenced.
+	actorStatusPolicy ActorStatusPolicy
+
+	// moderators are the local actors which can resolve or dismiss the reports received by the server.
+	moderators vocab.IRIs
+
+	// rateLimiter limits the volume of activities accepted from each remote actor and remote host.
+	rateLimiter *RateLimiter
+
+	// quotaPolicy limits the resources that the local actors can use by publishing activities.
+	quotaPolicy QuotaPolicy
+
+	// payloadLimits are the limits for the size and the complexity of the activities received by the server.
+	payloadLimits PayloadLimits
+
+	// cacheProxied determines if the objects fetched through the actors' proxyUrl endpoint get saved to storage.
+	cacheProxied bool
+	// proxyPrivateHosts determines if the actors' proxyUrl endpoint can fetch IRIs from private networks.
+	proxyPrivateHosts bool
+
+	// blobs stores the binary content of media objects, outside the ActivityStreams storage.
+	blobs BlobStore
+
+	// mediaLimits are the maximum sizes of the media content, per media type.
+	mediaLimits MediaSizeLimits
+
+	// tombstonePolicy determines how long the tombstones of deleted objects are kept in storage.
+	tombstonePolicy TombstonePolicy
+
 	// skipValidationOnInboundCollections determines if the validation functionality checks that the collection
 	// which received the activity actually exists.
 	skipValidationOnInboundCollections bool
@@ -45,6 +118,11 @@ func New(o ...OptionFn) *P {
 		createIDFn:      emptyIDGenerator,
 		localIRICheckFn: defaultLocalIRICheck,
 		actorKeyGenFn:   defaultKeyGenerator,
+		clockSkew:       DefaultMaxClockSkew,
+		maxBodySize:     DefaultMaxBodySize,
+		payloadLimits:   DefaultPayloadLimits,
+		muteRules:       newMuteRulesCache(),
+		mutes:           newMutesCache(),
 	}
 	for _, fn := range o {
 		fn(&p)
@@ -62,6 +140,187 @@ func SkipInboundCollectionValidation(p *P) {
 	p.skipValidationOnInboundCollections = true
 }
 
+// KeepObjectHistory enables the versioning of objects: every Update activity stores a snapshot of the previous
+// state of the objects it modifies in their history collection.
+func KeepObjectHistory(p *P) {
+	p.keepHistory = true
+}
+
+// CascadeActorDeletes enables the cascading of Delete activities that have actors as objects.
+// For local actors, the objects authored by them are replaced with Tombstones, they are removed from the local
+// followers and following collections, and the Delete activity is delivered to all their followers.
+// For remote actors, they are removed from the followers and following collections of the local recipients
+// of the Delete activity.
+func CascadeActorDeletes(p *P) {
+	p.cascadeDeletes = true
+}
+
+// WithMaxClockSkew sets the maximum difference we accept between the moment an HTTP request was signed and
+// the current time, when verifying its signature. The default is DefaultMaxClockSkew.
+func WithMaxClockSkew(d time.Duration) OptionFn {
+	return func(p *P) {
+		p.clockSkew = d
+	}
+}
+
+// WithMaxBodySize sets the maximum size in bytes of the request bodies that get read when verifying
+// HTTP signatures, and by the handlers wrapped with HTTPSignatureVerifierMw. Larger requests are rejected.
+// The default is DefaultMaxBodySize.
+func WithMaxBodySize(size int64) OptionFn {
+	return func(p *P) {
+		p.maxBodySize = size
+	}
+}
+
+// WithKeyLoader sets the KeyLoader used for loading the private keys of the local actors, which are used
+// to sign the deliveries of their activities, and by P.SigningTransport to sign other outgoing requests.
+func WithKeyLoader(kl KeyLoader) OptionFn {
+	return func(p *P) {
+		p.keyLoader = kl
+	}
+}
+
+// WithProofKeyLoader sets the ProofKeyLoader used for loading the Ed25519 keys of the local actors, which are used
+// by P.SigningTransport to create the FEP-8b32 integrity proofs of the activities they publish.
+// Without it, integrity proofs are created only for the actors whose main key is an Ed25519 one.
+func WithProofKeyLoader(kl ProofKeyLoader) OptionFn {
+	return func(p *P) {
+		p.proofKeyLoader = kl
+	}
+}
+
+// WithDeliveryTransport sets the transport used for delivering the activities that the processor signs, when it has
+// been created with a KeyLoader. The default is http.DefaultTransport.
+func WithDeliveryTransport(rt http.RoundTripper) OptionFn {
+	return func(p *P) {
+		p.deliveryTransport = rt
+	}
+}
+
+// WithInstanceActor sets the actor whose key is used to sign outgoing requests when the key of the
+// actor on whose behalf they are made can not be loaded.
+func WithInstanceActor(actor vocab.IRI) OptionFn {
+	return func(p *P) {
+		p.instanceActor = actor
+	}
+}
+
+// SignWithRFC9421 makes P.SigningTransport sign requests using RFC9421 HTTP signatures,
+// instead of the default draft-cavage ones.
+func SignWithRFC9421(p *P) {
+	p.signRFC9421 = true
+}
+
+// RequireSignedFetches makes the handlers wrapped with P.AuthorizedItemHandler and P.AuthorizedCollectionHandler
+// reject the requests that are not made by an authenticated actor, also known as "authorized fetch".
+func RequireSignedFetches(p *P) {
+	p.requireSignedFetch = true
+}
+
+// WithFederationPolicy sets the server-wide rules for federating with other servers, like blocking or silencing
+// domains, or federating only with an allowlist of domains.
+func WithFederationPolicy(fp FederationPolicy) OptionFn {
+	return func(p *P) {
+		p.federation = fp
+	}
+}
+
+// WithMRFPolicies sets the chain of Message Rewrite Facility policies that get applied, in order, to the activities
+// received from other servers, after their validation. The policies can accept, reject, modify or quarantine them.
+func WithMRFPolicies(policies ...MRFPolicy) OptionFn {
+	return func(p *P) {
+		p.mrf = append(p.mrf, policies...)
+	}
+}
+
+// WithQuarantineCheck sets the function which decides which of the activities received from other servers
+// are held in quarantine, instead of being processed. See P.ReleaseQuarantined and P.DiscardQuarantined.
+func WithQuarantineCheck(fn QuarantineCheckFn) OptionFn {
+	return func(p *P) {
+		p.quarantineCheck = fn
+	}
+}
+
+// WithActorStatusPolicy sets the policy that resolves which actors are suspended or silenced.
+// Suspended actors can't post, and their content is hidden, while the activities of silenced actors
+// are delivered only to their followers.
+func WithActorStatusPolicy(policy ActorStatusPolicy) OptionFn {
+	return func(p *P) {
+		p.actorStatusPolicy = policy
+	}
+}
+
+// WithModerators sets the local actors which handle the reports of the server. An Accept of a report,
+// created by one of them, resolves it, and a Reject dismisses it.
+func WithModerators(actors ...vocab.IRI) OptionFn {
+	return func(p *P) {
+		p.moderators = append(p.moderators, actors...)
+	}
+}
+
+// WithRateLimiter sets the RateLimiter which limits the volume of activities that the server accepts from each
+// remote actor, and from each remote host. The activities over the limit get rejected with a
+// 429 Too Many Requests error.
+func WithRateLimiter(r *RateLimiter) OptionFn {
+	return func(p *P) {
+		p.rateLimiter = r
+	}
+}
+
+// WithQuotaPolicy sets the QuotaPolicy which limits how much each local actor can publish. The client activities
+// over the quota get rejected with 429 Too Many Requests, or 413 Request Entity Too Large errors.
+func WithQuotaPolicy(q QuotaPolicy) OptionFn {
+	return func(p *P) {
+		p.quotaPolicy = q
+	}
+}
+
+// WithPayloadLimits sets the limits for the embedding depth, the number of recipients, tags and attachments, and
+// the content length of the activities received by the server, both from clients and from other servers.
+// The activities exceeding them get rejected with a 413 Request Entity Too Large error.
+// By default, the processor uses the DefaultPayloadLimits.
+func WithPayloadLimits(l PayloadLimits) OptionFn {
+	return func(p *P) {
+		p.payloadLimits = l
+	}
+}
+
+// CacheProxiedObjects enables saving to storage of the remote objects that the local actors fetch
+// through their proxyUrl endpoint.
+func CacheProxiedObjects(p *P) {
+	p.cacheProxied = true
+}
+
+// ProxyToPrivateHosts allows the actors' proxyUrl endpoint to fetch IRIs from hosts on loopback, link-local
+// or private networks, which are refused by default. It is meant for development setups.
+func ProxyToPrivateHosts(p *P) {
+	p.proxyPrivateHosts = true
+}
+
+// WithBlobStore sets the storage where the binary content of media objects, received inline as data URIs,
+// is saved. The objects' URLs are then pointing to the IRIs where the content can be retrieved.
+func WithBlobStore(b BlobStore) OptionFn {
+	return func(p *P) {
+		p.blobs = b
+	}
+}
+
+// WithMediaSizeLimits sets the maximum sizes for media content received as data URIs.
+// Content exceeding them results in the activity being rejected.
+func WithMediaSizeLimits(limits MediaSizeLimits) OptionFn {
+	return func(p *P) {
+		p.mediaLimits = limits
+	}
+}
+
+// WithTombstonePolicy sets the policy for retaining the tombstones that replace deleted objects.
+// See KeepTombstonesForever, KeepTombstonesFor and HardDeleteImmediately.
+func WithTombstonePolicy(policy TombstonePolicy) OptionFn {
+	return func(p *P) {
+		p.tombstonePolicy = policy
+	}
+}
+
 // WithDisseminationRetryCount specifies the number of retries for failed remote activity dissemination.
 // The default value is 0 when building with -tags dev, and 5 otherwise.
 // If a negative value is passed as the retry count, we don't try to execute the dissemination at all.
@@ -204,10 +463,17 @@ func (p *P) actorHasBlockedFn(rec vocab.Item) func(act vocab.Item) bool {
 	}
 }
 
+// KeyLoader loads the private key of an actor.
 type KeyLoader interface {
 	LoadKey(vocab.IRI) (crypto.PrivateKey, error)
 }
 
+// ProofKeyLoader loads the Ed25519 private key that an actor uses for integrity proofs, together with its ID,
+// which needs to be one of the Multikey entries of the actor's assertionMethod property.
+type ProofKeyLoader interface {
+	LoadProofKey(vocab.IRI) (ed25519.PrivateKey, vocab.IRI, error)
+}
+
 const OAuthOOBRedirectURN = "urn:ietf:wg:oauth:2.0:oob:auto"
 
 // BuildReplyToCollections builds the list of objects that it is inReplyTo
@@ -0,0 +1,169 @@
+package processing
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+type ctxKey string
+
+const signingActorKey = ctxKey("__signingActor")
+
+// ContextWithSigningActor returns a context that carries the actor on whose behalf the requests made
+// with it need to be signed.
+func ContextWithSigningActor(ctx context.Context, actor vocab.Item) context.Context {
+	return context.WithValue(ctx, signingActorKey, actor)
+}
+
+// SigningActorFromContext returns the actor on whose behalf the requests made with "ctx" need to be signed.
+// It can be used by the HTTP-Signature RoundTripper of the client to load the actor's key.
+func SigningActorFromContext(ctx context.Context) (vocab.Item, bool) {
+	actor, ok := ctx.Value(signingActorKey).(vocab.Item)
+	return actor, ok && !vocab.IsNil(actor)
+}
+
+// isPrivateIP checks if "ip" is a loopback, link-local, private or unspecified address.
+func isPrivateIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast()
+}
+
+// refusePrivateAddresses is a net.Dialer Control function which refuses the connections to private addresses.
+// It runs after the host has been resolved, for the address the dialer is about to connect to, so the check
+// can't be bypassed by a host which resolves to a different address at connection time.
+func refusePrivateAddresses(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return errors.Forbiddenf("invalid address %s", address)
+	}
+	if ip := net.ParseIP(host); ip == nil || isPrivateIP(ip) {
+		return errors.Forbiddenf("unable to connect to %s on a private network", host)
+	}
+	return nil
+}
+
+// maxProxyRedirects is the maximum number of redirects that a request of the proxyUrl endpoint follows.
+const maxProxyRedirects = 10
+
+// checkProxyRedirect is the redirect policy of the requests of the proxyUrl endpoint. The redirects are followed
+// only to remote http(s) IRIs of domains we federate with, and the connections to private networks get refused
+// by the dialer of the transport.
+func (p *P) checkProxyRedirect(r *http.Request, via []*http.Request) error {
+	if len(via) >= maxProxyRedirects {
+		return errors.BadGatewayf("stopped after %d redirects", maxProxyRedirects)
+	}
+	iri := vocab.IRI(r.URL.String())
+	if (r.URL.Scheme != "https" && r.URL.Scheme != "http") || r.URL.Host == "" {
+		return errors.Forbiddenf("invalid redirect to %s", iri)
+	}
+	if p.IsLocalIRI(iri) {
+		return errors.Forbiddenf("redirect to local IRI %s is not allowed", iri)
+	}
+	if p.IsBlockedDomain(iri) {
+		return errors.Forbiddenf("federation with the domain of %s is not allowed", iri)
+	}
+	return nil
+}
+
+// proxyClient returns the HTTP client used for the requests of the proxyUrl endpoint.
+// Its transport is a copy of the processor's delivery transport, when that is an *http.Transport, and it signs
+// the requests like the one returned by P.SigningTransport. Unless the processor has been created with the
+// ProxyToPrivateHosts option, the transport refuses to connect to private networks.
+func (p *P) proxyClient() *http.Client {
+	base, ok := p.deliveryTransport.(*http.Transport)
+	if !ok {
+		base = http.DefaultTransport.(*http.Transport)
+	}
+	transport := base.Clone()
+	if !p.proxyPrivateHosts {
+		dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Control: refusePrivateAddresses}
+		transport.DialContext = dialer.DialContext
+		// NOTE(marius): an HTTP proxy would connect to the hosts on our behalf, without our dialer's checks.
+		transport.Proxy = nil
+	}
+	return &http.Client{Transport: p.SigningTransport(transport), CheckRedirect: p.checkProxyRedirect}
+}
+
+// ProxyFetch dereferences the "iri" on behalf of the local "actor", implementing the semantics of the
+// actor's proxyUrl endpoint.
+//
+// https://www.w3.org/TR/activitypub/#proxyUrl
+//
+// Local IRIs are loaded from storage, and are returned only if the actor is allowed to see them, similarly to
+// P.AuthorizedItemHandler. Remote ones are fetched within the "ctx" context, with requests signed with the key of
+// the actor. Unless the processor has been created with the ProxyToPrivateHosts option, the IRIs of hosts on
+// loopback, link-local or private networks are refused, also when they are the target of redirects.
+// If the processor has been created with the CacheProxiedObjects option, the fetched objects get saved to storage,
+// if they have an ID of the same origin as the IRI.
+func (p *P) ProxyFetch(ctx context.Context, actor vocab.Actor, iri vocab.IRI) (vocab.Item, error) {
+	if !p.IsLocal(actor) {
+		return nil, errors.Forbiddenf("actor %s is not local", actor.GetLink())
+	}
+	u, err := iri.URL()
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, errors.BadRequestf("invalid IRI to fetch %s", iri)
+	}
+
+	if p.IsLocalIRI(iri) {
+		it, err := p.s.Load(iri)
+		if err != nil {
+			return nil, err
+		}
+		it = firstOrItem(it)
+		blocked := make(blockedChecks)
+		if !p.CanRead(it, &actor) || p.isBlockedBy(it, &actor, blocked) {
+			return nil, errors.NotFoundf("unable to find %s", iri)
+		}
+		if collectionTypes.Match(it.GetType()) {
+			return p.filterCollection(it, &actor, blocked), nil
+		}
+		return redactItem(it, &actor), nil
+	}
+
+	if p.IsBlockedDomain(iri) {
+		return nil, errors.Forbiddenf("federation with the domain of %s is not allowed", iri)
+	}
+
+	r, err := http.NewRequestWithContext(ContextWithSigningActor(ctx, actor), http.MethodGet, iri.String(), nil)
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to create request for %s", iri)
+	}
+	r.Header.Set("Accept", activityStreamsMediaType)
+	resp, err := p.proxyClient().Do(r)
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to fetch remote IRI %s", iri)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.NewFromStatus(resp.StatusCode, "unable to fetch remote IRI %s: %s", iri, resp.Status)
+	}
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, p.maxRequestBodySize()))
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to fetch remote IRI %s", iri)
+	}
+	it, err := vocab.UnmarshalJSON(raw)
+	if err != nil {
+		return nil, errors.BadGatewayf("invalid object received for %s", iri)
+	}
+	if vocab.IsNil(it) {
+		return nil, errors.NotFoundf("unable to find %s", iri)
+	}
+
+	if id := it.GetLink(); !vocab.IsItemCollection(it) && (p.IsLocalIRI(id) || !sameOrigin(id, iri)) {
+		return nil, errors.BadGatewayf("remote object %s has an ID from a different origin than %s", id, iri)
+	}
+
+	if p.cacheProxied && !vocab.IsItemCollection(it) {
+		if _, err = p.s.Save(it); err != nil {
+			p.l.Warnf("unable to cache proxied object %s: %s", iri, err)
+		}
+	}
+	return it, nil
+}
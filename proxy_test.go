@@ -0,0 +1,193 @@
+package processing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+func TestSigningActorFromContext(t *testing.T) {
+	if _, ok := SigningActorFromContext(context.Background()); ok {
+		t.Errorf("SigningActorFromContext() on empty context should not return an actor")
+	}
+	actor := vocab.IRI("https://example.com/~jdoe")
+	got, ok := SigningActorFromContext(ContextWithSigningActor(context.Background(), actor))
+	if !ok || !got.GetLink().Equal(actor) {
+		t.Errorf("SigningActorFromContext() = %v, want %s", got, actor)
+	}
+}
+
+func TestP_ProxyFetch(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ob := vocab.Object{ID: vocab.IRI("https://" + r.Host + r.URL.Path), Type: vocab.NoteType}
+		switch r.URL.Path {
+		case "/foreign":
+			ob.ID = "https://other.example.com/objects/1"
+		case "/local":
+			ob.ID = defaultActorID + "/objects/1"
+		case "/redirect":
+			http.Redirect(w, r, defaultActorID+"/objects/1", http.StatusFound)
+			return
+		}
+		raw, _ := vocab.MarshalJSON(ob)
+		w.Header().Set("Content-Type", "application/activity+json")
+		_, _ = w.Write(raw)
+	}))
+	defer srv.Close()
+	remote := vocab.IRI(srv.URL)
+
+	jane := vocab.Actor{ID: defaultActorID + "/~jane", Type: vocab.PersonType}
+	public := &vocab.Object{
+		ID:           defaultActorID + "/objects/1",
+		Type:         vocab.NoteType,
+		AttributedTo: defaultActorID,
+		To:           vocab.ItemCollection{vocab.PublicNS},
+	}
+	followersOnly := &vocab.Object{
+		ID:           defaultActorID + "/objects/2",
+		Type:         vocab.NoteType,
+		AttributedTo: defaultActorID,
+		To:           vocab.ItemCollection{vocab.Followers.IRI(defaultActor)},
+	}
+
+	tests := []struct {
+		name         string
+		actor        vocab.Actor
+		iri          vocab.IRI
+		privateHosts bool
+		wantErr      func(error) bool
+		wantCached   bool
+	}{
+		{
+			name:    "remote actor",
+			actor:   vocab.Actor{ID: "https://remote.example.com/~jdoe", Type: vocab.PersonType},
+			iri:     public.ID,
+			wantErr: errors.IsForbidden,
+		},
+		{
+			name:    "invalid IRI",
+			actor:   jane,
+			iri:     "ftp://remote.example.com/objects/1",
+			wantErr: errors.IsBadRequest,
+		},
+		{
+			name:  "local public object",
+			actor: jane,
+			iri:   public.ID,
+		},
+		{
+			name:    "local followers only object",
+			actor:   jane,
+			iri:     followersOnly.ID,
+			wantErr: errors.IsNotFound,
+		},
+		{
+			name:    "local hidden collection",
+			actor:   jane,
+			iri:     BlockedCollection.IRI(defaultActor),
+			wantErr: errors.IsNotFound,
+		},
+		{
+			name:    "private host",
+			actor:   jane,
+			iri:     remote + "/objects/1",
+			wantErr: errors.IsForbidden,
+		},
+		{
+			name:         "remote object",
+			actor:        jane,
+			iri:          remote + "/objects/1",
+			privateHosts: true,
+			wantCached:   true,
+		},
+		{
+			name:         "remote object with foreign ID",
+			actor:        jane,
+			iri:          remote + "/foreign",
+			privateHosts: true,
+			wantErr:      errors.IsBadGateway,
+		},
+		{
+			name:         "remote object with local ID",
+			actor:        jane,
+			iri:          remote + "/local",
+			privateHosts: true,
+			wantErr:      errors.IsBadGateway,
+		},
+		{
+			name:         "redirect to local IRI",
+			actor:        jane,
+			iri:          remote + "/redirect",
+			privateHosts: true,
+			wantErr:      errors.IsForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := mockProcessor(t, defaultActorID)
+			p.cacheProxied = true
+			p.proxyPrivateHosts = tt.privateHosts
+			p.deliveryTransport = srv.Client().Transport
+			for _, it := range []vocab.Item{public, followersOnly, emptyCol(BlockedCollection.IRI(defaultActor))} {
+				if _, err := p.s.Save(it); err != nil {
+					t.Fatalf("unable to save %s: %s", it.GetLink(), err)
+				}
+			}
+
+			got, err := p.ProxyFetch(context.Background(), tt.actor, tt.iri)
+			if tt.wantErr != nil {
+				if err == nil || !tt.wantErr(err) {
+					t.Fatalf("ProxyFetch() error = %v, got %v", err, got)
+				}
+			} else if err != nil {
+				t.Fatalf("ProxyFetch() error = %s", err)
+			}
+			if tt.wantErr == nil && !got.GetLink().Equal(tt.iri) {
+				t.Errorf("ProxyFetch() = %v, want %s", got, tt.iri)
+			}
+			if p.IsLocalIRI(tt.iri) {
+				return
+			}
+			cached, _ := p.s.Load(tt.iri)
+			isCached := !vocab.IsNil(cached) && firstOrItem(cached).GetLink().Equal(tt.iri)
+			if isCached != tt.wantCached {
+				t.Errorf("ProxyFetch() cached = %v, want cached %v", cached, tt.wantCached)
+			}
+			if local, _ := p.s.Load(public.ID); vocab.IsNil(firstOrItem(local)) || firstOrItem(local).GetType() != vocab.NoteType {
+				t.Errorf("ProxyFetch() overwrote local object %s", public.ID)
+			}
+		})
+	}
+}
+
+func Test_refusePrivateAddresses(t *testing.T) {
+	tests := []struct {
+		address string
+		wantErr bool
+	}{
+		{address: "127.0.0.1:443", wantErr: true},
+		{address: "[::1]:443", wantErr: true},
+		{address: "10.0.0.1:80", wantErr: true},
+		{address: "192.168.1.1:443", wantErr: true},
+		{address: "169.254.169.254:80", wantErr: true},
+		{address: "0.0.0.0:443", wantErr: true},
+		{address: "localhost:443", wantErr: true},
+		{address: "93.184.216.34:443", wantErr: false},
+		{address: "[2606:2800:220:1:248:1893:25c8:1946]:443", wantErr: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			err := refusePrivateAddresses("tcp", tt.address, nil)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("refusePrivateAddresses() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.IsForbidden(err) {
+				t.Errorf("refusePrivateAddresses() error = %v, expected forbidden", err)
+			}
+		})
+	}
+}
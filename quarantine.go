@@ -0,0 +1,181 @@
+package processing
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+// QuarantineCollection is the server collection that holds the inbound activities which have been put
+// in quarantine, instead of being processed.
+const QuarantineCollection = vocab.CollectionPath("quarantine")
+
+// QuarantineCheckFn decides if the "it" activity, received from the "author" actor, needs to be held
+// in quarantine, instead of being processed.
+type QuarantineCheckFn func(it vocab.Item, author vocab.Actor) bool
+
+// QuarantineDomains returns a QuarantineCheckFn which holds the activities whose actors belong to any of
+// the "domains". They follow the same rules as the ones of the FederationPolicy, eg: "*.example.com"
+// matches the domain and all its subdomains.
+func QuarantineDomains(domains ...string) QuarantineCheckFn {
+	return func(it vocab.Item, author vocab.Actor) bool {
+		var actor vocab.IRI
+		_ = vocab.OnIntransitiveActivity(it, func(act *vocab.IntransitiveActivity) error {
+			if !vocab.IsNil(act.Actor) {
+				actor = act.Actor.GetLink()
+			}
+			return nil
+		})
+		host := iriHost(actor)
+		if host == "" {
+			return false
+		}
+		for _, domain := range domains {
+			if domainMatches(domain, host) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// quarantineRecordIRI returns the IRI of the record of the "it" activity, received in the "receivedIn" collection,
+// in the "col" quarantine collection.
+func quarantineRecordIRI(col vocab.IRI, it vocab.Item, receivedIn vocab.IRI) vocab.IRI {
+	hash := sha256.Sum256([]byte(it.GetLink().String() + " " + receivedIn.String()))
+	return col.AddPath(fmt.Sprintf("%x", hash[:8]))
+}
+
+// quarantineActivity holds the "it" activity, received in the "receivedIn" collection, in the quarantine
+// collection of the server, without executing any of its side effects, or delivering it to its recipients.
+//
+// The quarantine collection contains Add activities which have the held activity as an object and the collection
+// where it was received as a target, as we need both for resuming its processing.
+func (p P) quarantineActivity(it vocab.Item, receivedIn vocab.IRI) error {
+	if len(it.GetLink()) == 0 {
+		return errors.Newf("unable to quarantine activity without an ID")
+	}
+	base := p.localBaseIRI(receivedIn)
+	if base == "" {
+		return errors.Newf("unable to find the server for %s", receivedIn)
+	}
+
+	col := QuarantineCollection.IRI(base)
+	if err := p.saveCollectionObjectForParent(base, blankOrderedCollection(col)); err != nil {
+		return errors.Annotatef(err, "unable to create quarantine collection %s", col)
+	}
+
+	holder := base
+	if len(p.instanceActor) > 0 {
+		holder = p.instanceActor
+	}
+	record := &vocab.Activity{
+		ID:        quarantineRecordIRI(col, it, receivedIn),
+		Type:      vocab.AddType,
+		Actor:     holder,
+		Object:    it,
+		Target:    receivedIn,
+		Published: time.Now().UTC(),
+	}
+	// NOTE(marius): the held activity is saved embedded in its record, so it's not visible by itself,
+	// until it gets released.
+	saved, err := p.s.Save(record)
+	if err != nil {
+		return errors.Annotatef(err, "unable to save quarantine record for %s", it.GetLink())
+	}
+	if err = p.s.AddTo(col, saved.GetLink()); err != nil && !errors.IsConflict(err) {
+		return errors.Annotatef(err, "unable to add %s to %s", saved.GetLink(), col)
+	}
+	p.l.Infof("Activity %s received in %s has been quarantined", it.GetLink(), receivedIn)
+	return nil
+}
+
+// quarantineRecord is a record of the quarantine collection "col" which holds an activity.
+type quarantineRecord struct {
+	col    vocab.IRI
+	record *vocab.Activity
+}
+
+// quarantineRecords returns the quarantine records which match "iri", which can be either the IRI of a held
+// activity, or the IRI of a record.
+// The same activity can have multiple records, when it was received in more than one collection.
+func (p P) quarantineRecords(iri vocab.IRI) ([]quarantineRecord, error) {
+	records := make([]quarantineRecord, 0)
+	for _, base := range p.baseIRI {
+		col := QuarantineCollection.IRI(base)
+		err := p.walkCollection(col, func(it vocab.Item) error {
+			return vocab.OnActivity(p.loadLocalCopy(it), func(rec *vocab.Activity) error {
+				if rec.ID.Equal(iri) || (!vocab.IsNil(rec.Object) && rec.Object.GetLink().Equal(iri)) {
+					records = append(records, quarantineRecord{col: col, record: rec})
+				}
+				return nil
+			})
+		})
+		if err != nil && !errors.IsNotFound(err) {
+			return nil, err
+		}
+	}
+	if len(records) == 0 {
+		return nil, errors.NotFoundf("%s is not in quarantine", iri)
+	}
+	return records, nil
+}
+
+// removeQuarantineRecord removes the "q" record from its quarantine collection and from storage.
+func (p P) removeQuarantineRecord(q quarantineRecord) error {
+	if err := p.s.RemoveFrom(q.col, q.record.GetLink()); err != nil && !errors.IsNotFound(err) {
+		return errors.Annotatef(err, "unable to remove %s from %s", q.record.GetLink(), q.col)
+	}
+	if err := p.s.Delete(q.record.GetLink()); err != nil && !errors.IsNotFound(err) {
+		return errors.Annotatef(err, "unable to delete quarantine record %s", q.record.GetLink())
+	}
+	return nil
+}
+
+// ReleaseQuarantined resumes the processing of the activity held in quarantine, identified by "iri", which
+// can be either the IRI of the activity, or the IRI of its quarantine record.
+// The activity has its side effects executed, and it's delivered to the recipients in the collections it was
+// received in, as if it had been accepted when it was received. After that, it's removed from the quarantine.
+func (p P) ReleaseQuarantined(iri vocab.IRI) error {
+	records, err := p.quarantineRecords(iri)
+	if err != nil {
+		return err
+	}
+
+	errs := make([]error, 0)
+	for _, q := range records {
+		held := p.loadLocalCopy(q.record.Object)
+		if vocab.IsNil(held) || vocab.IsIRI(held) {
+			errs = append(errs, errors.NotFoundf("unable to load quarantined activity %s", q.record.Object.GetLink()))
+			continue
+		}
+		if _, err = p.processValidServerActivity(held, q.record.Target.GetLink()); err != nil {
+			errs = append(errs, errors.Annotatef(err, "unable to process quarantined activity %s", held.GetLink()))
+			continue
+		}
+		if err = p.removeQuarantineRecord(q); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// DiscardQuarantined removes the activity held in quarantine, identified by "iri", which can be either the IRI
+// of the activity, or the IRI of its quarantine record, without processing it.
+func (p P) DiscardQuarantined(iri vocab.IRI) error {
+	records, err := p.quarantineRecords(iri)
+	if err != nil {
+		return err
+	}
+
+	errs := make([]error, 0)
+	for _, q := range records {
+		if err = p.removeQuarantineRecord(q); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
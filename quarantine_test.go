@@ -0,0 +1,118 @@
+package processing
+
+import (
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+)
+
+func TestP_quarantineActivity(t *testing.T) {
+	base := defaultActorID
+	p := mockProcessor(t, base)
+
+	act := mrfCreate(&vocab.Object{ID: "https://remote.example.com/objects/1", Type: vocab.NoteType})
+	inbox := vocab.Inbox.IRI(defaultActor)
+	if err := p.quarantineActivity(act, inbox); err != nil {
+		t.Fatalf("quarantineActivity() error = %s", err)
+	}
+
+	col := QuarantineCollection.IRI(base)
+	record, err := p.s.Load(quarantineRecordIRI(col, act, inbox))
+	if err != nil {
+		t.Fatalf("unable to load quarantine record: %s", err)
+	}
+	_ = vocab.OnActivity(record, func(rec *vocab.Activity) error {
+		if !rec.Object.GetLink().Equal(act.ID) {
+			t.Errorf("quarantined object = %s, want %s", rec.Object.GetLink(), act.ID)
+		}
+		if !rec.Target.GetLink().Equal(inbox) {
+			t.Errorf("quarantine target = %s, want %s", rec.Target.GetLink(), inbox)
+		}
+		return nil
+	})
+	found := false
+	_ = p.walkCollection(col, func(it vocab.Item) error {
+		found = found || it.GetLink().Equal(record.GetLink())
+		return nil
+	})
+	if !found {
+		t.Errorf("quarantine record is not in %s", col)
+	}
+	if _, err = p.s.Load(act.ID); err == nil {
+		t.Errorf("quarantined activity has been saved")
+	}
+}
+
+func TestQuarantineDomains(t *testing.T) {
+	check := QuarantineDomains("*.example.com", "new.social")
+	tests := []struct {
+		actor vocab.IRI
+		want  bool
+	}{
+		{actor: "https://example.com/~jdoe", want: true},
+		{actor: "https://remote.example.com/~jdoe", want: true},
+		{actor: "https://new.social/~jdoe", want: true},
+		{actor: "https://old.social/~jdoe", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.actor.String(), func(t *testing.T) {
+			act := &vocab.Activity{Type: vocab.CreateType, Actor: tt.actor}
+			if got := check(act, vocab.Actor{ID: tt.actor}); got != tt.want {
+				t.Errorf("QuarantineDomains() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestP_ReleaseQuarantined(t *testing.T) {
+	p := mockProcessor(t, defaultActorID)
+
+	act := mrfCreate(&vocab.Object{ID: "https://remote.example.com/objects/1", Type: vocab.NoteType})
+	act.To = vocab.ItemCollection{defaultActorID}
+	inbox := vocab.Inbox.IRI(defaultActor)
+	if err := p.quarantineActivity(act, inbox); err != nil {
+		t.Fatalf("quarantineActivity() error = %s", err)
+	}
+
+	if err := p.ReleaseQuarantined(act.ID); err != nil {
+		t.Fatalf("ReleaseQuarantined() error = %s", err)
+	}
+	if _, err := p.s.Load(act.ID); err != nil {
+		t.Errorf("released activity has not been saved: %s", err)
+	}
+	delivered := false
+	_ = p.walkCollection(inbox, func(it vocab.Item) error {
+		delivered = delivered || it.GetLink().Equal(act.ID)
+		return nil
+	})
+	if !delivered {
+		t.Errorf("released activity has not been delivered to %s", inbox)
+	}
+	if _, err := p.quarantineRecords(act.ID); err == nil {
+		t.Errorf("released activity is still in quarantine")
+	}
+	if err := p.ReleaseQuarantined(act.ID); err == nil {
+		t.Errorf("ReleaseQuarantined() expected error for activity that is not in quarantine")
+	}
+}
+
+func TestP_DiscardQuarantined(t *testing.T) {
+	p := mockProcessor(t, defaultActorID)
+
+	act := mrfCreate(&vocab.Object{ID: "https://remote.example.com/objects/1", Type: vocab.NoteType})
+	inbox := vocab.Inbox.IRI(defaultActor)
+	if err := p.quarantineActivity(act, inbox); err != nil {
+		t.Fatalf("quarantineActivity() error = %s", err)
+	}
+	record := quarantineRecordIRI(QuarantineCollection.IRI(defaultActorID), act, inbox)
+
+	if err := p.DiscardQuarantined(record); err != nil {
+		t.Fatalf("DiscardQuarantined() error = %s", err)
+	}
+	if _, err := p.s.Load(record); err == nil {
+		t.Errorf("quarantine record has not been removed")
+	}
+	if _, err := p.s.Load(act.ID); err == nil {
+		t.Errorf("discarded activity has been saved")
+	}
+}
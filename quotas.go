@@ -0,0 +1,374 @@
+package processing
+
+import (
+	"bytes"
+	"sync"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+)
+
+// Quota contains the limits of the resources that a local actor can use by publishing activities.
+// A zero value for any of them means that the resource is not limited.
+type Quota struct {
+	// ActivitiesPerHour is the maximum number of activities the actor can publish in an hour.
+	ActivitiesPerHour int
+	// StoredBytes is the maximum total size of the activities, including their objects, that the actor publishes.
+	StoredBytes int64
+	// MediaBytes is the maximum total size of the media content that the actor uploads.
+	MediaBytes int64
+	// RecipientsPerActivity is the maximum number of distinct recipients of a single activity.
+	RecipientsPerActivity int
+}
+
+// Usage contains the resources that a local actor has used.
+type Usage struct {
+	// Activities is the number of activities published in the last hour.
+	Activities int
+	// ActivitiesReset is the moment when the oldest of the activities published in the last hour stops counting.
+	ActivitiesReset time.Time
+	// StoredBytes is the total size of the objects published, as they are stored, without their media content.
+	StoredBytes int64
+	// MediaBytes is the total size of the media content uploaded.
+	MediaBytes int64
+}
+
+// QuotaPolicy resolves the quotas of the local actors, and keeps track of their usage.
+type QuotaPolicy interface {
+	// Quota returns the quota of the "actor".
+	Quota(actor vocab.IRI) Quota
+	// Usage returns the resources used by the "actor".
+	Usage(actor vocab.IRI) Usage
+	// Reserve checks that the "actor" can use the resources in "u" without exceeding its quota, and accounts
+	// for them in the same step, so concurrent requests of the actor can't exceed the quota together.
+	// It returns a TooManyRequests error when the actor has published too many activities, and a
+	// RequestEntityTooLarge one when it has used too much storage.
+	Reserve(actor vocab.IRI, u Usage) error
+	// Release gives back to the "actor" the stored bytes and the media bytes in "u", when the content they
+	// account for gets deleted, or when its publishing fails.
+	// The activities don't get released, as they are counted only for the last hour.
+	Release(actor vocab.IRI, u Usage)
+}
+
+// QuotaTracker is a QuotaPolicy which keeps the usage of the actors in memory.
+// The actors that are missing from its quotas get the default one.
+//
+// NOTE(marius): the usage is tracked per process, so it is lost when the process restarts, and it is not
+// shared between processes serving the same storage. The integrators that need persistent quotas can
+// implement QuotaPolicy on top of their own storage.
+type QuotaTracker struct {
+	def    Quota
+	quotas map[vocab.IRI]Quota
+
+	m     sync.Mutex
+	usage map[vocab.IRI]*Usage
+	times map[vocab.IRI][]time.Time
+	now   func() time.Time
+}
+
+var _ QuotaPolicy = new(QuotaTracker)
+
+// NewQuotaTracker returns a QuotaTracker with the "def" quota for all actors, except the ones in "quotas".
+func NewQuotaTracker(def Quota, quotas map[vocab.IRI]Quota) *QuotaTracker {
+	return &QuotaTracker{
+		def:    def,
+		quotas: quotas,
+		usage:  make(map[vocab.IRI]*Usage),
+		times:  make(map[vocab.IRI][]time.Time),
+		now:    time.Now,
+	}
+}
+
+func (q *QuotaTracker) Quota(actor vocab.IRI) Quota {
+	if quota, ok := q.quotas[actor]; ok {
+		return quota
+	}
+	return q.def
+}
+
+// recentActivities returns the moments when the "actor" has published activities in the last hour,
+// discarding the older ones.
+func (q *QuotaTracker) recentActivities(actor vocab.IRI) []time.Time {
+	since := q.now().Add(-time.Hour)
+	times := q.times[actor]
+	for len(times) > 0 && !times[0].After(since) {
+		times = times[1:]
+	}
+	q.times[actor] = times
+	return times
+}
+
+func (q *QuotaTracker) Usage(actor vocab.IRI) Usage {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	u := Usage{}
+	if cur, ok := q.usage[actor]; ok {
+		u = *cur
+	}
+	times := q.recentActivities(actor)
+	u.Activities = len(times)
+	if len(times) > 0 {
+		u.ActivitiesReset = times[0].Add(time.Hour)
+	}
+	return u
+}
+
+func (q *QuotaTracker) usageFor(actor vocab.IRI) *Usage {
+	u, ok := q.usage[actor]
+	if !ok {
+		u = new(Usage)
+		q.usage[actor] = u
+	}
+	return u
+}
+
+func (q *QuotaTracker) Reserve(actor vocab.IRI, u Usage) error {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	quota := q.Quota(actor)
+	cur := q.usageFor(actor)
+	times := q.recentActivities(actor)
+	if u.Activities > 0 && quota.ActivitiesPerHour > 0 && len(times)+u.Activities > quota.ActivitiesPerHour {
+		retryAfter := time.Duration(0)
+		if len(times) > 0 {
+			retryAfter = times[0].Add(time.Hour).Sub(q.now())
+		}
+		return TooManyRequestsf(retryAfter, "%s has exceeded the quota of %d activities per hour", actor, quota.ActivitiesPerHour)
+	}
+	if u.StoredBytes > 0 && quota.StoredBytes > 0 && cur.StoredBytes+u.StoredBytes > quota.StoredBytes {
+		return RequestEntityTooLargef("%s has exceeded the storage quota of %d bytes", actor, quota.StoredBytes)
+	}
+	if u.MediaBytes > 0 && quota.MediaBytes > 0 && cur.MediaBytes+u.MediaBytes > quota.MediaBytes {
+		return RequestEntityTooLargef("%s has exceeded the media quota of %d bytes", actor, quota.MediaBytes)
+	}
+
+	for range u.Activities {
+		times = append(times, q.now())
+	}
+	q.times[actor] = times
+	cur.StoredBytes += u.StoredBytes
+	cur.MediaBytes += u.MediaBytes
+	return nil
+}
+
+func (q *QuotaTracker) Release(actor vocab.IRI, u Usage) {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	cur := q.usageFor(actor)
+	cur.StoredBytes = max(0, cur.StoredBytes-u.StoredBytes)
+	cur.MediaBytes = max(0, cur.MediaBytes-u.MediaBytes)
+}
+
+// itemSize returns the size in bytes of the JSON representation of "it".
+func itemSize(it vocab.Item) int64 {
+	raw, err := vocab.MarshalJSON(it)
+	if err != nil {
+		return 0
+	}
+	return int64(len(raw))
+}
+
+// onInlineMedia calls "fn" with the media content of the "ob" object, and of its attachments, which is encoded
+// as data URIs.
+func onInlineMedia(ob *vocab.Object, fn func(dataURI []byte)) {
+	for _, nv := range ob.Content {
+		if bytes.HasPrefix(nv, []byte("data:")) {
+			fn(nv)
+			break
+		}
+	}
+	_ = vocab.OnItem(ob.Attachment, func(att vocab.Item) error {
+		if !vocab.IsIRI(att) {
+			_ = vocab.OnObject(att, func(att *vocab.Object) error {
+				onInlineMedia(att, fn)
+				return nil
+			})
+		}
+		return nil
+	})
+}
+
+// inlineMediaSize returns the total size of the media content of the "it" activity's objects, and of their
+// attachments, which is encoded as data URIs.
+func inlineMediaSize(it vocab.Item) int64 {
+	var size int64
+	mrfObjects(it, func(ob *vocab.Object) error {
+		onInlineMedia(ob, func(dataURI []byte) {
+			if _, data, err := decodeDataURI(dataURI); err == nil {
+				size += int64(len(data))
+			}
+		})
+		return nil
+	})
+	return size
+}
+
+// storedObjectTypes are the types of the activities whose objects get stored on behalf of their actor.
+var storedObjectTypes = vocab.ActivityVocabularyTypes{vocab.CreateType, vocab.UpdateType}
+
+// objectsSize returns the size in bytes that the objects of the Create or Update "it" activity will have in
+// storage, without their media content, which gets moved to the BlobStore.
+func objectsSize(it vocab.Item) int64 {
+	if vocab.IsNil(it) || !storedObjectTypes.Match(it.GetType()) {
+		return 0
+	}
+	var size int64
+	mrfObjects(it, func(ob *vocab.Object) error {
+		size += itemSize(ob)
+		onInlineMedia(ob, func(dataURI []byte) {
+			size -= int64(len(dataURI))
+		})
+		return nil
+	})
+	return max(0, size)
+}
+
+// storedObjectsSize returns the size in bytes of the stored copies of the local objects of the Create or
+// Update "it" activity.
+func (p P) storedObjectsSize(it vocab.Item) int64 {
+	if vocab.IsNil(it) || !storedObjectTypes.Match(it.GetType()) {
+		return 0
+	}
+	var size int64
+	_ = vocab.OnActivity(it, func(act *vocab.Activity) error {
+		return vocab.OnItem(act.Object, func(ob vocab.Item) error {
+			if iri := ob.GetLink(); len(iri) > 0 && p.IsLocalIRI(iri) {
+				if full := p.loadLocalCopy(iri); !vocab.IsIRI(full) {
+					size += itemSize(full)
+				}
+			}
+			return nil
+		})
+	})
+	return size
+}
+
+// activityRecipientsCount returns the number of distinct recipients of the "it" activity and of its objects.
+func activityRecipientsCount(it vocab.Item) int {
+	recipients := make(vocab.IRIs, 0)
+	appendRecipients := func(ob *vocab.Object) error {
+		for _, rec := range objectRecipients(ob) {
+			if !vocab.IsNil(rec) && !recipients.Contains(rec.GetLink()) {
+				_ = recipients.Append(rec.GetLink())
+			}
+		}
+		return nil
+	}
+	_ = vocab.OnObject(it, appendRecipients)
+	mrfObjects(it, appendRecipients)
+	return len(recipients)
+}
+
+// reserveQuota checks that publishing the "it" activity doesn't exceed the quota of the local "author", and
+// reserves the resources it uses. They need to be released with releaseQuota if the activity fails to get processed.
+//
+// The stored bytes are the size of the objects of the Create and Update activities, without their inline media,
+// from which the size of the "previous" versions of the updated objects is subtracted.
+func (p P) reserveQuota(it vocab.Item, author vocab.IRI, previous int64) (Usage, error) {
+	u := Usage{}
+	if p.quotaPolicy == nil || vocab.IsNil(it) {
+		return u, nil
+	}
+	quota := p.quotaPolicy.Quota(author)
+	if recipients := activityRecipientsCount(it); quota.RecipientsPerActivity > 0 && recipients > quota.RecipientsPerActivity {
+		return u, RequestEntityTooLargef("activity has %d recipients, more than the maximum of %d", recipients, quota.RecipientsPerActivity)
+	}
+
+	u = Usage{Activities: 1, StoredBytes: max(0, objectsSize(it)-previous), MediaBytes: inlineMediaSize(it)}
+	if err := p.quotaPolicy.Reserve(author, u); err != nil {
+		return Usage{}, err
+	}
+	return u, nil
+}
+
+// settleStoredQuota adjusts the stored bytes "reserved" by reserveQuota for the processed "it" activity, to the size
+// its objects have in storage, from which the size of their "previous" versions is subtracted.
+// This way, the size that gets released when the objects are updated or deleted is the same that was reserved.
+func (p P) settleStoredQuota(author vocab.IRI, it vocab.Item, previous int64, reserved Usage) {
+	if p.quotaPolicy == nil || vocab.IsNil(it) || !storedObjectTypes.Match(it.GetType()) {
+		return
+	}
+	diff := p.storedObjectsSize(it) - previous - reserved.StoredBytes
+	switch {
+	case diff > 0:
+		// NOTE(marius): the activity has already been processed, so if the difference exceeds the quota
+		// we can only log it.
+		if err := p.quotaPolicy.Reserve(author, Usage{StoredBytes: diff}); err != nil {
+			p.l.Warnf("unable to account for the storage of %s: %s", it.GetLink(), err)
+		}
+	case diff < 0:
+		p.quotaPolicy.Release(author, Usage{StoredBytes: -diff})
+	}
+}
+
+// reserveMediaQuota checks that uploading "size" bytes of media content doesn't exceed the quota of
+// the local "author", and reserves them.
+func (p P) reserveMediaQuota(author vocab.IRI, size int64) (Usage, error) {
+	if p.quotaPolicy == nil || size == 0 {
+		return Usage{}, nil
+	}
+	u := Usage{MediaBytes: size}
+	if err := p.quotaPolicy.Reserve(author, u); err != nil {
+		return Usage{}, err
+	}
+	return u, nil
+}
+
+// releaseQuota gives back to the local "author" the resources in "u".
+func (p P) releaseQuota(author vocab.IRI, u Usage) {
+	if p.quotaPolicy == nil || (u.StoredBytes == 0 && u.MediaBytes == 0) {
+		return
+	}
+	p.quotaPolicy.Release(author, u)
+}
+
+// releaseMediaQuota gives back to the local actor which created the "it" object, the "size" of its media
+// which has been removed.
+func (p P) releaseMediaQuota(it vocab.Item, size int64) {
+	if p.quotaPolicy == nil || size == 0 {
+		return
+	}
+	_ = vocab.OnObject(it, func(ob *vocab.Object) error {
+		if !vocab.IsNil(ob.AttributedTo) && p.IsLocalIRI(ob.AttributedTo.GetLink()) {
+			p.releaseQuota(ob.AttributedTo.GetLink(), Usage{MediaBytes: size})
+		}
+		return nil
+	})
+}
+
+// releaseDeletedQuota gives back to the local "author" the resources used by the "deleted" objects, which
+// have been replaced by the "tombstones". The size of the tombstones is released when they get purged.
+func (p P) releaseDeletedQuota(author vocab.IRI, deleted vocab.ItemCollection, tombstones vocab.Item) {
+	if p.quotaPolicy == nil || !p.IsLocalIRI(author) {
+		return
+	}
+	u := Usage{}
+	for _, ob := range deleted {
+		u.StoredBytes += itemSize(ob)
+		u.MediaBytes += p.mediaSize(mediaBlobIRIs(ob)...)
+	}
+	_ = vocab.OnItem(tombstones, func(t vocab.Item) error {
+		u.StoredBytes -= itemSize(t)
+		return nil
+	})
+	u.StoredBytes = max(0, u.StoredBytes)
+	p.releaseQuota(author, u)
+}
+
+// releaseTombstoneQuota gives back to the local actor which created the object the "it" tombstone replaced,
+// the size of the tombstone.
+func (p P) releaseTombstoneQuota(it vocab.Item) {
+	if p.quotaPolicy == nil {
+		return
+	}
+	_ = vocab.OnTombstone(it, func(t *vocab.Tombstone) error {
+		if vocab.IsNil(t.AttributedTo) || !p.IsLocalIRI(t.AttributedTo.GetLink()) {
+			return nil
+		}
+		p.releaseQuota(t.AttributedTo.GetLink(), Usage{StoredBytes: itemSize(t)})
+		return nil
+	})
+}
@@ -0,0 +1,275 @@
+package processing
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+)
+
+func TestQuotaTracker_Usage(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	q := NewQuotaTracker(Quota{ActivitiesPerHour: 10}, map[vocab.IRI]Quota{defaultActorID: {ActivitiesPerHour: 1}})
+	q.now = func() time.Time { return now }
+
+	if got := q.Quota(defaultActorID); got.ActivitiesPerHour != 1 {
+		t.Errorf("Quota() = %v, expected the quota of the actor", got)
+	}
+	if got := q.Quota("https://example.com/~jdoe"); got.ActivitiesPerHour != 10 {
+		t.Errorf("Quota() = %v, expected the default quota", got)
+	}
+
+	if err := q.Reserve(defaultActorID, Usage{Activities: 1, StoredBytes: 100}); err != nil {
+		t.Fatalf("Reserve() error = %s", err)
+	}
+	now = now.Add(30 * time.Minute)
+	if err := q.Reserve(defaultActorID, Usage{Activities: 1, StoredBytes: 50, MediaBytes: 1000}); !IsTooManyRequests(err) {
+		t.Fatalf("Reserve() error = %v, expected too many requests", err)
+	}
+	q.quotas = nil
+	if err := q.Reserve(defaultActorID, Usage{Activities: 1, StoredBytes: 50, MediaBytes: 1000}); err != nil {
+		t.Fatalf("Reserve() error = %s", err)
+	}
+
+	u := q.Usage(defaultActorID)
+	if u.Activities != 2 || u.StoredBytes != 150 || u.MediaBytes != 1000 {
+		t.Errorf("Usage() = %+v, expected 2 activities, 150 stored bytes and 1000 media bytes", u)
+	}
+	if want := now.Add(30 * time.Minute); !u.ActivitiesReset.Equal(want) {
+		t.Errorf("Usage() activities reset = %s, want %s", u.ActivitiesReset, want)
+	}
+
+	now = now.Add(45 * time.Minute)
+	if u = q.Usage(defaultActorID); u.Activities != 1 || u.StoredBytes != 150 {
+		t.Errorf("Usage() = %+v, expected 1 activity in the last hour and 150 stored bytes", u)
+	}
+
+	q.Release(defaultActorID, Usage{Activities: 1, StoredBytes: 100, MediaBytes: 2000})
+	if u = q.Usage(defaultActorID); u.Activities != 1 || u.StoredBytes != 50 || u.MediaBytes != 0 {
+		t.Errorf("Usage() = %+v after Release(), expected 1 activity, 50 stored bytes and no media bytes", u)
+	}
+}
+
+func Test_activityRecipientsCount(t *testing.T) {
+	act := &vocab.Activity{
+		Type: vocab.CreateType,
+		To:   vocab.ItemCollection{vocab.PublicNS},
+		CC:   vocab.ItemCollection{vocab.Followers.IRI(defaultActor)},
+		Object: &vocab.Object{
+			Type: vocab.NoteType,
+			To:   vocab.ItemCollection{vocab.PublicNS},
+			CC:   vocab.ItemCollection{vocab.Followers.IRI(defaultActor), vocab.IRI("https://remote.example.com/~jdoe")},
+			BCC:  vocab.ItemCollection{vocab.IRI("https://remote.example.com/~jane")},
+		},
+	}
+	if got := activityRecipientsCount(act); got != 4 {
+		t.Errorf("activityRecipientsCount() = %d, want 4", got)
+	}
+}
+
+func Test_inlineMediaSize(t *testing.T) {
+	act := mrfCreate(&vocab.Object{
+		Type:    vocab.NoteType,
+		Content: vocab.DefaultNaturalLanguage("data:text/plain,hello"),
+		Attachment: vocab.ItemCollection{
+			&vocab.Object{Type: vocab.ImageType, Content: vocab.DefaultNaturalLanguage("data:image/png;base64,iVBORw0KGgo=")},
+			vocab.IRI("https://remote.example.com/media/1"),
+		},
+	})
+	if got := inlineMediaSize(act); got != 5+8 {
+		t.Errorf("inlineMediaSize() = %d, want %d", got, 5+8)
+	}
+}
+
+func TestP_reserveQuota(t *testing.T) {
+	note := func(recipients ...vocab.Item) *vocab.Activity {
+		return &vocab.Activity{
+			Type:   vocab.CreateType,
+			Actor:  defaultActorID,
+			To:     recipients,
+			Object: &vocab.Object{Type: vocab.NoteType, Content: vocab.DefaultNaturalLanguage("data:text/plain,hello")},
+		}
+	}
+	tests := []struct {
+		name         string
+		quota        Quota
+		usage        Usage
+		it           vocab.Item
+		wantTooMany  bool
+		wantTooLarge bool
+	}{
+		{
+			name: "no quota",
+			it:   note(vocab.PublicNS),
+		},
+		{
+			name:        "activities per hour exceeded",
+			quota:       Quota{ActivitiesPerHour: 1},
+			usage:       Usage{Activities: 1, StoredBytes: 10},
+			it:          note(vocab.PublicNS),
+			wantTooMany: true,
+		},
+		{
+			name:         "too many recipients",
+			quota:        Quota{RecipientsPerActivity: 1},
+			it:           note(vocab.PublicNS, vocab.IRI("https://remote.example.com/~jdoe")),
+			wantTooLarge: true,
+		},
+		{
+			name:         "storage exceeded",
+			quota:        Quota{StoredBytes: 100},
+			usage:        Usage{Activities: 1, StoredBytes: 90},
+			it:           note(vocab.PublicNS),
+			wantTooLarge: true,
+		},
+		{
+			name:         "media exceeded",
+			quota:        Quota{MediaBytes: 10},
+			usage:        Usage{MediaBytes: 6},
+			it:           note(vocab.PublicNS),
+			wantTooLarge: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := NewQuotaTracker(Quota{}, nil)
+			if err := q.Reserve(defaultActorID, tt.usage); err != nil {
+				t.Fatalf("Reserve() error = %s", err)
+			}
+			q.def = tt.quota
+			before := q.Usage(defaultActorID)
+
+			p := mockProcessor(t, defaultActorID)
+			p.quotaPolicy = q
+			u, err := p.reserveQuota(tt.it, defaultActorID, 0)
+			if IsTooManyRequests(err) != tt.wantTooMany {
+				t.Errorf("reserveQuota() error = %v, want too many requests %v", err, tt.wantTooMany)
+			}
+			if IsRequestEntityTooLarge(err) != tt.wantTooLarge {
+				t.Errorf("reserveQuota() error = %v, want request entity too large %v", err, tt.wantTooLarge)
+			}
+			after := q.Usage(defaultActorID)
+			if err != nil {
+				if after != before {
+					t.Errorf("reserveQuota() usage = %+v, expected it to be unchanged %+v on error", after, before)
+				}
+				return
+			}
+			if got := after.Activities - before.Activities; got != 1 {
+				t.Errorf("reserveQuota() reserved %d activities, want 1", got)
+			}
+			if got := after.StoredBytes - before.StoredBytes; got <= 0 || got != u.StoredBytes {
+				t.Errorf("reserveQuota() reserved %d stored bytes, expected the size of the object %d", got, u.StoredBytes)
+			}
+			if got := after.MediaBytes - before.MediaBytes; got != 5 {
+				t.Errorf("reserveQuota() reserved %d media bytes, want 5", got)
+			}
+
+			p.releaseQuota(defaultActorID, u)
+			if released := q.Usage(defaultActorID); released.StoredBytes != before.StoredBytes || released.MediaBytes != before.MediaBytes {
+				t.Errorf("releaseQuota() usage = %+v, want the bytes of %+v", released, before)
+			}
+		})
+	}
+}
+
+func Test_objectsSize(t *testing.T) {
+	data := bytes.Repeat([]byte("a"), 1024)
+	ob := &vocab.Object{
+		Type:    vocab.ImageType,
+		Content: vocab.DefaultNaturalLanguage("data:image/png;base64," + base64.StdEncoding.EncodeToString(data)),
+	}
+	got := objectsSize(mrfCreate(ob))
+	if got <= 0 || got >= int64(len(data)) {
+		t.Errorf("objectsSize() = %d, expected the size of the object without its %d bytes of inline media", got, len(data))
+	}
+	if got = objectsSize(&vocab.Activity{Type: vocab.LikeType, Object: ob}); got != 0 {
+		t.Errorf("objectsSize() = %d for a Like, expected 0", got)
+	}
+}
+
+func TestP_settleStoredQuota(t *testing.T) {
+	p := mockProcessor(t, defaultActorID)
+	q := NewQuotaTracker(Quota{}, nil)
+	p.quotaPolicy = q
+
+	ob := &vocab.Object{
+		ID:           defaultActorID + "/objects/1",
+		Type:         vocab.NoteType,
+		AttributedTo: defaultActorID,
+		Content:      vocab.DefaultNaturalLanguage("hello"),
+	}
+	create := &vocab.Activity{Type: vocab.CreateType, Actor: defaultActorID, Object: ob}
+	reserved, err := p.reserveQuota(create, defaultActorID, 0)
+	if err != nil {
+		t.Fatalf("reserveQuota() error = %s", err)
+	}
+	// NOTE(marius): the object gets more properties when it's processed
+	ob.Published = time.Now().UTC()
+	if _, err = p.s.Save(ob); err != nil {
+		t.Fatalf("unable to save object: %s", err)
+	}
+	p.settleStoredQuota(defaultActorID, create, 0, reserved)
+	if got := q.Usage(defaultActorID).StoredBytes; got != itemSize(ob) {
+		t.Errorf("settleStoredQuota() stored bytes = %d after Create, want the size of the object %d", got, itemSize(ob))
+	}
+
+	// NOTE(marius): the Update releases the size of the previous version of the object
+	previous := p.storedObjectsSize(create)
+	updated := *ob
+	updated.Content = vocab.DefaultNaturalLanguage("hello, world")
+	upd := &vocab.Activity{Type: vocab.UpdateType, Actor: defaultActorID, Object: &updated}
+	if reserved, err = p.reserveQuota(upd, defaultActorID, previous); err != nil {
+		t.Fatalf("reserveQuota() error = %s", err)
+	}
+	if _, err = p.s.Save(&updated); err != nil {
+		t.Fatalf("unable to save object: %s", err)
+	}
+	p.settleStoredQuota(defaultActorID, upd, previous, reserved)
+	if got := q.Usage(defaultActorID).StoredBytes; got != itemSize(&updated) {
+		t.Errorf("settleStoredQuota() stored bytes = %d after Update, want the size of the updated object %d", got, itemSize(&updated))
+	}
+}
+
+func TestP_deleteActivityWithCascade_releasesQuota(t *testing.T) {
+	p := mockProcessor(t, defaultActorID)
+	q := NewQuotaTracker(Quota{}, nil)
+	p.quotaPolicy = q
+	p.blobs = NewFSBlobStore(t.TempDir(), "https://jdoe.example.com/blobs")
+	blob, _, err := p.blobs.Put("image/png", make([]byte, 100))
+	if err != nil {
+		t.Fatalf("unable to save blob: %s", err)
+	}
+
+	ob := &vocab.Object{
+		ID:           "https://jdoe.example.com/objects/1",
+		Type:         vocab.ImageType,
+		AttributedTo: defaultActorID,
+		URL:          mediaLink(blob, "image/png", mediaMetadata{}),
+	}
+	if _, err := p.s.Save(ob); err != nil {
+		t.Fatalf("unable to save object: %s", err)
+	}
+	size := itemSize(ob)
+	if err := q.Reserve(defaultActorID, Usage{Activities: 1, StoredBytes: size, MediaBytes: 100}); err != nil {
+		t.Fatalf("Reserve() error = %s", err)
+	}
+
+	del := &vocab.Activity{ID: "https://jdoe.example.com/activities/1", Type: vocab.DeleteType, Actor: defaultActorID, Object: ob.ID}
+	if del, err = p.deleteActivityWithCascade(del); err != nil {
+		t.Fatalf("deleteActivityWithCascade() error = %s", err)
+	}
+	tombstoneSize := itemSize(del.Object)
+	if u := q.Usage(defaultActorID); u.StoredBytes != tombstoneSize || u.MediaBytes != 0 {
+		t.Errorf("Usage() = %+v after Delete, want %d stored bytes for the tombstone and no media bytes", u, tombstoneSize)
+	}
+
+	if err = p.purgeTombstone(del.Object); err != nil {
+		t.Fatalf("purgeTombstone() error = %s", err)
+	}
+	if u := q.Usage(defaultActorID); u.StoredBytes != 0 {
+		t.Errorf("Usage() = %+v after purging the tombstone, want no stored bytes", u)
+	}
+}
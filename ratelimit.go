@@ -0,0 +1,207 @@
+package processing
+
+import (
+	"container/list"
+	"math"
+	"sync"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+)
+
+// RateLimit is the budget of a token bucket: it holds at most Burst tokens, and it gets refilled
+// with one token every Interval. Each activity consumes a token.
+// A RateLimit with a zero Burst, or a zero Interval, doesn't limit anything.
+type RateLimit struct {
+	Burst    int
+	Interval time.Duration
+}
+
+func (l RateLimit) unlimited() bool {
+	return l.Burst <= 0 || l.Interval <= 0
+}
+
+// RateLimits are the budgets for the activity types that need separate ones.
+// The empty type key holds the budget for all other activity types.
+type RateLimits map[vocab.ActivityVocabularyType]RateLimit
+
+// bucketFor returns the type key of the bucket which "typ" activities consume from, and its budget.
+func (r RateLimits) bucketFor(typ vocab.ActivityVocabularyType) (vocab.ActivityVocabularyType, RateLimit) {
+	if l, ok := r[typ]; ok {
+		return typ, l
+	}
+	return "", r[""]
+}
+
+// DefaultActorRateLimits are reasonable budgets for the activities received from a single remote actor.
+var DefaultActorRateLimits = RateLimits{
+	"":               {Burst: 300, Interval: time.Second},
+	vocab.CreateType: {Burst: 30, Interval: 10 * time.Second},
+	vocab.FollowType: {Burst: 10, Interval: time.Minute},
+	vocab.FlagType:   {Burst: 5, Interval: 5 * time.Minute},
+}
+
+// DefaultHostRateLimits are reasonable budgets for the activities received from all the actors of a remote server.
+var DefaultHostRateLimits = RateLimits{
+	"":               {Burst: 3000, Interval: 100 * time.Millisecond},
+	vocab.CreateType: {Burst: 300, Interval: time.Second},
+	vocab.FollowType: {Burst: 100, Interval: 6 * time.Second},
+	vocab.FlagType:   {Burst: 20, Interval: time.Minute},
+}
+
+type tokenBucket struct {
+	key    bucketKey
+	tokens float64
+	last   time.Time
+}
+
+// refill adds to the bucket the tokens accumulated since it was last used.
+// It returns true if the bucket is full.
+func (b *tokenBucket) refill(l RateLimit, now time.Time) bool {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(float64(l.Burst), b.tokens+float64(elapsed)/float64(l.Interval))
+		b.last = now
+	}
+	return b.tokens >= float64(l.Burst)
+}
+
+// wait returns how long until the bucket has a token available.
+func (b *tokenBucket) wait(l RateLimit) time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) * float64(l.Interval))
+}
+
+type bucketKey struct {
+	owner string
+	host  bool
+	typ   vocab.ActivityVocabularyType
+}
+
+// RateLimiter limits the volume of activities received from other servers, using token buckets
+// for each remote actor, and for each remote host.
+// It keeps at most rateLimiterMaxBuckets buckets, discarding the least recently used ones.
+type RateLimiter struct {
+	actor RateLimits
+	host  RateLimits
+
+	m       sync.Mutex
+	buckets map[bucketKey]*list.Element
+	lru     *list.List
+	max     int
+	pruned  time.Time
+	now     func() time.Time
+}
+
+const (
+	// rateLimiterPruneInterval is how often the RateLimiter discards the full buckets, which are
+	// equivalent with missing ones.
+	rateLimiterPruneInterval = 5 * time.Minute
+	// rateLimiterMaxBuckets is the maximum number of buckets the RateLimiter keeps in memory.
+	rateLimiterMaxBuckets = 1 << 16
+)
+
+// NewRateLimiter returns a RateLimiter with the "actor" budgets for each remote actor, and the "host" budgets
+// for each remote host.
+func NewRateLimiter(actor, host RateLimits) *RateLimiter {
+	return &RateLimiter{
+		actor:   actor,
+		host:    host,
+		buckets: make(map[bucketKey]*list.Element),
+		lru:     list.New(),
+		max:     rateLimiterMaxBuckets,
+		now:     time.Now,
+	}
+}
+
+func (r *RateLimiter) bucket(key bucketKey, l RateLimit, now time.Time) *tokenBucket {
+	el, ok := r.buckets[key]
+	if ok {
+		r.lru.MoveToFront(el)
+	} else {
+		el = r.lru.PushFront(&tokenBucket{key: key, tokens: float64(l.Burst), last: now})
+		r.buckets[key] = el
+		for r.max > 0 && r.lru.Len() > r.max {
+			r.remove(r.lru.Back())
+		}
+	}
+	b := el.Value.(*tokenBucket)
+	b.refill(l, now)
+	return b
+}
+
+func (r *RateLimiter) remove(el *list.Element) {
+	delete(r.buckets, el.Value.(*tokenBucket).key)
+	r.lru.Remove(el)
+}
+
+func (r *RateLimiter) prune(now time.Time) {
+	if now.Sub(r.pruned) < rateLimiterPruneInterval {
+		return
+	}
+	for el := r.lru.Front(); el != nil; {
+		next := el.Next()
+		b := el.Value.(*tokenBucket)
+		limits := r.actor
+		if b.key.host {
+			limits = r.host
+		}
+		if _, l := limits.bucketFor(b.key.typ); l.unlimited() || b.refill(l, now) {
+			r.remove(el)
+		}
+		el = next
+	}
+	r.pruned = now
+}
+
+// Allow checks if the "actor" and its host have enough budget left for an activity of type "typ", and if so,
+// it consumes it. When they don't, it returns false, and the duration after which the activity would be allowed.
+func (r *RateLimiter) Allow(actor vocab.IRI, typ vocab.ActivityVocabularyType) (bool, time.Duration) {
+	if r == nil {
+		return true, 0
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	now := r.now()
+	r.prune(now)
+
+	type check struct {
+		b *tokenBucket
+		l RateLimit
+	}
+	checks := make([]check, 0, 2)
+	if key, l := r.actor.bucketFor(typ); !l.unlimited() {
+		checks = append(checks, check{b: r.bucket(bucketKey{owner: actor.String(), typ: key}, l, now), l: l})
+	}
+	if key, l := r.host.bucketFor(typ); !l.unlimited() {
+		checks = append(checks, check{b: r.bucket(bucketKey{owner: iriHost(actor), host: true, typ: key}, l, now), l: l})
+	}
+
+	var retryAfter time.Duration
+	for _, c := range checks {
+		if wait := c.b.wait(c.l); wait > retryAfter {
+			retryAfter = wait
+		}
+	}
+	if retryAfter > 0 {
+		return false, retryAfter
+	}
+	for _, c := range checks {
+		c.b.tokens--
+	}
+	return true, 0
+}
+
+// validateRateLimit returns a 429 Too Many Requests error if the remote "author" has exceeded
+// the budget for activities of type "typ".
+func (p P) validateRateLimit(author vocab.IRI, typ vocab.ActivityVocabularyType) error {
+	if p.rateLimiter == nil || p.IsLocalIRI(author) {
+		return nil
+	}
+	if ok, retryAfter := p.rateLimiter.Allow(author, typ); !ok {
+		return TooManyRequestsf(retryAfter, "too many %s activities from %s", typ, author)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package processing
+
+import (
+	"testing"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+)
+
+func TestRateLimiter_Allow(t *testing.T) {
+	alice := vocab.IRI("https://remote.example.com/~alice")
+	bob := vocab.IRI("https://remote.example.com/~bob")
+	eve := vocab.IRI("https://other.example.com/~eve")
+
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	r := NewRateLimiter(
+		RateLimits{
+			"":               {Burst: 10, Interval: time.Second},
+			vocab.FollowType: {Burst: 2, Interval: time.Minute},
+		},
+		RateLimits{
+			vocab.FollowType: {Burst: 3, Interval: time.Minute},
+		},
+	)
+	r.now = func() time.Time { return now }
+
+	type step struct {
+		name      string
+		actor     vocab.IRI
+		typ       vocab.ActivityVocabularyType
+		advance   time.Duration
+		want      bool
+		wantRetry time.Duration
+	}
+	steps := []step{
+		{name: "first follow", actor: alice, typ: vocab.FollowType, want: true},
+		{name: "second follow", actor: alice, typ: vocab.FollowType, want: true},
+		{name: "actor follow budget exhausted", actor: alice, typ: vocab.FollowType, want: false, wantRetry: time.Minute},
+		{name: "other types have separate budget", actor: alice, typ: vocab.CreateType, want: true},
+		{name: "other actor on same host", actor: bob, typ: vocab.FollowType, want: true},
+		{name: "host follow budget exhausted", actor: bob, typ: vocab.FollowType, want: false, wantRetry: time.Minute},
+		{name: "other host", actor: eve, typ: vocab.FollowType, want: true},
+		{name: "partially refilled", actor: alice, typ: vocab.FollowType, advance: 30 * time.Second, want: false, wantRetry: 30 * time.Second},
+		{name: "refilled", actor: alice, typ: vocab.FollowType, advance: 30 * time.Second, want: true},
+	}
+	for _, s := range steps {
+		now = now.Add(s.advance)
+		got, retry := r.Allow(s.actor, s.typ)
+		if got != s.want {
+			t.Fatalf("%s: Allow() = %v, want %v", s.name, got, s.want)
+		}
+		if retry != s.wantRetry {
+			t.Errorf("%s: Allow() retry after = %s, want %s", s.name, retry, s.wantRetry)
+		}
+	}
+}
+
+func TestRateLimiter_prune(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	r := NewRateLimiter(RateLimits{"": {Burst: 2, Interval: time.Second}}, nil)
+	r.now = func() time.Time { return now }
+
+	if ok, _ := r.Allow("https://remote.example.com/~alice", vocab.CreateType); !ok {
+		t.Fatalf("Allow() = false for first activity")
+	}
+	if len(r.buckets) != 1 {
+		t.Fatalf("expected 1 bucket, got %d", len(r.buckets))
+	}
+	now = now.Add(rateLimiterPruneInterval)
+	r.prune(now)
+	if len(r.buckets) != 0 {
+		t.Errorf("expected refilled buckets to be pruned, got %d", len(r.buckets))
+	}
+}
+
+func TestRateLimiter_maxBuckets(t *testing.T) {
+	alice := vocab.IRI("https://remote.example.com/~alice")
+	bob := vocab.IRI("https://remote.example.com/~bob")
+	eve := vocab.IRI("https://remote.example.com/~eve")
+
+	r := NewRateLimiter(RateLimits{"": {Burst: 1, Interval: time.Hour}}, nil)
+	r.max = 2
+	for _, actor := range []vocab.IRI{alice, bob, alice, eve} {
+		_, _ = r.Allow(actor, vocab.CreateType)
+	}
+	if len(r.buckets) != 2 || r.lru.Len() != 2 {
+		t.Fatalf("expected 2 buckets, got %d", len(r.buckets))
+	}
+	if _, ok := r.buckets[bucketKey{owner: bob.String()}]; ok {
+		t.Errorf("expected the least recently used bucket of %s to be discarded", bob)
+	}
+	if ok, _ := r.Allow(alice, vocab.CreateType); ok {
+		t.Errorf("Allow() = true, expected the bucket of %s to be kept", alice)
+	}
+}
+
+func TestP_validateRateLimit(t *testing.T) {
+	remote := vocab.IRI("https://remote.example.com/~jdoe")
+
+	p := mockProcessor(t, defaultActorID)
+	p.rateLimiter = NewRateLimiter(RateLimits{vocab.FlagType: {Burst: 1, Interval: time.Hour}}, nil)
+
+	if err := p.validateRateLimit(remote, vocab.FlagType); err != nil {
+		t.Fatalf("validateRateLimit() error = %s", err)
+	}
+	err := p.validateRateLimit(remote, vocab.FlagType)
+	if !IsTooManyRequests(err) {
+		t.Fatalf("validateRateLimit() error = %v, expected too many requests", err)
+	}
+	if RetryAfter(err) <= 0 {
+		t.Errorf("validateRateLimit() error doesn't have a retry after duration")
+	}
+	for range 3 {
+		if err = p.validateRateLimit(defaultActorID, vocab.FlagType); err != nil {
+			t.Errorf("validateRateLimit() error = %s for local actor", err)
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package processing
 
 import (
+	"time"
+
 	"git.sr.ht/~mariusor/lw"
 	vocab "github.com/go-ap/activitypub"
 	"github.com/go-ap/errors"
@@ -17,6 +19,8 @@ func ReactionsActivity(p *P, act *vocab.Activity, receivedIn vocab.IRI) (*vocab.
 	var err error
 	if act.Object != nil {
 		switch {
+		case p.isReportModeration(act):
+			act, err = ModerateReportActivity(p, act)
 		case vocab.DislikeType.Match(act.Type):
 			fallthrough
 		case vocab.LikeType.Match(act.Type):
@@ -33,7 +37,9 @@ func ReactionsActivity(p *P, act *vocab.Activity, receivedIn vocab.IRI) (*vocab.
 		case vocab.BlockType.Match(act.Type):
 			act, err = BlockActivity(p, act, receivedIn)
 		case vocab.FlagType.Match(act.Type):
-			act, err = FlagActivity(p.s, act)
+			if act, err = FlagActivity(p.s, act); err == nil {
+				err = p.fileReport(act, receivedIn)
+			}
 		case vocab.IgnoreType.Match(act.Type):
 			act, err = IgnoreActivity(p, act)
 		}
@@ -42,9 +48,26 @@ func ReactionsActivity(p *P, act *vocab.Activity, receivedIn vocab.IRI) (*vocab.
 	return act, err
 }
 
+const (
+	// DislikedCollection is the collection of objects an actor has disliked, the counterpart of the liked collection.
+	DislikedCollection = vocab.CollectionPath("disliked")
+	// DislikesCollection is the collection of Dislike activities for an object, the counterpart of the likes collection.
+	DislikesCollection = vocab.CollectionPath("dislikes")
+)
+
+// appreciationCollections returns the collections that an appreciation activity of type "typ" operates on:
+// the first one belongs to the activity's actor and the second one belongs to the activity's object.
+func appreciationCollections(typ vocab.ActivityVocabularyType) (vocab.CollectionPath, vocab.CollectionPath) {
+	if vocab.DislikeType.Match(typ) {
+		return DislikedCollection, DislikesCollection
+	}
+	return vocab.Liked, vocab.Likes
+}
+
 // AppreciationActivity
 // The Like(and Dislike) activity indicates the actor likes the object.
 // The side effect of receiving this in an outbox is that the server SHOULD add the object to the actor's liked Collection.
+// For Dislike activities we use the actor's disliked and the object's dislikes Collections instead.
 func AppreciationActivity(p *P, act *vocab.Activity) (*vocab.Activity, error) {
 	if vocab.IsNil(act.Object) {
 		return act, errors.BadRequestf("Missing object for %s Activity", act.Type)
@@ -70,46 +93,47 @@ func AppreciationActivity(p *P, act *vocab.Activity) (*vocab.Activity, error) {
 		return objects.Append(item)
 	})
 
-	// NOTE(marius): we're only saving to the Liked and Likes collections for Likes in order to conform to the spec.
-	if vocab.LikeType.Match(act.GetType()) {
-		saveToCollections := func(actors, objects vocab.ItemCollection) error {
-			errs := make([]error, 0)
-			colToAdd := make(map[vocab.IRI][]vocab.IRI)
+	// NOTE(marius): Likes are saved to the Liked and Likes collections in order to conform to the spec,
+	// while Dislikes are saved to the separate Disliked and Dislikes collections.
+	actorCol, objectCol := appreciationCollections(act.GetType())
+	saveToCollections := func(actors, objects vocab.ItemCollection) error {
+		errs := make([]error, 0)
+		colToAdd := make(map[vocab.IRI][]vocab.IRI)
 
-			for _, object := range objects {
-				for _, actor := range actors {
-					liked := vocab.Liked.IRI(actor)
-					colToAdd[liked] = append(colToAdd[liked], object.GetLink())
-				}
+		for _, object := range objects {
+			for _, actor := range actors {
+				liked := actorCol.IRI(actor)
+				colToAdd[liked] = append(colToAdd[liked], object.GetLink())
+			}
 
-				if !likeWasSavedLocally {
-					p.l.WithContext(lw.Ctx{"iri": act.ID, "typ": act.Type}).Warnf("Activity was not saved locally, unable to add it to collections.")
-					break
-				}
-				likes := vocab.Likes.IRI(object)
-				colToAdd[likes] = append(colToAdd[likes], act.GetLink())
+			if !likeWasSavedLocally {
+				p.l.WithContext(lw.Ctx{"iri": act.ID, "typ": act.Type}).Warnf("Activity was not saved locally, unable to add it to collections.")
+				break
 			}
-			for col, iris := range colToAdd {
-				for _, iri := range iris {
-					if err := p.AddItemToCollection(col, iri); err != nil {
-						errs = append(errs, errors.Annotatef(err, "Unable to save %s to collection %s", iris, col))
-					}
+			likes := objectCol.IRI(object)
+			colToAdd[likes] = append(colToAdd[likes], act.GetLink())
+		}
+		for col, iris := range colToAdd {
+			for _, iri := range iris {
+				if err := p.AddItemToCollection(col, iri); err != nil {
+					errs = append(errs, errors.Annotatef(err, "Unable to save %s to collection %s", iris, col))
 				}
 			}
-			return errors.Join(errs...)
 		}
-		// TODO(marius): do something sensible with these errors, they shouldn't stop execution,
-		//               but they are still good to know
-		_ = saveToCollections(actors, objects)
+		return errors.Join(errs...)
 	}
+	// TODO(marius): do something sensible with these errors, they shouldn't stop execution,
+	//               but they are still good to know
+	_ = saveToCollections(actors, objects)
 	return act, nil
 }
 
 // UndoAppreciationActivity
 //
 // Removes the side effects of an existing Appreciation activity (Like or Dislike)
-// Currently this means only removal of the Liked/Disliked object from the actor's `liked` collection and
-// removal of the Like/Dislike Activity from the object's `likes` collection
+// Currently this means only removal of the Liked object from the actor's `liked` collection and
+// removal of the Like Activity from the object's `likes` collection.
+// For a Dislike the same operations happen on the actor's `disliked` and on the object's `dislikes` collections.
 func (p *P) UndoAppreciationActivity(like *vocab.Activity) (*vocab.Activity, error) {
 	if like == nil {
 		return like, InvalidActivity("nil Like activity")
@@ -117,13 +141,24 @@ func (p *P) UndoAppreciationActivity(like *vocab.Activity) (*vocab.Activity, err
 	errs := make([]error, 0)
 	toRemove := like.GetLink()
 
+	actorCol, objectCol := appreciationCollections(like.GetType())
 	allRec := like.Recipients()
 	removeFromCols := make(vocab.IRIs, 0)
 	if p.IsLocal(like.Actor) {
-		_ = removeFromCols.Append(vocab.Outbox.IRI(like.Actor), vocab.Liked.IRI(like.Actor))
+		_ = removeFromCols.Append(vocab.Outbox.IRI(like.Actor))
+
+		// NOTE(marius): the actor's collection contains the appreciated objects, not the activity.
+		objects := make(vocab.ItemCollection, 0)
+		_ = vocab.OnItem(like.Object, func(ob vocab.Item) error {
+			return objects.Append(ob.GetLink())
+		})
+		liked := actorCol.IRI(like.Actor)
+		if err := p.s.RemoveFrom(liked, objects...); err != nil && !errors.IsNotFound(err) {
+			errs = append(errs, errors.Annotatef(err, "unable to remove from collection %s", liked))
+		}
 	}
 	if p.IsLocal(like.Object) {
-		_ = removeFromCols.Append(vocab.Likes.IRI(like.Object))
+		_ = removeFromCols.Append(objectCol.IRI(like.Object))
 	}
 	for _, rec := range allRec {
 		recIRI := rec.GetLink()
@@ -144,7 +179,7 @@ func (p *P) UndoAppreciationActivity(like *vocab.Activity) (*vocab.Activity, err
 		}
 	}
 	if len(errs) > 0 {
-		return like, errors.Annotatef(errors.Join(errs...), "failed Undo Like activity")
+		return like, errors.Annotatef(errors.Join(errs...), "failed Undo %s activity", like.GetType())
 	}
 	return like, nil
 }
@@ -203,6 +238,9 @@ func dispatchFollowSideEffectToLocalCollections(p *P, a *vocab.Activity) error {
 	if !good.Match(a.Type) {
 		return errors.BadRequestf("Object Activity has wrong type %s, expected %v", a.Type, good)
 	}
+	if p.followIsBlocked(a) {
+		return errors.Forbiddenf("unable to accept Follow between actors that have blocked each other")
+	}
 
 	errs := make([]error, 0, 2)
 	_ = vocab.OnItem(a.Object, func(ob vocab.Item) error {
@@ -246,6 +284,11 @@ const BlockedCollection = vocab.CollectionPath("blocked")
 // The server SHOULD prevent the blocked user from interacting with any object posted by the actor.
 //
 // Servers SHOULD NOT deliver Block Activities to their object.
+//
+// Besides adding the object to the blocked collection of the actor, the follow relationships between the actor
+// and the object get removed in both directions, and they get restored when the Block is undone.
+// While the block exists, the object's future Follow activities are rejected, and the actor's content
+// is hidden from it by the P.AuthorizedItemHandler and P.AuthorizedCollectionHandler handlers.
 func BlockActivity(p *P, act *vocab.Activity, receivedIn vocab.IRI) (*vocab.Activity, error) {
 	if vocab.IsNil(act.Object) {
 		return act, errors.BadRequestf("Missing object for %s Activity", act.Type)
@@ -264,9 +307,161 @@ func BlockActivity(p *P, act *vocab.Activity, receivedIn vocab.IRI) (*vocab.Acti
 	act.Bto.Remove(obIRI)
 	act.BCC.Remove(obIRI)
 
+	if err := p.severFollowRelationships(act); err != nil {
+		p.l.Warnf("unable to remove the follow relationships of blocked actor %s: %s", obIRI, err)
+	}
 	return act, p.AddItemToCollection(BlockedCollection.IRI(act.Actor), obIRI)
 }
 
+// SeveredCollection is the hidden collection of a Block activity, where we keep the follow relationships it has
+// severed, so they can be restored when the Block gets undone.
+//
+// Each relationship is stored as the IRI of a collection of the blocked actor: its following collection when it was
+// following the actor of the Block, and its followers collection when the actor of the Block was following it.
+const SeveredCollection = vocab.CollectionPath("severed")
+
+// severFollowRelationships removes the actor and the objects of the "block" activity from each other's local followers
+// and following collections, and saves the relationships it removed in the severed collection of the Block.
+//
+// When the actor of the Block is local, the servers of its remote objects are notified that the relationships have
+// ended: a Reject of their Follow is sent when they were following the actor, and an Undo of its Follow when the actor
+// was following them.
+func (p *P) severFollowRelationships(block *vocab.Activity) error {
+	blocker := block.Actor
+	errs := make([]error, 0)
+	removeFrom := func(colIRI vocab.IRI, it vocab.Item) bool {
+		if !p.IsLocalIRI(colIRI) || !p.isCollectionMember(colIRI, it.GetLink()) {
+			return false
+		}
+		if err := p.s.RemoveFrom(colIRI, it.GetLink()); err != nil && !errors.IsNotFound(err) {
+			errs = append(errs, errors.Annotatef(err, "unable to remove from collection %s", colIRI))
+			return false
+		}
+		return true
+	}
+
+	severed := make(vocab.IRIs, 0)
+	_ = vocab.OnItem(block.Object, func(blocked vocab.Item) error {
+		wasFollower := removeFrom(vocab.Followers.IRI(blocker), blocked)
+		wasFollower = removeFrom(vocab.Following.IRI(blocked), blocker) || wasFollower
+		wasFollowed := removeFrom(vocab.Following.IRI(blocker), blocked)
+		wasFollowed = removeFrom(vocab.Followers.IRI(blocked), blocker) || wasFollowed
+
+		federate := p.IsLocal(blocker) && !p.IsLocal(blocked)
+		if wasFollower {
+			_ = severed.Append(vocab.Following.IRI(blocked))
+			if federate {
+				follow := &vocab.Activity{Type: vocab.FollowType, Actor: blocked.GetLink(), Object: blocker.GetLink()}
+				if err := p.federateFollowChange(block, vocab.RejectType, follow, blocked); err != nil {
+					errs = append(errs, err)
+				}
+			}
+		}
+		if wasFollowed {
+			_ = severed.Append(vocab.Followers.IRI(blocked))
+			if federate {
+				follow := &vocab.Activity{Type: vocab.FollowType, Actor: blocker.GetLink(), Object: blocked.GetLink()}
+				if err := p.federateFollowChange(block, vocab.UndoType, follow, blocked); err != nil {
+					errs = append(errs, err)
+				}
+			}
+		}
+		return nil
+	})
+
+	if len(severed) > 0 && len(block.GetLink()) > 0 {
+		col := SeveredCollection.IRI(block)
+		if err := p.saveCollectionObjectForParent(block, blankOrderedCollection(col)); err != nil {
+			errs = append(errs, errors.Annotatef(err, "unable to create severed relationships collection %s", col))
+		} else if err = p.s.AddTo(col, severed...); err != nil && !errors.IsConflict(err) {
+			errs = append(errs, errors.Annotatef(err, "unable to add to collection %s", col))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// restoreFollowRelationships restores the follow relationships that the "block" activity has severed, when it gets
+// undone.
+//
+// The relationships between local actors are restored as they were. When the actor of the Block was following
+// a remote actor, a new Follow is sent to it, and the relationship is restored when the remote server accepts it.
+// The remote actors that were following the actor of the Block have received a Reject of their Follow, so they
+// need to follow it again.
+func (p *P) restoreFollowRelationships(block *vocab.Activity) error {
+	blocker := block.Actor
+	if vocab.IsNil(blocker) || len(block.GetLink()) == 0 {
+		return nil
+	}
+	errs := make([]error, 0)
+	addTo := func(colIRI vocab.IRI, it vocab.Item) {
+		if !p.IsLocalIRI(colIRI) {
+			return
+		}
+		if err := p.s.AddTo(colIRI, it.GetLink()); err != nil && !errors.IsConflict(err) {
+			errs = append(errs, errors.Annotatef(err, "unable to add to collection %s", colIRI))
+		}
+	}
+
+	col := SeveredCollection.IRI(block)
+	err := p.walkCollection(col, func(it vocab.Item) error {
+		blocked, path := vocab.Split(it.GetLink())
+		if len(blocked) == 0 {
+			return nil
+		}
+		switch path {
+		case vocab.Following:
+			if !p.IsLocalIRI(blocked) {
+				return nil
+			}
+			addTo(vocab.Following.IRI(blocked), blocker)
+			addTo(vocab.Followers.IRI(blocker), blocked)
+		case vocab.Followers:
+			if p.IsLocal(blocker) && !p.IsLocalIRI(blocked) {
+				follow := &vocab.Activity{Type: vocab.FollowType, Actor: blocker.GetLink(), Object: blocked}
+				if err := p.federateFollowChange(block, vocab.FollowType, follow, blocked); err != nil {
+					errs = append(errs, err)
+				}
+				return nil
+			}
+			addTo(vocab.Followers.IRI(blocked), blocker)
+			addTo(vocab.Following.IRI(blocker), blocked)
+		}
+		return nil
+	})
+	if err != nil && !errors.IsNotFound(err) {
+		errs = append(errs, errors.Annotatef(err, "unable to load severed relationships of %s", block.GetLink()))
+	}
+	if err == nil {
+		if err = p.s.Delete(col); err != nil && !errors.IsNotFound(err) {
+			errs = append(errs, errors.Annotatef(err, "unable to remove collection %s", col))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// federateFollowChange sends to the remote actor "rec" an activity of type "typ", from the actor of the "block"
+// activity, which changes the "follow" relationship between them: a Reject or an Undo of it when the Block severs
+// the relationship, or the Follow itself when the Block gets undone.
+func (p *P) federateFollowChange(block *vocab.Activity, typ vocab.ActivityVocabularyType, follow *vocab.Activity, rec vocab.Item) error {
+	act := follow
+	if !vocab.FollowType.Match(typ) {
+		act = &vocab.Activity{Type: typ, Object: follow}
+	}
+	act.Actor = block.Actor.GetLink()
+	act.To = vocab.ItemCollection{rec.GetLink()}
+	act.Published = time.Now().UTC()
+	if err := SetIDIfMissing(act, block, p.createIDFn); err != nil {
+		return err
+	}
+	if _, err := p.s.Save(act); err != nil {
+		return errors.Annotatef(err, "unable to save %s activity for %s", typ, rec.GetLink())
+	}
+	if err := p.disseminateToRemoteCollections(act, p.inboxForDelivery(rec)); err != nil {
+		return errors.Annotatef(err, "unable to send %s activity to %s", typ, rec.GetLink())
+	}
+	return nil
+}
+
 // FlagActivity
 // There isn't any side effect to this activity except delivering it to the inboxes of its recipients.
 // From the list of recipients we remove the Object itself if it represents an Actor being flagged,
@@ -310,6 +505,11 @@ const IgnoredCollection = vocab.CollectionPath("ignored")
 // IgnoreActivity
 // This relies on custom behavior for the repository, which would allow for an ignored collection,
 // where we save these
+//
+// The objects of the Ignore activities are muted for their actors: the activities which are related to them
+// don't get delivered to the actors' inboxes. See P.BuildInboxRecipientsList.
+// If the Ignore has an end time, we save the activity itself in the ignored collection instead of its object,
+// so the mute can expire.
 func IgnoreActivity(p *P, act *vocab.Activity) (*vocab.Activity, error) {
 	if vocab.IsNil(act.Object) {
 		return act, errors.BadRequestf("Missing object for %s Activity", act.Type)
@@ -328,5 +528,11 @@ func IgnoreActivity(p *P, act *vocab.Activity) (*vocab.Activity, error) {
 	act.Bto.Remove(obIRI)
 	act.BCC.Remove(obIRI)
 
-	return act, p.AddItemToCollection(IgnoredCollection.IRI(act.Actor), obIRI)
+	muted := obIRI
+	if !act.EndTime.IsZero() && len(act.GetLink()) > 0 {
+		muted = act.GetLink()
+	}
+	err := p.AddItemToCollection(IgnoredCollection.IRI(act.Actor), muted)
+	p.mutes.invalidate(act.Actor.GetLink())
+	return act, err
 }
@@ -1,11 +1,251 @@
 package processing
 
-import "testing"
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
 
 func TestReactionsActivity(t *testing.T) {
 	t.Skipf("TODO")
 }
 
+// testAppreciationSetup returns a processor with the liked and disliked collections of the default actor,
+// and the likes and dislikes collections of a local object.
+func testAppreciationSetup(t *testing.T) (*P, *vocab.Object) {
+	p := mockProcessor(t, defaultActorID)
+	ob := &vocab.Object{ID: defaultActorID + "/objects/1", Type: vocab.NoteType, AttributedTo: defaultActorID}
+	if _, err := p.s.Save(ob); err != nil {
+		t.Fatalf("unable to save object: %s", err)
+	}
+	for _, colIRI := range []vocab.IRI{
+		vocab.Outbox.IRI(defaultActor),
+		vocab.Liked.IRI(defaultActor),
+		DislikedCollection.IRI(defaultActor),
+		vocab.Likes.IRI(ob),
+		DislikesCollection.IRI(ob),
+	} {
+		if _, err := p.s.Create(emptyCol(colIRI)); err != nil {
+			t.Fatalf("unable to create collection %s: %s", colIRI, err)
+		}
+	}
+	return p, ob
+}
+
 func TestAppreciationActivity(t *testing.T) {
-	t.Skipf("TODO")
+	tests := []struct {
+		name           string
+		typ            vocab.ActivityVocabularyType
+		wantActorCol   vocab.CollectionPath
+		wantObjectCol  vocab.CollectionPath
+		otherActorCol  vocab.CollectionPath
+		otherObjectCol vocab.CollectionPath
+	}{
+		{
+			name:           "Like",
+			typ:            vocab.LikeType,
+			wantActorCol:   vocab.Liked,
+			wantObjectCol:  vocab.Likes,
+			otherActorCol:  DislikedCollection,
+			otherObjectCol: DislikesCollection,
+		},
+		{
+			name:           "Dislike",
+			typ:            vocab.DislikeType,
+			wantActorCol:   DislikedCollection,
+			wantObjectCol:  DislikesCollection,
+			otherActorCol:  vocab.Liked,
+			otherObjectCol: vocab.Likes,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, ob := testAppreciationSetup(t)
+			act := &vocab.Activity{ID: defaultActorID + "/activities/1", Type: tt.typ, Actor: defaultActor, Object: ob.ID}
+			if _, err := AppreciationActivity(p, act); err != nil {
+				t.Fatalf("AppreciationActivity() error = %s", err)
+			}
+
+			if !testCollectionContains(p, tt.wantActorCol.IRI(defaultActor), ob) {
+				t.Errorf("AppreciationActivity() object is missing from the actor's %s collection", tt.wantActorCol)
+			}
+			if !testCollectionContains(p, tt.wantObjectCol.IRI(ob), act) {
+				t.Errorf("AppreciationActivity() activity is missing from the object's %s collection", tt.wantObjectCol)
+			}
+			if testCollectionContains(p, tt.otherActorCol.IRI(defaultActor), ob) {
+				t.Errorf("AppreciationActivity() object was added to the actor's %s collection", tt.otherActorCol)
+			}
+			if testCollectionContains(p, tt.otherObjectCol.IRI(ob), act) {
+				t.Errorf("AppreciationActivity() activity was added to the object's %s collection", tt.otherObjectCol)
+			}
+		})
+	}
+}
+
+func Test_appreciationCollections(t *testing.T) {
+	tests := []struct {
+		name       string
+		typ        vocab.ActivityVocabularyType
+		wantActor  vocab.CollectionPath
+		wantObject vocab.CollectionPath
+	}{
+		{
+			name:       "Like",
+			typ:        vocab.LikeType,
+			wantActor:  vocab.Liked,
+			wantObject: vocab.Likes,
+		},
+		{
+			name:       "Dislike",
+			typ:        vocab.DislikeType,
+			wantActor:  DislikedCollection,
+			wantObject: DislikesCollection,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotActor, gotObject := appreciationCollections(tt.typ)
+			if gotActor != tt.wantActor {
+				t.Errorf("appreciationCollections() actor collection = %v, want %v", gotActor, tt.wantActor)
+			}
+			if gotObject != tt.wantObject {
+				t.Errorf("appreciationCollections() object collection = %v, want %v", gotObject, tt.wantObject)
+			}
+		})
+	}
+}
+
+func TestBlockActivity(t *testing.T) {
+	var m sync.Mutex
+	delivered := make([]vocab.ActivityVocabularyType, 0)
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		raw, _ := io.ReadAll(r.Body)
+		if it, err := vocab.UnmarshalJSON(raw); err == nil {
+			m.Lock()
+			delivered = append(delivered, it.GetType())
+			m.Unlock()
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	p := mockProcessor(t, defaultActorID)
+
+	blocked := vocab.IRI(srv.URL + "/~blocked")
+	local := defaultActorID.AddPath("~local")
+	if _, err := p.s.Create(emptyCol(BlockedCollection.IRI(defaultActor))); err != nil {
+		t.Fatalf("unable to create blocked collection: %s", err)
+	}
+	for _, col := range []vocab.CollectionPath{vocab.Followers, vocab.Following} {
+		if _, err := p.s.Create(emptyCol(col.IRI(local))); err != nil {
+			t.Fatalf("unable to create %s collection: %s", col, err)
+		}
+		if err := p.s.AddTo(col.IRI(defaultActor), blocked); err != nil {
+			t.Fatalf("unable to add to %s collection: %s", col, err)
+		}
+	}
+	if err := p.s.AddTo(vocab.Followers.IRI(defaultActor), local); err != nil {
+		t.Fatalf("unable to add to followers collection: %s", err)
+	}
+	if err := p.s.AddTo(vocab.Following.IRI(local), defaultActorID); err != nil {
+		t.Fatalf("unable to add to following collection: %s", err)
+	}
+
+	isMember := func(col vocab.IRI, it vocab.IRI) bool {
+		c, err := p.s.Load(col)
+		if err != nil {
+			t.Fatalf("unable to load %s collection: %s", col, err)
+		}
+		member := false
+		_ = vocab.OnCollectionIntf(c, func(c vocab.CollectionInterface) error {
+			member = c.Contains(it)
+			return nil
+		})
+		return member
+	}
+	wantDelivered := func(types ...vocab.ActivityVocabularyType) {
+		t.Helper()
+		m.Lock()
+		defer m.Unlock()
+		if !slices.Equal(delivered, types) {
+			t.Errorf("activities delivered to the blocked actor = %v, want %v", delivered, types)
+		}
+		delivered = delivered[:0]
+	}
+
+	block := &vocab.Activity{
+		ID:     "https://jdoe.example.com/activities/block",
+		Type:   vocab.BlockType,
+		Actor:  defaultActorID,
+		Object: blocked,
+		To:     vocab.ItemCollection{blocked},
+	}
+	act, err := BlockActivity(p, block, vocab.Outbox.IRI(defaultActor))
+	if err != nil {
+		t.Fatalf("BlockActivity() error = %s", err)
+	}
+	if act.To.Contains(blocked) {
+		t.Errorf("BlockActivity() did not remove the blocked actor from the recipients")
+	}
+	if !isMember(BlockedCollection.IRI(defaultActor), blocked) {
+		t.Errorf("BlockActivity() did not add %s to the blocked collection", blocked)
+	}
+	for _, col := range []vocab.CollectionPath{vocab.Followers, vocab.Following} {
+		if isMember(col.IRI(defaultActor), blocked) {
+			t.Errorf("BlockActivity() did not remove %s from the %s collection", blocked, col)
+		}
+	}
+	wantDelivered(vocab.RejectType, vocab.UndoType)
+
+	follow := &vocab.Activity{
+		Type:   vocab.FollowType,
+		Actor:  blocked,
+		Object: defaultActorID,
+	}
+	if _, err = FollowActivity(p, follow, vocab.Outbox.IRI(blocked)); err == nil {
+		t.Errorf("FollowActivity() expected error for the Follow of a blocked actor")
+	}
+
+	if err = p.restoreFollowRelationships(block); err != nil {
+		t.Fatalf("restoreFollowRelationships() error = %s", err)
+	}
+	for _, col := range []vocab.CollectionPath{vocab.Followers, vocab.Following} {
+		if isMember(col.IRI(defaultActor), blocked) {
+			t.Errorf("restoreFollowRelationships() added remote %s to the %s collection without its server's consent", blocked, col)
+		}
+	}
+	wantDelivered(vocab.FollowType)
+	if _, err = p.s.Load(SeveredCollection.IRI(block)); !errors.IsNotFound(err) {
+		t.Errorf("restoreFollowRelationships() did not remove the severed relationships collection")
+	}
+
+	blockLocal := &vocab.Activity{
+		ID:     "https://jdoe.example.com/activities/block-local",
+		Type:   vocab.BlockType,
+		Actor:  defaultActorID,
+		Object: local,
+	}
+	if _, err = BlockActivity(p, blockLocal, vocab.Outbox.IRI(defaultActor)); err != nil {
+		t.Fatalf("BlockActivity() error = %s", err)
+	}
+	if isMember(vocab.Followers.IRI(defaultActor), local) || isMember(vocab.Following.IRI(local), defaultActorID) {
+		t.Errorf("BlockActivity() did not remove the follow relationship of %s", local)
+	}
+	if err = p.restoreFollowRelationships(blockLocal); err != nil {
+		t.Fatalf("restoreFollowRelationships() error = %s", err)
+	}
+	if !isMember(vocab.Followers.IRI(defaultActor), local) || !isMember(vocab.Following.IRI(local), defaultActorID) {
+		t.Errorf("restoreFollowRelationships() did not restore the follow relationship of %s", local)
+	}
+	wantDelivered()
 }
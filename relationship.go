@@ -75,7 +75,13 @@ func RelationshipManagementActivity(p *P, act *vocab.Activity, receivedIn vocab.
 // an actor for perhaps six months while the follower remains unreachable, it is reasonable that the delivering
 // server remove the subscriber from the followers list. Timeframes and behavior for dealing with unreachable
 // actors are left to the discretion of the delivering server.
-func FollowActivity(_ *P, act *vocab.Activity, receivedIn vocab.IRI) (*vocab.Activity, error) {
+//
+// Follow activities of actors that are blocked by the object of the activity get rejected.
+func FollowActivity(p *P, act *vocab.Activity, receivedIn vocab.IRI) (*vocab.Activity, error) {
+	if p != nil && p.followIsBlocked(act) {
+		// NOTE(marius): we don't want to disclose to the blocked actor that they have been blocked
+		return act, errors.NotFoundf("unable to find %s", act.Object.GetLink())
+	}
 	if !vocab.IsNil(act.Object) {
 		validForRecipient := func(i vocab.IRI) bool {
 			return len(i) > 0 && !i.Equal(vocab.PublicNS)
@@ -93,6 +99,18 @@ func FollowActivity(_ *P, act *vocab.Activity, receivedIn vocab.IRI) (*vocab.Act
 	return act, nil
 }
 
+// followIsBlocked checks if the object of the "follow" activity has blocked its actor, or the other way around.
+func (p *P) followIsBlocked(follow *vocab.Activity) bool {
+	blocked := false
+	_ = vocab.OnItem(follow.Object, func(ob vocab.Item) error {
+		if blocked = p.actorHasBlockedFn(ob)(follow.Actor) || p.actorHasBlockedFn(follow.Actor)(ob); blocked {
+			return errors.Newf("blocked")
+		}
+		return nil
+	})
+	return blocked
+}
+
 var UndoableRelationshipActivityTypes = vocab.ActivityVocabularyTypes{
 	vocab.FollowType, vocab.FlagType,
 	vocab.IgnoreType, vocab.BlockType,
@@ -103,7 +121,8 @@ var UndoableRelationshipActivityTypes = vocab.ActivityVocabularyTypes{
 //
 // Removes the side effects of an existing RelationshipActivity activity (Follow, Block, Ignore, Flag)
 // Currently this means the removal of the object from the collection corresponding to the original Activity type.
-// Block - removes the original object from the actor's blocked collection.
+// Block - removes the original object from the actor's blocked collection, and restores the follow relationships
+// the Block has severed.
 // Ignore - removes the original object from the actor's ignored collection.
 // Flag - is a special case where there isn't a specific collection that needs to be operated on.
 // Accept, TentativeAccept - undos the activity it has as an object
@@ -178,14 +197,19 @@ func (p *P) UndoRelationshipManagementActivity(toUndo *vocab.Activity) (*vocab.A
 	case vocab.BlockType.Match(typ):
 		// NOTE(marius): when receiving Undo for Block:
 		//  * we need to remove the Block's Object from the blocked collection of the Undo's Actor.
+		//  * we need to restore the follow relationships which were severed by the Block.
 		if colIRI := BlockedCollection.Of(toUndo.Actor).GetLink(); p.IsLocalIRI(colIRI) && !vocab.IsNil(toUndo.Object) {
 			removeCollectionOperations[colIRI] = vocab.ItemCollection{toUndo.Object}
 		}
+		if err := p.restoreFollowRelationships(toUndo); err != nil {
+			p.l.Warnf("unable to restore the follow relationships severed by %s: %s", toUndo.GetLink(), err)
+		}
 	case vocab.IgnoreType.Match(typ):
 		// NOTE(marius): when receiving Undo for Ignore:
 		//  * we need to remove the Ignore's Object from the ignored collection of the Undo's Actor.
+		//  * we need to remove the Ignore itself, which is saved there instead of its Object when it has an end time.
 		if colIRI := IgnoredCollection.Of(toUndo.Actor).GetLink(); p.IsLocalIRI(colIRI) && !vocab.IsNil(toUndo.Object) {
-			removeCollectionOperations[colIRI] = vocab.ItemCollection{toUndo.Object}
+			removeCollectionOperations[colIRI] = vocab.ItemCollection{toUndo.Object, toUndo.GetLink()}
 		}
 	}
 
@@ -194,6 +218,9 @@ func (p *P) UndoRelationshipManagementActivity(toUndo *vocab.Activity) (*vocab.A
 			errs = append(errs, errors.Annotatef(err, "unable to remove from collection %s", colIRI))
 		}
 	}
+	if vocab.IgnoreType.Match(typ) && !vocab.IsNil(toUndo.Actor) {
+		p.mutes.invalidate(toUndo.Actor.GetLink())
+	}
 	if len(errs) > 0 {
 		return toUndo, errors.Annotatef(errors.Join(errs...), "failed to Undo %s activity", toUndo.GetType())
 	}
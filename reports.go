@@ -0,0 +1,227 @@
+package processing
+
+import (
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/go-ap/filters"
+)
+
+// ReportState is the moderation state of a report, which is a Flag activity received by the server,
+// or created by one of its local actors.
+type ReportState string
+
+const (
+	// ReportOpen is the state of the reports which haven't been handled by a moderator yet.
+	ReportOpen ReportState = "open"
+	// ReportResolved is the state of the reports which have been accepted by a moderator.
+	ReportResolved ReportState = "resolved"
+	// ReportDismissed is the state of the reports which have been rejected by a moderator.
+	ReportDismissed ReportState = "dismissed"
+)
+
+const (
+	// ReportsCollection is the server collection of the open reports.
+	ReportsCollection = vocab.CollectionPath("reports")
+	// ResolvedReportsCollection is the server collection of the reports resolved by the moderators.
+	ResolvedReportsCollection = vocab.CollectionPath("resolved-reports")
+	// DismissedReportsCollection is the server collection of the reports dismissed by the moderators.
+	DismissedReportsCollection = vocab.CollectionPath("dismissed-reports")
+)
+
+var reportStates = []ReportState{ReportOpen, ReportResolved, ReportDismissed}
+
+// Collection returns the server collection that holds the reports in the "s" state.
+func (s ReportState) Collection() vocab.CollectionPath {
+	switch s {
+	case ReportResolved:
+		return ResolvedReportsCollection
+	case ReportDismissed:
+		return DismissedReportsCollection
+	default:
+		return ReportsCollection
+	}
+}
+
+// isModerator checks if "actor" is one of the moderators of the server.
+func (p P) isModerator(actor vocab.Item) bool {
+	return !vocab.IsNil(actor) && p.moderators.Contains(actor.GetLink())
+}
+
+// fileReport adds the "flag" activity, received or created in the "receivedIn" collection, to the open reports
+// collection of the server.
+func (p P) fileReport(flag *vocab.Activity, receivedIn vocab.IRI) error {
+	base := p.localBaseIRI(receivedIn)
+	if base == "" || len(flag.GetLink()) == 0 {
+		return nil
+	}
+	col := ReportsCollection.IRI(base)
+	if err := p.saveCollectionObjectForParent(base, blankOrderedCollection(col)); err != nil {
+		return errors.Annotatef(err, "unable to create reports collection %s", col)
+	}
+	if err := p.s.AddTo(col, flag.GetLink()); err != nil && !errors.IsConflict(err) {
+		return errors.Annotatef(err, "unable to add report %s to %s", flag.GetLink(), col)
+	}
+	return nil
+}
+
+// reportState returns the state of the report "iri", together with the base IRI of the server it belongs to.
+func (p P) reportState(iri vocab.IRI) (ReportState, vocab.IRI, error) {
+	for _, base := range p.baseIRI {
+		for _, state := range reportStates {
+			col, err := p.s.Load(state.Collection().IRI(base), filters.SameID(iri))
+			if err != nil || vocab.IsNil(col) {
+				continue
+			}
+			found := false
+			_ = vocab.OnCollectionIntf(col, func(c vocab.CollectionInterface) error {
+				found = c.Contains(iri)
+				return nil
+			})
+			if found {
+				return state, base, nil
+			}
+		}
+	}
+	return "", "", errors.NotFoundf("%s is not a report", iri)
+}
+
+// ReportState returns the moderation state of the report "iri".
+func (p P) ReportState(iri vocab.IRI) (ReportState, error) {
+	state, _, err := p.reportState(iri)
+	return state, err
+}
+
+// isReportModeration checks if the "act" activity is an Accept or a Reject of a report by a moderator.
+func (p P) isReportModeration(act *vocab.Activity) bool {
+	if !vocab.AcceptType.Match(act.Type) && !vocab.RejectType.Match(act.Type) {
+		return false
+	}
+	if vocab.IsNil(act.Object) || vocab.IsItemCollection(act.Object) || !p.isModerator(act.Actor) {
+		return false
+	}
+	// NOTE(marius): only Flag activities can be reports, so we check the object before looking up its state
+	report, err := p.s.Load(act.Object.GetLink())
+	if err != nil || vocab.IsNil(report) || !vocab.FlagType.Match(firstOrItem(report).GetType()) {
+		return false
+	}
+	_, _, err = p.reportState(act.Object.GetLink())
+	return err == nil
+}
+
+// ModerateReportActivity changes the state of the report which is the object of the "act" activity, created by
+// a moderator: an Accept resolves the report, and a Reject dismisses it.
+// A report that has already been handled can be changed by a new activity from a moderator.
+func ModerateReportActivity(p *P, act *vocab.Activity) (*vocab.Activity, error) {
+	if !p.isModerator(act.Actor) {
+		return act, errors.Forbiddenf("%s is not a moderator", act.Actor.GetLink())
+	}
+	report := act.Object.GetLink()
+	current, base, err := p.reportState(report)
+	if err != nil {
+		return act, err
+	}
+
+	next := ReportResolved
+	if vocab.RejectType.Match(act.Type) {
+		next = ReportDismissed
+	}
+	if current == next {
+		return act, nil
+	}
+
+	col := next.Collection().IRI(base)
+	if err = p.saveCollectionObjectForParent(base, blankOrderedCollection(col)); err != nil {
+		return act, errors.Annotatef(err, "unable to create reports collection %s", col)
+	}
+	if err = p.s.AddTo(col, report); err != nil && !errors.IsConflict(err) {
+		return act, errors.Annotatef(err, "unable to add report %s to %s", report, col)
+	}
+	if err = p.s.RemoveFrom(current.Collection().IRI(base), report); err != nil && !errors.IsNotFound(err) {
+		return act, errors.Annotatef(err, "unable to remove report %s from %s", report, current.Collection().IRI(base))
+	}
+	return act, nil
+}
+
+// ForwardReport sends an anonymised copy of the local report "iri" to the servers of the remote objects
+// and actors it flags. The copies have the instance actor as their actor, so the identity of the reporter
+// is not disclosed, and each server receives only the items which belong to it.
+func (p P) ForwardReport(iri vocab.IRI) error {
+	if len(p.instanceActor) == 0 {
+		return errors.NotImplementedf("unable to forward reports without an instance actor")
+	}
+	it, err := p.s.Load(iri)
+	if err != nil {
+		return errors.Annotatef(err, "unable to load report %s", iri)
+	}
+	it = firstOrItem(it)
+	if !vocab.FlagType.Match(it.GetType()) || !p.IsLocal(it) {
+		return errors.BadRequestf("%s is not a local report", iri)
+	}
+
+	return vocab.OnActivity(it, func(flag *vocab.Activity) error {
+		// NOTE(marius): we group the flagged items, and their owners, by the servers they belong to
+		items := make(map[string]vocab.ItemCollection)
+		owners := make(map[string]vocab.ItemCollection)
+		_ = vocab.OnItem(flag.Object, func(ob vocab.Item) error {
+			host := iriHost(ob.GetLink())
+			if p.IsLocal(ob) || host == "" {
+				return nil
+			}
+			_ = vocab.OnItem(p.loadLocalCopy(ob), func(ob vocab.Item) error {
+				col := items[host]
+				items[host] = append(col, ob.GetLink())
+				for _, owner := range itemOwners(ob) {
+					if iriHost(owner) != host {
+						continue
+					}
+					rec := owners[host]
+					if !rec.Contains(owner) {
+						owners[host] = append(rec, owner)
+					}
+				}
+				return nil
+			})
+			return nil
+		})
+
+		errs := make([]error, 0)
+		for host, flagged := range items {
+			recipients := owners[host]
+			if len(recipients) == 0 {
+				p.l.Warnf("Unable to find the owners of the flagged items on %s", host)
+				continue
+			}
+			fwd := &vocab.Activity{
+				Type:      vocab.FlagType,
+				Actor:     p.instanceActor,
+				Object:    flagged,
+				Content:   flag.Content,
+				To:        recipients,
+				Published: time.Now().UTC(),
+			}
+			if err := SetIDIfMissing(fwd, flag, p.createIDFn); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if _, err := p.s.Save(fwd); err != nil {
+				errs = append(errs, errors.Annotatef(err, "unable to save forwarded report for %s", host))
+				continue
+			}
+			inboxes := make(vocab.IRIs, 0, len(recipients))
+			for _, rec := range recipients {
+				if full, err := p.DereferenceItem(rec); err == nil {
+					rec = full
+				}
+				if inbox := p.inboxForDelivery(rec); !inboxes.Contains(inbox) {
+					_ = inboxes.Append(inbox)
+				}
+			}
+			if err := p.disseminateToRemoteCollections(fwd, inboxes...); err != nil {
+				errs = append(errs, errors.Annotatef(err, "unable to forward report to %s", host))
+			}
+		}
+		return errors.Join(errs...)
+	})
+}
@@ -0,0 +1,107 @@
+package processing
+
+import (
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+func TestModerateReportActivity(t *testing.T) {
+	moderator := defaultActorID.AddPath("moderator")
+	p := mockProcessor(t, defaultActorID)
+	p.moderators = vocab.IRIs{moderator}
+
+	flag := &vocab.Activity{
+		ID:     "https://remote.example.com/activities/flag",
+		Type:   vocab.FlagType,
+		Actor:  vocab.IRI("https://remote.example.com/~jdoe"),
+		Object: defaultActorID,
+	}
+	if _, err := p.s.Save(flag); err != nil {
+		t.Fatalf("unable to save report: %s", err)
+	}
+	if _, err := ReactionsActivity(p, flag, vocab.Inbox.IRI(defaultActor)); err != nil {
+		t.Fatalf("ReactionsActivity() error = %s", err)
+	}
+	if state, err := p.ReportState(flag.ID); err != nil || state != ReportOpen {
+		t.Fatalf("ReportState() = %q, %v, want %q", state, err, ReportOpen)
+	}
+
+	tests := []struct {
+		name  string
+		act   *vocab.Activity
+		want  ReportState
+		moved bool
+	}{
+		{
+			name: "accept from a regular actor is not a moderation",
+			act:  &vocab.Activity{Type: vocab.AcceptType, Actor: defaultActorID, Object: flag.ID},
+			want: ReportOpen,
+		},
+		{
+			name: "accept of an object which is not a report is not a moderation",
+			act:  &vocab.Activity{Type: vocab.AcceptType, Actor: moderator, Object: defaultActorID},
+			want: ReportOpen,
+		},
+		{
+			name: "accept from a moderator resolves the report",
+			act:  &vocab.Activity{Type: vocab.AcceptType, Actor: moderator, Object: flag.ID},
+			want: ReportResolved,
+		},
+		{
+			name: "reject from a moderator dismisses the report",
+			act:  &vocab.Activity{Type: vocab.RejectType, Actor: moderator, Object: flag.ID},
+			want: ReportDismissed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if p.isReportModeration(tt.act) {
+				if _, err := ModerateReportActivity(p, tt.act); err != nil {
+					t.Fatalf("ModerateReportActivity() error = %s", err)
+				}
+			}
+			state, err := p.ReportState(flag.ID)
+			if err != nil {
+				t.Fatalf("ReportState() error = %s", err)
+			}
+			if state != tt.want {
+				t.Errorf("ReportState() = %q, want %q", state, tt.want)
+			}
+			for _, other := range reportStates {
+				if other == state {
+					continue
+				}
+				_ = p.walkCollection(other.Collection().IRI(defaultActorID), func(it vocab.Item) error {
+					if it.GetLink().Equal(flag.ID) {
+						t.Errorf("report is still in the %s collection", other)
+					}
+					return nil
+				})
+			}
+		})
+	}
+}
+
+func TestP_ForwardReport(t *testing.T) {
+	p := mockProcessor(t, defaultActorID)
+
+	if err := p.ForwardReport(defaultActorID.AddPath("flag")); !errors.IsNotImplemented(err) {
+		t.Errorf("ForwardReport() error = %v, expected not implemented without an instance actor", err)
+	}
+
+	p.instanceActor = defaultActorID
+	remote := &vocab.Activity{
+		ID:     "https://remote.example.com/activities/flag",
+		Type:   vocab.FlagType,
+		Actor:  vocab.IRI("https://remote.example.com/~jdoe"),
+		Object: defaultActorID,
+	}
+	if _, err := p.s.Save(remote); err != nil {
+		t.Fatalf("unable to save report: %s", err)
+	}
+	if err := p.ForwardReport(remote.ID); !errors.IsBadRequest(err) {
+		t.Errorf("ForwardReport() error = %v, expected bad request for a remote report", err)
+	}
+}
@@ -1,6 +1,8 @@
 package processing
 
 import (
+	"context"
+
 	"git.sr.ht/~mariusor/lw"
 	vocab "github.com/go-ap/activitypub"
 	"github.com/go-ap/errors"
@@ -52,7 +54,33 @@ func (p P) ProcessServerActivity(it vocab.Item, author vocab.Actor, receivedIn v
 		return nil, errors.Newf("Unable to process nil Activity")
 	}
 
+	// NOTE(marius): the checks which don't need any lookups run first, on the activity as it was received,
+	// so that activities from blocked or rate limited actors, or with excessive payloads, don't trigger any fetches.
+	if err := p.validateInboundActivity(it, author, receivedIn); err != nil {
+		return it, err
+	}
+
 	var err error
+	received := author
+	if it, author, err = p.authenticateActivityActor(it, author); err != nil {
+		return it, err
+	}
+	if !author.ID.Equal(received.ID) {
+		// NOTE(marius): the activity has been fetched from the origin of its actor, which is the new author
+		if p.IsBlockedDomain(author.ID) {
+			return it, errors.Forbiddenf("federation with the domain of %s is not allowed", author.ID)
+		}
+		if err = p.validateActorStatus(&author); err != nil {
+			return it, err
+		}
+		if err = p.validatePayload(it); err != nil {
+			return it, err
+		}
+	}
+	if err = p.replaceForeignEmbeddedItems(it, author); err != nil {
+		return it, err
+	}
+
 	if vocab.IntransitiveActivityTypes.Match(it.GetType()) {
 		err = vocab.OnIntransitiveActivity(it, p.dereferenceIntransitiveActivityProperties(receivedIn))
 	} else {
@@ -61,11 +89,35 @@ func (p P) ProcessServerActivity(it vocab.Item, author vocab.Actor, receivedIn v
 	if err != nil {
 		return it, err
 	}
+	// NOTE(marius): the media is removed after the properties of the activity have been dereferenced, so
+	// the objects which were received as IRIs lose it too.
+	p.rejectMedia(it)
+
+	if err = p.validateServerActivity(it, author, receivedIn); err != nil {
+		return it, err
+	}
 
-	if err := p.ValidateServerActivity(it, author, receivedIn); err != nil {
+	var action MRFAction
+	if it, action, err = p.applyMRFPolicies(it); err != nil {
 		return it, err
 	}
+	if action == MRFAccept && p.quarantineCheck != nil && p.quarantineCheck(it, author) {
+		action = MRFQuarantine
+	}
+	switch action {
+	case MRFReject:
+		return it, errors.Forbiddenf("activity %s has been rejected by the server policies", it.GetLink())
+	case MRFQuarantine:
+		return it, p.quarantineActivity(it, receivedIn)
+	}
 
+	return p.processValidServerActivity(it, receivedIn)
+}
+
+// processValidServerActivity executes the side effects of the "it" activity received in the "receivedIn"
+// collection, which has already been validated, saves it and delivers it to its local recipients.
+func (p P) processValidServerActivity(it vocab.Item, receivedIn vocab.IRI) (vocab.Item, error) {
+	var err error
 	// NOTE(marius): the separation between transitive and intransitive activities overlaps the separation we're
 	// using in the processingClientActivity function between the ActivityStreams motivations separation.
 	// This means that 'it' should probably be treated as a vocab.Item until the last possible moment.
@@ -102,6 +154,177 @@ func (p P) ProcessServerActivity(it vocab.Item, author vocab.Actor, receivedIn v
 	return it, p.ProcessServerInboxDelivery(it, receivedIn, firstDelivery)
 }
 
+// authenticateActivityActor makes sure that the "it" activity, received from the "author" actor, can be trusted to
+// belong to its actor.
+//
+// When the actor of the activity is not the author, which happens for activities forwarded from inboxes or relayed,
+// and the author has not been resolved from a valid integrity proof (see HTTPSignatureVerifierMw), we discard
+// the received copy and we fetch the activity from its origin. The fetched activity is returned together with
+// its actor, which becomes the author.
+func (p P) authenticateActivityActor(it vocab.Item, author vocab.Actor) (vocab.Item, vocab.Actor, error) {
+	if vocab.IsIRI(it) || vocab.PublicNS.Equal(author.ID) {
+		return it, author, nil
+	}
+
+	var actor vocab.Item
+	_ = vocab.OnIntransitiveActivity(it, func(act *vocab.IntransitiveActivity) error {
+		actor = act.Actor
+		return nil
+	})
+	if vocab.IsNil(actor) || actor.GetLink().Equal(author.ID) {
+		return it, author, nil
+	}
+
+	iri := it.GetLink()
+	if len(iri) == 0 || p.IsLocalIRI(iri) {
+		return it, author, errors.Unauthorizedf("activity is not signed by its actor %s", actor.GetLink())
+	}
+	if !sameOrigin(iri, actor.GetLink()) {
+		return it, author, errors.Unauthorizedf("activity %s does not have the same origin as its actor %s", iri, actor.GetLink())
+	}
+	if p.IsBlockedDomain(iri) {
+		return it, author, errors.Forbiddenf("federation with the domain of %s is not allowed", iri)
+	}
+
+	fetched, err := p.c.CtxLoadIRI(context.TODO(), iri)
+	if err != nil {
+		return it, author, errors.NewUnauthorized(err, "unable to fetch activity %s from its origin", iri)
+	}
+	if vocab.IsNil(fetched) || !fetched.GetLink().Equal(iri) || !validActivityTypes.Match(fetched.GetType()) {
+		return it, author, errors.Unauthorizedf("invalid activity fetched from %s", iri)
+	}
+
+	actor = nil
+	_ = vocab.OnIntransitiveActivity(fetched, func(act *vocab.IntransitiveActivity) error {
+		actor = act.Actor
+		return nil
+	})
+	if vocab.IsNil(actor) || !sameOrigin(iri, actor.GetLink()) {
+		return it, author, errors.Unauthorizedf("activity fetched from %s does not have the same origin as its actor", iri)
+	}
+	if actor, err = p.DereferenceItem(actor); err != nil {
+		return it, author, errors.NewUnauthorized(err, "unable to load actor of activity %s", iri)
+	}
+	err = vocab.OnActor(actor, func(a *vocab.Actor) error {
+		author = *a
+		return nil
+	})
+	if err != nil {
+		return it, author, errors.NewUnauthorized(err, "unable to load actor of activity %s", iri)
+	}
+	return fetched, author, nil
+}
+
+// maxEmbeddedItemsDepth is the maximum nesting level up to which the items embedded in an inbound activity
+// get checked against the origin of the server that sent them. The items nested deeper are replaced with their IRIs.
+const maxEmbeddedItemsDepth = 8
+
+// authoritativeCopy returns the representation of the embedded "it" item, which has an ID with a different origin
+// than the server that sent it, which can be trusted.
+//
+// The embedded copy gets discarded, and the item is loaded from storage if it's local or fetched from its origin
+// if it's remote. If that fails, we return only the IRI of the item.
+func (p P) authoritativeCopy(it vocab.Item, origin vocab.IRI) vocab.Item {
+	iri := it.GetLink()
+	if p.IsLocalIRI(iri) {
+		return p.loadLocalCopy(iri)
+	}
+	if p.IsBlockedDomain(iri) {
+		return iri
+	}
+	fetched, err := p.c.CtxLoadIRI(context.TODO(), iri)
+	if err != nil || vocab.IsNil(fetched) || !fetched.GetLink().Equal(iri) {
+		p.l.WithContext(lw.Ctx{"iri": iri, "origin": origin}).Warnf("unable to fetch embedded item from its origin")
+		return iri
+	}
+	return fetched
+}
+
+// replaceForeignEmbeddedItem returns the "it" item, found at "depth" nesting level in an activity received from
+// a server with the "origin" scheme and host, with all the items embedded in it, which have an ID with a different
+// origin, replaced with their authoritative copies.
+//
+// The copies fetched from another origin get checked the same way against their own origin, while the ones loaded
+// from our storage are trusted as they are.
+func (p P) replaceForeignEmbeddedItem(it vocab.Item, origin vocab.IRI, depth int) vocab.Item {
+	if vocab.IsNil(it) || vocab.IsIRI(it) || vocab.IsIRIs(it) {
+		return it
+	}
+	if vocab.IsItemCollection(it) {
+		// NOTE(marius): the items of a property with multiple values are on the same level as a single value would be
+		_ = vocab.OnItemCollection(it, func(col *vocab.ItemCollection) error {
+			for i, el := range *col {
+				(*col)[i] = p.replaceForeignEmbeddedItem(el, origin, depth)
+			}
+			return nil
+		})
+		return it
+	}
+
+	iri := it.GetLink()
+	if depth > maxEmbeddedItemsDepth {
+		if len(iri) == 0 {
+			return nil
+		}
+		return iri
+	}
+	if len(iri) > 0 && !sameOrigin(iri, origin) {
+		if it = p.authoritativeCopy(it, origin); vocab.IsIRI(it) || p.IsLocalIRI(iri) {
+			return it
+		}
+		origin = iri
+	}
+	p.replaceForeignEmbeddedProperties(it, origin, depth)
+	return it
+}
+
+// replaceForeignEmbeddedProperties replaces the items embedded in the properties of the "it" item, found at "depth"
+// nesting level, which have an ID with a different origin than "origin", with their authoritative copies.
+func (p P) replaceForeignEmbeddedProperties(it vocab.Item, origin vocab.IRI, depth int) {
+	if vocab.LinkTypes.Match(it.GetType()) {
+		return
+	}
+	_ = vocab.OnObject(it, func(ob *vocab.Object) error {
+		ob.AttributedTo = p.replaceForeignEmbeddedItem(ob.AttributedTo, origin, depth+1)
+		ob.InReplyTo = p.replaceForeignEmbeddedItem(ob.InReplyTo, origin, depth+1)
+		ob.Context = p.replaceForeignEmbeddedItem(ob.Context, origin, depth+1)
+		ob.Attachment = p.replaceForeignEmbeddedItem(ob.Attachment, origin, depth+1)
+		ob.Tag = p.replaceForeignEmbeddedItem(ob.Tag, origin, depth+1)
+		ob.Generator = p.replaceForeignEmbeddedItem(ob.Generator, origin, depth+1)
+		ob.Location = p.replaceForeignEmbeddedItem(ob.Location, origin, depth+1)
+		ob.Preview = p.replaceForeignEmbeddedItem(ob.Preview, origin, depth+1)
+		return nil
+	})
+	if vocab.IntransitiveActivityTypes.Match(it.GetType()) || vocab.ActivityTypes.Match(it.GetType()) {
+		_ = vocab.OnIntransitiveActivity(it, func(act *vocab.IntransitiveActivity) error {
+			act.Actor = p.replaceForeignEmbeddedItem(act.Actor, origin, depth+1)
+			act.Target = p.replaceForeignEmbeddedItem(act.Target, origin, depth+1)
+			act.Result = p.replaceForeignEmbeddedItem(act.Result, origin, depth+1)
+			act.Origin = p.replaceForeignEmbeddedItem(act.Origin, origin, depth+1)
+			act.Instrument = p.replaceForeignEmbeddedItem(act.Instrument, origin, depth+1)
+			return nil
+		})
+	}
+	if vocab.ActivityTypes.Match(it.GetType()) {
+		_ = vocab.OnActivity(it, func(act *vocab.Activity) error {
+			act.Object = p.replaceForeignEmbeddedItem(act.Object, origin, depth+1)
+			return nil
+		})
+	}
+}
+
+// replaceForeignEmbeddedItems replaces all the items embedded in the "it" activity, at any nesting level, which
+// have an ID with a different origin than the "author", with their authoritative copies.
+// This prevents a malicious server from injecting objects that claim to belong to other servers, eg: an Undo of
+// an Announce of a spoofed Note, or a Note in reply to a spoofed one.
+func (p P) replaceForeignEmbeddedItems(it vocab.Item, author vocab.Actor) error {
+	if vocab.IsNil(it) || vocab.IsIRI(it) || len(author.ID) == 0 || vocab.PublicNS.Equal(author.ID) {
+		return nil
+	}
+	p.replaceForeignEmbeddedProperties(it, author.ID, 1)
+	return nil
+}
+
 // ProcessServerInboxDelivery processes an incoming activity received in an actor's Inbox collection.
 // It propagates the activity to all local actors, and if among them there are collections, they get
 // dereferenced and their members local *and* remote get forwarded a copy of the activity.
@@ -181,9 +404,13 @@ func (p P) ForwardFromInbox(it vocab.Item, remoteRecipients vocab.ItemCollection
 	if !p.ObjectShouldBeInboxForwarded(it, 3) {
 		return nil
 	}
-	return p.disseminateToRemoteCollections(it, remoteRecipients.IRIs()...)
+	return p.disseminateToRemoteCollections(it, p.filterBlockedDomains(remoteRecipients.IRIs())...)
 }
 
+// maxInboxForwardingItems is the maximum number of items of a property with multiple values that
+// ObjectShouldBeInboxForwarded checks for objects owned by the server.
+const maxInboxForwardingItems = 50
+
 // ObjectShouldBeInboxForwarded checks if the last remaining rules for forwarding from an inbox are fulfilled.
 //
 // * The values of inReplyTo, object, target and/or tag are objects owned by the server.
@@ -194,6 +421,9 @@ func (p P) ForwardFromInbox(it vocab.Item, remoteRecipients vocab.ItemCollection
 // recursing through the linked objects (in case these addressees were purposefully amended by or via the client).
 //
 // The server MAY filter its delivery targets according to implementation-specific rules (for example, spam filtering).
+//
+// Besides the "maxDepth" limit for recursion, only the first maxInboxForwardingItems items of the properties with
+// multiple values are checked.
 func (p P) ObjectShouldBeInboxForwarded(it vocab.Item, maxDepth int) bool {
 	if vocab.IsNil(it) {
 		return false
@@ -210,7 +440,10 @@ func (p P) ObjectShouldBeInboxForwarded(it vocab.Item, maxDepth int) bool {
 		return p.IsLocal(it)
 	case vocab.IsIRIs(it):
 		_ = vocab.OnIRIs(it, func(is *vocab.IRIs) error {
-			for _, iri := range *is {
+			for i, iri := range *is {
+				if i >= maxInboxForwardingItems {
+					break
+				}
 				if shouldForward = p.IsLocalIRI(iri); shouldForward {
 					break
 				}
@@ -219,7 +452,10 @@ func (p P) ObjectShouldBeInboxForwarded(it vocab.Item, maxDepth int) bool {
 		})
 	case vocab.IsItemCollection(it):
 		_ = vocab.OnCollectionIntf(it, func(col vocab.CollectionInterface) error {
-			for _, ob := range col.Collection() {
+			for i, ob := range col.Collection() {
+				if i >= maxInboxForwardingItems {
+					break
+				}
 				if shouldForward = p.ObjectShouldBeInboxForwarded(ob, maxDepth-1); shouldForward {
 					break
 				}
@@ -315,7 +551,7 @@ func (p *P) processServerActivity(act *vocab.Activity, receivedIn vocab.IRI) (vo
 	case vocab.CreateType.Match(typ):
 		act, err = CreateActivityFromServer(p, act)
 	case vocab.DeleteType.Match(typ):
-		act, err = DeleteActivity(p.s, act)
+		act, err = p.deleteActivityWithCascade(act)
 	case vocab.ReactionsActivityTypes.Match(typ):
 		act, err = ReactionsActivity(p, act, receivedIn)
 	}
@@ -323,7 +559,7 @@ func (p *P) processServerActivity(act *vocab.Activity, receivedIn vocab.IRI) (vo
 }
 
 // BuildInboxRecipientsList builds the recipients list of the received 'it' Activity is addressed to:
-//   - the *local* recipients' Inboxes
+//   - the *local* recipients' Inboxes, except the ones of the actors that have muted it
 func (p P) BuildInboxRecipientsList(it vocab.Item, receivedIn vocab.IRI) vocab.ItemCollection {
 	act, err := vocab.ToActivity(it)
 	if err != nil {
@@ -378,7 +614,10 @@ func (p P) BuildInboxRecipientsList(it vocab.Item, receivedIn vocab.IRI) vocab.I
 		_ = allRecipients.Append(receivedIn)
 	}
 
-	return vocab.ItemCollectionDeduplication(&allRecipients)
+	recipients := p.restrictSilencedRecipients(act.Actor, vocab.ItemCollectionDeduplication(&allRecipients))
+	// NOTE(marius): the activities which are related to items muted by the local actors don't get delivered
+	// to their inboxes. They still have their side effects executed.
+	return p.removeMutingRecipients(act, recipients)
 }
 
 // BuildLocalCollectionsRecipients builds the recipients list of the received 'it' Activity is addressed to:
@@ -444,5 +683,5 @@ func (p P) BuildLocalCollectionsRecipients(it vocab.Item, receivedIn vocab.IRI)
 		})
 	}
 
-	return vocab.ItemCollectionDeduplication(&allRecipients)
+	return p.restrictSilencedRecipients(act.Actor, vocab.ItemCollectionDeduplication(&allRecipients))
 }
@@ -0,0 +1,258 @@
+package processing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestP_replaceForeignEmbeddedItems(t *testing.T) {
+	base := vocab.IRI("https://example.com")
+	p := mockProcessor(t, base)
+
+	local := &vocab.Object{ID: base + "/objects/1", Type: vocab.NoteType, Content: vocab.DefaultNaturalLanguage("original")}
+	if _, err := p.s.Save(local); err != nil {
+		t.Fatalf("unable to save object: %s", err)
+	}
+
+	author := vocab.Actor{ID: "https://remote.example.com/~jdoe", Type: vocab.PersonType}
+	sameOrigin := &vocab.Object{ID: "https://remote.example.com/objects/1", Type: vocab.NoteType}
+
+	tests := []struct {
+		name   string
+		object vocab.Item
+		want   vocab.Item
+	}{
+		{
+			name:   "same origin object is kept",
+			object: sameOrigin,
+			want:   sameOrigin,
+		},
+		{
+			name:   "IRI is kept",
+			object: base + "/objects/1",
+			want:   base + "/objects/1",
+		},
+		{
+			name:   "spoofed local object is replaced with the stored copy",
+			object: &vocab.Object{ID: base + "/objects/1", Type: vocab.NoteType, Content: vocab.DefaultNaturalLanguage("spoofed")},
+			want:   local,
+		},
+		{
+			name: "spoofed local object in collection",
+			object: vocab.ItemCollection{
+				sameOrigin,
+				&vocab.Object{ID: base + "/objects/1", Type: vocab.NoteType, Content: vocab.DefaultNaturalLanguage("spoofed")},
+			},
+			want: vocab.ItemCollection{sameOrigin, local},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			act := &vocab.Activity{
+				ID:     "https://remote.example.com/activities/1",
+				Type:   vocab.UpdateType,
+				Actor:  author.ID,
+				Object: tt.object,
+			}
+			if err := p.replaceForeignEmbeddedItems(act, author); err != nil {
+				t.Fatalf("replaceForeignEmbeddedItems() error = %s", err)
+			}
+			if !cmp.Equal(act.Object, tt.want) {
+				t.Errorf("replaceForeignEmbeddedItems() object = %s", cmp.Diff(tt.want, act.Object))
+			}
+		})
+	}
+}
+
+func TestP_replaceForeignEmbeddedItems_nested(t *testing.T) {
+	base := vocab.IRI("https://example.com")
+	p := mockProcessor(t, base)
+
+	local := &vocab.Object{ID: base + "/objects/1", Type: vocab.NoteType, Content: vocab.DefaultNaturalLanguage("original")}
+	if _, err := p.s.Save(local); err != nil {
+		t.Fatalf("unable to save object: %s", err)
+	}
+	spoofed := func() *vocab.Object {
+		return &vocab.Object{ID: base + "/objects/1", Type: vocab.NoteType, Content: vocab.DefaultNaturalLanguage("spoofed")}
+	}
+	author := vocab.Actor{ID: "https://remote.example.com/~jdoe", Type: vocab.PersonType}
+
+	t.Run("undo of announce", func(t *testing.T) {
+		announce := &vocab.Activity{ID: "https://remote.example.com/activities/1", Type: vocab.AnnounceType, Actor: author.ID, Object: spoofed()}
+		undo := &vocab.Activity{ID: "https://remote.example.com/activities/2", Type: vocab.UndoType, Actor: author.ID, Object: announce}
+		if err := p.replaceForeignEmbeddedItems(undo, author); err != nil {
+			t.Fatalf("replaceForeignEmbeddedItems() error = %s", err)
+		}
+		if !cmp.Equal(announce.Object, vocab.Item(local)) {
+			t.Errorf("replaceForeignEmbeddedItems() announced object = %s", cmp.Diff(vocab.Item(local), announce.Object))
+		}
+	})
+	t.Run("in reply to", func(t *testing.T) {
+		reply := &vocab.Object{ID: "https://remote.example.com/objects/2", Type: vocab.NoteType, InReplyTo: spoofed()}
+		create := mrfCreate(reply)
+		if err := p.replaceForeignEmbeddedItems(create, author); err != nil {
+			t.Fatalf("replaceForeignEmbeddedItems() error = %s", err)
+		}
+		if !cmp.Equal(reply.InReplyTo, vocab.Item(local)) {
+			t.Errorf("replaceForeignEmbeddedItems() in reply to = %s", cmp.Diff(vocab.Item(local), reply.InReplyTo))
+		}
+	})
+	t.Run("maximum depth", func(t *testing.T) {
+		var ob vocab.Item = &vocab.Object{ID: "https://remote.example.com/objects/0", Type: vocab.NoteType}
+		// NOTE(marius): the activity is at depth 1, so the innermost object ends up right past the maximum depth
+		for i := 0; i < maxEmbeddedItemsDepth-1; i++ {
+			ob = &vocab.Object{Type: vocab.NoteType, InReplyTo: ob}
+		}
+		create := mrfCreate(ob)
+		if err := p.replaceForeignEmbeddedItems(create, author); err != nil {
+			t.Fatalf("replaceForeignEmbeddedItems() error = %s", err)
+		}
+		deepest := create.Object
+		for i := 0; i < maxEmbeddedItemsDepth-1; i++ {
+			_ = vocab.OnObject(deepest, func(o *vocab.Object) error {
+				deepest = o.InReplyTo
+				return nil
+			})
+		}
+		if !vocab.IsIRI(deepest) || !deepest.GetLink().Equal("https://remote.example.com/objects/0") {
+			t.Errorf("replaceForeignEmbeddedItems() kept %v, expected the item past the maximum depth to be replaced with its IRI", deepest)
+		}
+	})
+}
+
+func TestP_ProcessServerActivity_validatesBeforeFetching(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	remote := vocab.IRI(srv.URL)
+	author := vocab.Actor{ID: "https://remote.example.com/~jdoe", Type: vocab.PersonType}
+	deep := &vocab.Object{ID: remote + "/objects/1", Type: vocab.NoteType, InReplyTo: &vocab.Object{Type: vocab.NoteType}}
+
+	tests := []struct {
+		name string
+		it   vocab.Item
+		rate bool
+	}{
+		{
+			name: "rate limited author",
+			it:   &vocab.Activity{ID: author.ID + "/activities/1", Type: vocab.LikeType, Actor: author.ID, Object: remote + "/objects/1"},
+			rate: true,
+		},
+		{
+			name: "foreign embedded object exceeding depth",
+			it:   &vocab.Activity{ID: author.ID + "/activities/1", Type: vocab.CreateType, Actor: author.ID, Object: deep},
+		},
+		{
+			name: "forwarded activity exceeding depth",
+			it:   &vocab.Activity{ID: remote + "/activities/1", Type: vocab.CreateType, Actor: remote + "/~alice", Object: deep},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests.Store(0)
+			p := mockProcessor(t, defaultActorID)
+			p.payloadLimits = PayloadLimits{MaxDepth: 2}
+			if tt.rate {
+				p.rateLimiter = NewRateLimiter(RateLimits{"": {Burst: 1, Interval: time.Hour}}, nil)
+				_ = p.validateRateLimit(author.ID, vocab.LikeType)
+			}
+			if _, err := p.ProcessServerActivity(tt.it, author, vocab.Inbox.IRI(defaultActor)); err == nil {
+				t.Errorf("ProcessServerActivity() expected error")
+			}
+			if got := requests.Load(); got > 0 {
+				t.Errorf("ProcessServerActivity() made %d requests before rejecting the activity", got)
+			}
+		})
+	}
+}
+
+func TestP_ProcessServerActivity_rejectsMediaOfDereferencedObjects(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/objects/1" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/activity+json")
+		_, _ = w.Write([]byte(`{"id":"` + srv.URL + `/objects/1","type":"Note","content":"Hello",` +
+			`"attachment":{"type":"Image","url":"` + srv.URL + `/1.png"},"icon":{"type":"Image","url":"` + srv.URL + `/icon.png"}}`))
+	}))
+	defer srv.Close()
+
+	remote := vocab.IRI(srv.URL)
+	p := mockProcessor(t, defaultActorID)
+	p.federation = FederationPolicy{Domains: map[string]DomainAction{iriHost(remote): RejectMedia}}
+
+	author := vocab.Actor{ID: remote + "/~jdoe", Type: vocab.PersonType}
+	act := &vocab.Activity{
+		ID:     remote + "/activities/1",
+		Type:   vocab.AnnounceType,
+		Actor:  author.ID,
+		To:     vocab.ItemCollection{vocab.PublicNS},
+		Object: remote + "/objects/1",
+	}
+	_, _ = p.ProcessServerActivity(act, author, vocab.Inbox.IRI(defaultActor))
+
+	if vocab.IsIRI(act.Object) {
+		t.Fatalf("ProcessServerActivity() didn't dereference the object %s", act.Object.GetLink())
+	}
+	_ = vocab.OnObject(act.Object, func(ob *vocab.Object) error {
+		if ob.Attachment != nil || ob.Icon != nil {
+			t.Errorf("ProcessServerActivity() kept the media of %s, which was received as an IRI", ob.ID)
+		}
+		return nil
+	})
+}
+
+func TestP_ObjectShouldBeInboxForwarded(t *testing.T) {
+	p := mockProcessor(t, defaultActorID)
+	local := vocab.IRI(defaultActorID + "/objects/1")
+
+	remoteTags := func(count int) vocab.ItemCollection {
+		tags := make(vocab.ItemCollection, 0, count)
+		for range count {
+			tags = append(tags, &vocab.Object{ID: "https://remote.example.com/tags/1", Type: vocab.ObjectType})
+		}
+		return tags
+	}
+	tests := []struct {
+		name string
+		it   vocab.Item
+		want bool
+	}{
+		{name: "nil", it: nil},
+		{name: "remote object", it: &vocab.Object{ID: "https://remote.example.com/objects/1", Type: vocab.NoteType}},
+		{name: "reply to local object", it: &vocab.Object{Type: vocab.NoteType, InReplyTo: local}, want: true},
+		{
+			name: "create of reply to local object",
+			it:   &vocab.Activity{Type: vocab.CreateType, Object: &vocab.Object{Type: vocab.NoteType, InReplyTo: local}},
+			want: true,
+		},
+		{
+			name: "reply beyond max depth",
+			it: &vocab.Activity{Type: vocab.CreateType, Object: &vocab.Object{
+				Type:      vocab.NoteType,
+				InReplyTo: &vocab.Object{Type: vocab.NoteType, InReplyTo: local},
+			}},
+		},
+		{name: "local tag", it: &vocab.Object{Type: vocab.NoteType, Tag: append(remoteTags(2), local)}, want: true},
+		{name: "local tag beyond max items", it: &vocab.Object{Type: vocab.NoteType, Tag: append(remoteTags(maxInboxForwardingItems), local)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := p.ObjectShouldBeInboxForwarded(tt.it, 3); got != tt.want {
+				t.Errorf("ObjectShouldBeInboxForwarded() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,809 @@
+package processing
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"hash"
+	"io"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+// DefaultMaxClockSkew is the maximum difference we accept between the moment a request was signed
+// and the current time.
+const DefaultMaxClockSkew = 5 * time.Minute
+
+// DefaultMaxBodySize is the maximum size in bytes of the request bodies we read when verifying HTTP signatures.
+const DefaultMaxBodySize int64 = 10 << 20
+
+// httpSignature represents the information we parse from either a draft-cavage "Signature" header, or
+// from the RFC9421 "Signature-Input" and "Signature" headers.
+//
+// https://datatracker.ietf.org/doc/html/draft-cavage-http-signatures-12
+// https://www.rfc-editor.org/rfc/rfc9421.html
+type httpSignature struct {
+	keyID      vocab.IRI
+	alg        string
+	components []string
+	base       string
+	signature  []byte
+	created    time.Time
+	expires    time.Time
+	rfc9421    bool
+}
+
+func (s httpSignature) covers(component string) bool {
+	for _, c := range s.components {
+		if strings.EqualFold(c, component) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitOutsideQuotes splits "s" on the "sep" separator, ignoring the separators found inside
+// quoted strings or inside parentheses.
+func splitOutsideQuotes(s string, sep rune) []string {
+	parts := make([]string, 0)
+	quoted := false
+	depth := 0
+	start := 0
+	for i, c := range s {
+		switch {
+		case c == '"' && (i == 0 || s[i-1] != '\\'):
+			quoted = !quoted
+		case c == '(' && !quoted:
+			depth++
+		case c == ')' && !quoted:
+			depth--
+		case c == sep && !quoted && depth == 0:
+			parts = append(parts, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	if last := strings.TrimSpace(s[start:]); len(last) > 0 {
+		parts = append(parts, last)
+	}
+	return parts
+}
+
+func unixTime(s string) (time.Time, error) {
+	sec, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(sec, 0).UTC(), nil
+}
+
+func requestHost(r *http.Request) string {
+	if len(r.Host) > 0 {
+		return r.Host
+	}
+	if r.URL != nil {
+		return r.URL.Host
+	}
+	return ""
+}
+
+func requestScheme(r *http.Request) string {
+	if r.URL != nil && len(r.URL.Scheme) > 0 {
+		return r.URL.Scheme
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+func headerValue(r *http.Request, name string) (string, error) {
+	if strings.EqualFold(name, "host") {
+		return requestHost(r), nil
+	}
+	values := r.Header.Values(name)
+	if len(values) == 0 {
+		return "", errors.Newf("missing signed header %q", name)
+	}
+	for i, v := range values {
+		values[i] = strings.TrimSpace(v)
+	}
+	return strings.Join(values, ", "), nil
+}
+
+// cavageSigningString builds the string that gets signed for a draft-cavage HTTP signature.
+func cavageSigningString(r *http.Request, headers []string, created, expires time.Time) (string, error) {
+	lines := make([]string, 0, len(headers))
+	for _, h := range headers {
+		h = strings.ToLower(h)
+		var v string
+		switch h {
+		case "(request-target)":
+			v = strings.ToLower(r.Method) + " " + r.URL.RequestURI()
+		case "(created)":
+			if created.IsZero() {
+				return "", errors.Newf("missing created signature parameter")
+			}
+			v = strconv.FormatInt(created.Unix(), 10)
+		case "(expires)":
+			if expires.IsZero() {
+				return "", errors.Newf("missing expires signature parameter")
+			}
+			v = strconv.FormatInt(expires.Unix(), 10)
+		default:
+			var err error
+			if v, err = headerValue(r, h); err != nil {
+				return "", err
+			}
+		}
+		lines = append(lines, h+": "+v)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// parseCavageSignature parses the value of a draft-cavage "Signature" header, or of an "Authorization" header
+// with the "Signature" scheme, and builds the corresponding signing string for "r".
+func parseCavageSignature(r *http.Request, header string) (*httpSignature, error) {
+	sig := httpSignature{}
+	for _, param := range splitOutsideQuotes(header, ',') {
+		k, v, ok := strings.Cut(param, "=")
+		if !ok {
+			continue
+		}
+		v = strings.Trim(strings.TrimSpace(v), `"`)
+		var err error
+		switch strings.TrimSpace(k) {
+		case "keyId":
+			sig.keyID = vocab.IRI(v)
+		case "algorithm":
+			sig.alg = strings.ToLower(v)
+		case "headers":
+			sig.components = strings.Fields(strings.ToLower(v))
+		case "signature":
+			sig.signature, err = base64.StdEncoding.DecodeString(v)
+		case "created":
+			sig.created, err = unixTime(v)
+		case "expires":
+			sig.expires, err = unixTime(v)
+		}
+		if err != nil {
+			return nil, errors.Annotatef(err, "invalid %s signature parameter", k)
+		}
+	}
+	if len(sig.keyID) == 0 {
+		return nil, errors.Newf("missing keyId signature parameter")
+	}
+	if len(sig.signature) == 0 {
+		return nil, errors.Newf("missing signature parameter")
+	}
+	if len(sig.components) == 0 {
+		// NOTE(marius): the default value of the headers parameter, when it's missing
+		sig.components = []string{"date"}
+	}
+
+	var err error
+	if sig.base, err = cavageSigningString(r, sig.components, sig.created, sig.expires); err != nil {
+		return nil, err
+	}
+	return &sig, nil
+}
+
+// rfc9421ComponentValue returns the value of the "name" component of the "r" request.
+func rfc9421ComponentValue(r *http.Request, name string) (string, error) {
+	switch name {
+	case "@method":
+		return r.Method, nil
+	case "@target-uri":
+		return requestScheme(r) + "://" + requestHost(r) + r.URL.RequestURI(), nil
+	case "@authority":
+		return strings.ToLower(requestHost(r)), nil
+	case "@scheme":
+		return requestScheme(r), nil
+	case "@request-target":
+		return r.URL.RequestURI(), nil
+	case "@path":
+		if p := r.URL.EscapedPath(); len(p) > 0 {
+			return p, nil
+		}
+		return "/", nil
+	case "@query":
+		return "?" + r.URL.RawQuery, nil
+	}
+	if strings.HasPrefix(name, "@") {
+		return "", errors.Newf("unsupported derived component %q", name)
+	}
+	return headerValue(r, name)
+}
+
+// rfc9421SignatureBase builds the signature base for the "components" of "r", where "params" is the
+// serialized value of the signature parameters.
+func rfc9421SignatureBase(r *http.Request, components []string, params string) (string, error) {
+	b := strings.Builder{}
+	for _, c := range components {
+		v, err := rfc9421ComponentValue(r, c)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(strconv.Quote(c) + ": " + v + "\n")
+	}
+	b.WriteString(`"@signature-params": ` + params)
+	return b.String(), nil
+}
+
+// rfc9421SignatureParams serializes the signature parameters for the "components" list.
+func rfc9421SignatureParams(components []string, keyID vocab.IRI, alg string, created time.Time) string {
+	quoted := make([]string, len(components))
+	for i, c := range components {
+		quoted[i] = strconv.Quote(c)
+	}
+	params := "(" + strings.Join(quoted, " ") + ");created=" + strconv.FormatInt(created.Unix(), 10)
+	params += ";keyid=" + strconv.Quote(keyID.String())
+	if len(alg) > 0 {
+		params += ";alg=" + strconv.Quote(alg)
+	}
+	return params
+}
+
+// parseRFC9421Signature parses the "Signature-Input" and "Signature" headers of "r" and builds
+// the signature base for the first signature we can find in both.
+func parseRFC9421Signature(r *http.Request) (*httpSignature, error) {
+	signatures := make(map[string][]byte)
+	for _, member := range splitOutsideQuotes(strings.Join(r.Header.Values("Signature"), ","), ',') {
+		label, v, ok := strings.Cut(member, "=")
+		if !ok {
+			continue
+		}
+		v = strings.Trim(strings.TrimSpace(v), ":")
+		raw, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return nil, errors.Annotatef(err, "invalid signature %s", label)
+		}
+		signatures[strings.TrimSpace(label)] = raw
+	}
+
+	for _, member := range splitOutsideQuotes(strings.Join(r.Header.Values("Signature-Input"), ","), ',') {
+		label, params, ok := strings.Cut(member, "=")
+		if !ok {
+			continue
+		}
+		label = strings.TrimSpace(label)
+		raw, ok := signatures[label]
+		if !ok {
+			continue
+		}
+
+		sig := httpSignature{signature: raw, rfc9421: true}
+		params = strings.TrimSpace(params)
+		end := strings.Index(params, ")")
+		if !strings.HasPrefix(params, "(") || end < 0 {
+			return nil, errors.Newf("invalid signature input %s", label)
+		}
+		for _, c := range strings.Fields(params[1:end]) {
+			if !strings.HasPrefix(c, `"`) || !strings.HasSuffix(c, `"`) {
+				return nil, errors.Newf("unsupported component %s in signature input %s", c, label)
+			}
+			sig.components = append(sig.components, strings.ToLower(strings.Trim(c, `"`)))
+		}
+		for _, param := range splitOutsideQuotes(params[end+1:], ';') {
+			k, v, ok := strings.Cut(param, "=")
+			if !ok {
+				continue
+			}
+			v = strings.Trim(v, `"`)
+			var err error
+			switch k {
+			case "keyid":
+				sig.keyID = vocab.IRI(v)
+			case "alg":
+				sig.alg = strings.ToLower(v)
+			case "created":
+				sig.created, err = unixTime(v)
+			case "expires":
+				sig.expires, err = unixTime(v)
+			}
+			if err != nil {
+				return nil, errors.Annotatef(err, "invalid %s signature parameter", k)
+			}
+		}
+		if len(sig.keyID) == 0 {
+			return nil, errors.Newf("missing keyid signature parameter")
+		}
+
+		var err error
+		if sig.base, err = rfc9421SignatureBase(r, sig.components, params); err != nil {
+			return nil, err
+		}
+		return &sig, nil
+	}
+	return nil, errors.Newf("no matching signature found for the signature input")
+}
+
+// parseRequestSignature returns the HTTP signature of "r", if it has one.
+func parseRequestSignature(r *http.Request) (*httpSignature, error) {
+	if len(r.Header.Get("Signature-Input")) > 0 {
+		return parseRFC9421Signature(r)
+	}
+	if sig := r.Header.Get("Signature"); len(sig) > 0 {
+		return parseCavageSignature(r, sig)
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Signature ") {
+		return parseCavageSignature(r, strings.TrimPrefix(auth, "Signature "))
+	}
+	return nil, nil
+}
+
+func digestHash(alg string) hash.Hash {
+	switch strings.ToLower(alg) {
+	case "sha-256":
+		return sha256.New()
+	case "sha-512":
+		return sha512.New()
+	}
+	return nil
+}
+
+// verifyBodyDigest checks the "Digest" and "Content-Digest" headers against the body of the request.
+// It returns false if the request has none of the headers.
+//
+// https://datatracker.ietf.org/doc/html/rfc3230
+// https://www.rfc-editor.org/rfc/rfc9530.html
+func verifyBodyDigest(h http.Header, body []byte) (bool, error) {
+	type digest struct {
+		alg   string
+		value string
+	}
+	digests := make([]digest, 0)
+	for _, d := range splitOutsideQuotes(h.Get("Digest"), ',') {
+		if alg, v, ok := strings.Cut(d, "="); ok {
+			digests = append(digests, digest{alg: alg, value: v})
+		}
+	}
+	for _, d := range splitOutsideQuotes(h.Get("Content-Digest"), ',') {
+		if alg, v, ok := strings.Cut(d, "="); ok {
+			digests = append(digests, digest{alg: alg, value: strings.Trim(v, ":")})
+		}
+	}
+	if len(digests) == 0 {
+		return false, nil
+	}
+
+	verified := false
+	for _, d := range digests {
+		hh := digestHash(d.alg)
+		if hh == nil {
+			continue
+		}
+		expected, err := base64.StdEncoding.DecodeString(d.value)
+		if err != nil {
+			return true, errors.Annotatef(err, "invalid %s digest", d.alg)
+		}
+		hh.Write(body)
+		if subtle.ConstantTimeCompare(hh.Sum(nil), expected) != 1 {
+			return true, errors.Newf("%s digest does not match the body", d.alg)
+		}
+		verified = true
+	}
+	if !verified {
+		return true, errors.Newf("no supported digest algorithm")
+	}
+	return true, nil
+}
+
+// parsePublicKeyPEM decodes the PEM encoded public key of an actor.
+func parsePublicKeyPEM(pemStr string) (crypto.PublicKey, error) {
+	block, _ := pem.Decode([]byte(pemStr))
+	if block == nil {
+		return nil, errors.Newf("invalid PEM public key")
+	}
+	if pub, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+		return pub, nil
+	}
+	pub, err := x509.ParsePKCS1PublicKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Annotatef(err, "unable to parse public key")
+	}
+	return pub, nil
+}
+
+func hashSum(h crypto.Hash, data []byte) []byte {
+	hh := h.New()
+	hh.Write(data)
+	return hh.Sum(nil)
+}
+
+// verifySignature verifies the "sig" signature of "data" with the "pub" key, for the "alg" algorithm.
+// When the algorithm is not specified, or when it is "hs2019", we infer it from the type of the key.
+func verifySignature(pub crypto.PublicKey, alg string, data, sig []byte) error {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		switch alg {
+		case "rsa-pss-sha512":
+			return rsa.VerifyPSS(k, crypto.SHA512, hashSum(crypto.SHA512, data), sig, &rsa.PSSOptions{SaltLength: 64})
+		case "rsa-sha512":
+			return rsa.VerifyPKCS1v15(k, crypto.SHA512, hashSum(crypto.SHA512, data), sig)
+		case "", "hs2019", "rsa-sha256", "rsa-v1_5-sha256":
+			err := rsa.VerifyPKCS1v15(k, crypto.SHA256, hashSum(crypto.SHA256, data), sig)
+			if err != nil && alg == "hs2019" {
+				err = rsa.VerifyPSS(k, crypto.SHA512, hashSum(crypto.SHA512, data), sig, nil)
+			}
+			return err
+		}
+	case *ecdsa.PublicKey:
+		// NOTE(marius): the hash function is determined by the curve of the key, and the algorithm, when it's
+		// specified, needs to match it.
+		keyAlg, h, err := ecdsaAlgorithm(k.Curve)
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(alg, "ecdsa-") && alg != keyAlg {
+			return errors.Newf("signature algorithm %q does not match the %s key", alg, k.Curve.Params().Name)
+		}
+		digest := hashSum(h, data)
+		if ecdsa.VerifyASN1(k, digest, sig) {
+			return nil
+		}
+		// NOTE(marius): RFC9421 uses the raw concatenation of the r and s values
+		if size := (k.Curve.Params().BitSize + 7) / 8; len(sig) == 2*size {
+			r := new(big.Int).SetBytes(sig[:size])
+			s := new(big.Int).SetBytes(sig[size:])
+			if ecdsa.Verify(k, digest, r, s) {
+				return nil
+			}
+		}
+		return errors.Newf("invalid ECDSA signature")
+	case ed25519.PublicKey:
+		if ed25519.Verify(k, data, sig) {
+			return nil
+		}
+		return errors.Newf("invalid Ed25519 signature")
+	}
+	return errors.Newf("unsupported signature algorithm %q for key %T", alg, pub)
+}
+
+// validateSignatureTime checks that the moment the request was signed is within the "skew" limit from the
+// current time, and that the signature has not expired.
+func validateSignatureTime(r *http.Request, sig *httpSignature, skew time.Duration) error {
+	now := time.Now().UTC()
+	inSkew := func(t time.Time) bool {
+		return t.After(now.Add(-skew)) && t.Before(now.Add(skew))
+	}
+
+	if !sig.expires.IsZero() && now.After(sig.expires) {
+		return errors.Newf("signature has expired")
+	}
+	if !sig.created.IsZero() && !inSkew(sig.created) {
+		return errors.Newf("signature creation time is outside the accepted interval")
+	}
+	date := r.Header.Get("Date")
+	if len(date) == 0 {
+		if sig.created.IsZero() {
+			return errors.Newf("unable to determine the moment the request was signed")
+		}
+		return nil
+	}
+	t, err := http.ParseTime(date)
+	if err != nil {
+		return errors.Annotatef(err, "invalid Date header")
+	}
+	if !inSkew(t) {
+		return errors.Newf("Date header is outside the accepted interval")
+	}
+	if !sig.covers("date") && sig.created.IsZero() {
+		return errors.Newf("the Date header is not covered by the signature")
+	}
+	return nil
+}
+
+// maxRequestBodySize returns the maximum size of the request bodies that the processor reads.
+func (p *P) maxRequestBodySize() int64 {
+	if p.maxBodySize <= 0 {
+		return DefaultMaxBodySize
+	}
+	return p.maxBodySize
+}
+
+// VerifyHTTPSignature verifies the draft-cavage or RFC9421 HTTP signature of the "r" request, and returns
+// the actor that owns the key which signed it.
+// If the request is not signed, it returns a nil actor and no error.
+//
+// The body of the request needs to have a valid Digest or Content-Digest header, which is covered by the
+// signature, and the moment of the signing needs to be within the processor's accepted clock skew.
+// The key owner is loaded from storage, or fetched and cached if it is missing. If the verification fails
+// for a cached remote actor, we fetch it again, in case its key has been rotated.
+func (p *P) VerifyHTTPSignature(r *http.Request) (*vocab.Actor, error) {
+	sig, err := parseRequestSignature(r)
+	if err != nil {
+		return nil, errors.NewUnauthorized(err, "invalid HTTP signature")
+	}
+	if sig == nil {
+		return nil, nil
+	}
+
+	var body []byte
+	if r.Body != nil {
+		if body, err = io.ReadAll(http.MaxBytesReader(nil, r.Body, p.maxRequestBodySize())); err != nil {
+			if mbe := new(http.MaxBytesError); errors.As(err, &mbe) {
+				return nil, RequestEntityTooLargef("request body exceeds maximum size of %d bytes", mbe.Limit)
+			}
+			return nil, errors.NewBadRequest(err, "unable to read request body")
+		}
+		_ = r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	hasDigest, err := verifyBodyDigest(r.Header, body)
+	if err != nil {
+		return nil, errors.NewUnauthorized(err, "invalid body digest")
+	}
+	if len(body) > 0 {
+		if !hasDigest {
+			return nil, errors.Unauthorizedf("missing body digest")
+		}
+		if !sig.covers("digest") && !sig.covers("content-digest") {
+			return nil, errors.Unauthorizedf("the body digest is not covered by the signature")
+		}
+	}
+
+	skew := p.clockSkew
+	if skew <= 0 {
+		skew = DefaultMaxClockSkew
+	}
+	if err = validateSignatureTime(r, sig, skew); err != nil {
+		return nil, errors.NewUnauthorized(err, "invalid HTTP signature")
+	}
+
+	actor, pub, fromStorage, err := p.dereferenceKeyOwner(sig.keyID, false)
+	if err != nil {
+		return nil, errors.NewUnauthorized(err, "unable to load key %s", sig.keyID)
+	}
+	if err = verifySignature(pub, sig.alg, []byte(sig.base), sig.signature); err != nil {
+		if !fromStorage || p.IsLocal(actor) {
+			return nil, errors.NewUnauthorized(err, "invalid HTTP signature")
+		}
+		if actor, pub, _, err = p.dereferenceKeyOwner(sig.keyID, true); err != nil {
+			return nil, errors.NewUnauthorized(err, "unable to load key %s", sig.keyID)
+		}
+		if err = verifySignature(pub, sig.alg, []byte(sig.base), sig.signature); err != nil {
+			return nil, errors.NewUnauthorized(err, "invalid HTTP signature")
+		}
+	}
+	return actor, nil
+}
+
+// ecdsaAlgorithm returns the name of the RFC9421 algorithm, and the hash function, of the ECDSA signatures
+// made with keys on the "curve" elliptic curve.
+func ecdsaAlgorithm(curve elliptic.Curve) (string, crypto.Hash, error) {
+	switch name := curve.Params().Name; name {
+	case "P-256":
+		return "ecdsa-p256-sha256", crypto.SHA256, nil
+	case "P-384":
+		return "ecdsa-p384-sha384", crypto.SHA384, nil
+	default:
+		return "", 0, errors.Newf("unsupported ECDSA curve %s", name)
+	}
+}
+
+// signatureAlgorithm returns the name of the algorithm we use to sign with "key", for either of the
+// draft-cavage or RFC9421 HTTP signatures.
+func signatureAlgorithm(key crypto.PrivateKey, rfc9421 bool) (string, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if rfc9421 {
+			return "rsa-v1_5-sha256", nil
+		}
+		return "rsa-sha256", nil
+	case *ecdsa.PrivateKey:
+		alg, _, err := ecdsaAlgorithm(k.Curve)
+		if err != nil || rfc9421 {
+			return alg, err
+		}
+		return "hs2019", nil
+	case ed25519.PrivateKey:
+		if rfc9421 {
+			return "ed25519", nil
+		}
+		return "hs2019", nil
+	}
+	return "", errors.Newf("unsupported private key type %T", key)
+}
+
+// signData signs "data" with "key". For ECDSA keys, RFC9421 signatures use the raw concatenation of the r and s
+// values, while the draft-cavage ones use the ASN.1 encoding.
+func signData(key crypto.PrivateKey, data []byte, rfc9421 bool) ([]byte, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, hashSum(crypto.SHA256, data))
+	case *ecdsa.PrivateKey:
+		_, h, err := ecdsaAlgorithm(k.Curve)
+		if err != nil {
+			return nil, err
+		}
+		digest := hashSum(h, data)
+		if !rfc9421 {
+			return ecdsa.SignASN1(rand.Reader, k, digest)
+		}
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest)
+		if err != nil {
+			return nil, err
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		sig := make([]byte, 2*size)
+		r.FillBytes(sig[:size])
+		s.FillBytes(sig[size:])
+		return sig, nil
+	case ed25519.PrivateKey:
+		return ed25519.Sign(k, data), nil
+	}
+	return nil, errors.Newf("unsupported private key type %T", key)
+}
+
+// signRequest adds the Date and, for requests with a body, the Digest and Content-Digest headers to "r",
+// and then signs it with "key", using either a draft-cavage or an RFC9421 HTTP signature.
+// The body of the request, if present, is replaced with an in-memory copy.
+func signRequest(r *http.Request, key crypto.PrivateKey, keyID vocab.IRI, rfc9421 bool) error {
+	now := time.Now().UTC()
+	if len(r.Header.Get("Date")) == 0 {
+		r.Header.Set("Date", now.Format(http.TimeFormat))
+	}
+
+	var body []byte
+	if r.Body != nil && r.Body != http.NoBody {
+		var err error
+		if body, err = io.ReadAll(r.Body); err != nil {
+			return errors.Annotatef(err, "unable to read request body")
+		}
+		_ = r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		r.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+		r.ContentLength = int64(len(body))
+	}
+	hasBody := len(body) > 0 || r.Method == http.MethodPost
+	if hasBody {
+		sum := sha256.Sum256(body)
+		digest := base64.StdEncoding.EncodeToString(sum[:])
+		r.Header.Set("Digest", "SHA-256="+digest)
+		r.Header.Set("Content-Digest", "sha-256=:"+digest+":")
+	}
+
+	alg, err := signatureAlgorithm(key, rfc9421)
+	if err != nil {
+		return err
+	}
+
+	if rfc9421 {
+		components := []string{"@method", "@target-uri", "date"}
+		if hasBody {
+			components = append(components, "content-digest")
+		}
+		params := rfc9421SignatureParams(components, keyID, alg, now)
+		base, err := rfc9421SignatureBase(r, components, params)
+		if err != nil {
+			return err
+		}
+		sig, err := signData(key, []byte(base), true)
+		if err != nil {
+			return errors.Annotatef(err, "unable to sign request")
+		}
+		r.Header.Set("Signature-Input", "sig1="+params)
+		r.Header.Set("Signature", "sig1=:"+base64.StdEncoding.EncodeToString(sig)+":")
+		return nil
+	}
+
+	headers := []string{"(request-target)", "host", "date"}
+	if hasBody {
+		headers = append(headers, "digest")
+	}
+	base, err := cavageSigningString(r, headers, time.Time{}, time.Time{})
+	if err != nil {
+		return err
+	}
+	sig, err := signData(key, []byte(base), false)
+	if err != nil {
+		return errors.Annotatef(err, "unable to sign request")
+	}
+	r.Header.Set("Signature", fmt.Sprintf(`keyId="%s",algorithm="%s",headers="%s",signature="%s"`,
+		keyID, alg, strings.Join(headers, " "), base64.StdEncoding.EncodeToString(sig)))
+	return nil
+}
+
+// signingKeyFor returns the private key and the key ID to use for signing requests made on behalf of "actor".
+// If the key of the actor can't be loaded, we fall back to the key of the instance actor.
+func (p *P) signingKeyFor(actor vocab.Item) (crypto.PrivateKey, vocab.IRI, error) {
+	if p.keyLoader == nil {
+		return nil, "", errors.Newf("no key loader has been set")
+	}
+
+	candidates := make(vocab.IRIs, 0, 2)
+	if !vocab.IsNil(actor) && p.IsLocal(actor) {
+		_ = candidates.Append(actor.GetLink())
+	}
+	if len(p.instanceActor) > 0 && !candidates.Contains(p.instanceActor) {
+		_ = candidates.Append(p.instanceActor)
+	}
+
+	errs := make([]error, 0)
+	for _, iri := range candidates {
+		key, err := p.keyLoader.LoadKey(iri)
+		if err != nil || key == nil {
+			errs = append(errs, errors.Annotatef(err, "unable to load key for %s", iri))
+			continue
+		}
+		keyID := vocab.IRI(iri.String() + "#main-key")
+		_ = vocab.OnActor(p.loadLocalCopy(iri), func(a *vocab.Actor) error {
+			if len(a.PublicKey.ID) > 0 {
+				keyID = a.PublicKey.ID
+			}
+			return nil
+		})
+		return key, keyID, nil
+	}
+	if len(errs) == 0 {
+		return nil, "", errors.Newf("no actor to sign the request for")
+	}
+	return nil, "", errors.Join(errs...)
+}
+
+type signingTransport struct {
+	p    *P
+	next http.RoundTripper
+}
+
+// SigningTransport returns an http.RoundTripper that signs the outgoing requests with the key of the actor
+// found in the request context (see ContextWithSigningActor), or with the key of the instance actor.
+// The keys are loaded with the processor's KeyLoader.
+//
+// It is meant to be used as the transport of the HTTP client which the processor's ActivityPub client uses,
+// so that the objects it fetches are requested with signed requests. The deliveries of the activities are signed
+// by the processor itself.
+func (p *P) SigningTransport(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return signingTransport{p: p, next: next}
+}
+
+// RoundTrip signs the request and executes it using the wrapped transport.
+func (s signingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if len(r.Header.Get("Signature")) > 0 || len(r.Header.Get("Authorization")) > 0 {
+		return s.next.RoundTrip(r)
+	}
+	actor, _ := SigningActorFromContext(r.Context())
+	key, keyID, err := s.p.signingKeyFor(actor)
+	if err != nil {
+		s.p.l.Warnf("unable to sign request to %s: %s", r.URL, err)
+		return s.next.RoundTrip(r)
+	}
+
+	// NOTE(marius): a RoundTripper must not modify the original request
+	signed := r.Clone(r.Context())
+	if err = s.p.signOutgoingRequest(signed, actor, key, keyID); err != nil {
+		return nil, err
+	}
+	return s.next.RoundTrip(signed)
+}
+
+// signOutgoingRequest signs the "r" request, made on behalf of "actor", with the "key" identified by "keyID".
+// The integrity proof of the activity in the body of the request gets attached before signing, see attachIntegrityProof.
+func (p *P) signOutgoingRequest(r *http.Request, actor vocab.Item, key crypto.PrivateKey, keyID vocab.IRI) error {
+	if err := p.attachIntegrityProof(r, actor, key, keyID); err != nil {
+		return err
+	}
+	return signRequest(r, key, keyID, p.signRFC9421)
+}
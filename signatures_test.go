@@ -0,0 +1,478 @@
+package processing
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+func testPublicKeyPEM(t *testing.T, pub crypto.PublicKey) string {
+	raw, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatalf("unable to marshal public key: %s", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: raw}))
+}
+
+func testSignedActor(t *testing.T, p *P, iri vocab.IRI, pub crypto.PublicKey) *vocab.Actor {
+	actor := &vocab.Actor{
+		ID:   iri,
+		Type: vocab.PersonType,
+		PublicKey: vocab.PublicKey{
+			ID:           iri + "#main-key",
+			Owner:        iri,
+			PublicKeyPem: testPublicKeyPEM(t, pub),
+		},
+	}
+	if _, err := p.s.Save(actor); err != nil {
+		t.Fatalf("unable to save actor: %s", err)
+	}
+	return actor
+}
+
+func testRequestWithDigest(body string, date time.Time) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "https://example.com/inbox", strings.NewReader(body))
+	sum := sha256.Sum256([]byte(body))
+	r.Header.Set("Digest", "SHA-256="+base64.StdEncoding.EncodeToString(sum[:]))
+	r.Header.Set("Date", date.UTC().Format(http.TimeFormat))
+	return r
+}
+
+func testCavageSign(t *testing.T, r *http.Request, key *rsa.PrivateKey, keyID vocab.IRI, headers []string) {
+	base, err := cavageSigningString(r, headers, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("unable to build signing string: %s", err)
+	}
+	digest := sha256.Sum256([]byte(base))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("unable to sign: %s", err)
+	}
+	r.Header.Set("Signature", `keyId="`+keyID.String()+`",algorithm="rsa-sha256",headers="`+strings.Join(headers, " ")+
+		`",signature="`+base64.StdEncoding.EncodeToString(sig)+`"`)
+}
+
+func Test_verifyBodyDigest(t *testing.T) {
+	body := []byte(`{"type":"Create"}`)
+	sum := sha256.Sum256(body)
+	b64 := base64.StdEncoding.EncodeToString(sum[:])
+	tests := []struct {
+		name       string
+		header     http.Header
+		wantDigest bool
+		wantErr    bool
+	}{
+		{
+			name:   "no digest",
+			header: http.Header{},
+		},
+		{
+			name:       "valid Digest",
+			header:     http.Header{"Digest": []string{"SHA-256=" + b64}},
+			wantDigest: true,
+		},
+		{
+			name:       "valid Content-Digest",
+			header:     http.Header{"Content-Digest": []string{"sha-256=:" + b64 + ":"}},
+			wantDigest: true,
+		},
+		{
+			name:       "mismatched Digest",
+			header:     http.Header{"Digest": []string{"SHA-256=" + base64.StdEncoding.EncodeToString([]byte("invalid"))}},
+			wantDigest: true,
+			wantErr:    true,
+		},
+		{
+			name:       "unsupported algorithm",
+			header:     http.Header{"Digest": []string{"MD5=" + b64}},
+			wantDigest: true,
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := verifyBodyDigest(tt.header, body)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("verifyBodyDigest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.wantDigest {
+				t.Errorf("verifyBodyDigest() = %v, want %v", got, tt.wantDigest)
+			}
+		})
+	}
+}
+
+func TestP_VerifyHTTPSignature(t *testing.T) {
+	base := vocab.IRI("https://example.com")
+	body := `{"type":"Create"}`
+
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	edPub, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	t.Run("unsigned", func(t *testing.T) {
+		p := mockProcessor(t, base)
+		author, err := p.VerifyHTTPSignature(testRequestWithDigest(body, time.Now()))
+		if err != nil || author != nil {
+			t.Errorf("VerifyHTTPSignature() = %v, %v, expected no author and no error", author, err)
+		}
+	})
+
+	t.Run("valid draft-cavage", func(t *testing.T) {
+		p := mockProcessor(t, base)
+		actor := testSignedActor(t, p, base+"/~jdoe", rsaKey.Public())
+		r := testRequestWithDigest(body, time.Now())
+		testCavageSign(t, r, rsaKey, actor.PublicKey.ID, []string{"(request-target)", "host", "date", "digest"})
+
+		author, err := p.VerifyHTTPSignature(r)
+		if err != nil {
+			t.Fatalf("VerifyHTTPSignature() error = %s", err)
+		}
+		if author == nil || !author.ID.Equal(actor.ID) {
+			t.Errorf("VerifyHTTPSignature() author = %v, want %s", author, actor.ID)
+		}
+	})
+
+	t.Run("tampered body", func(t *testing.T) {
+		p := mockProcessor(t, base)
+		actor := testSignedActor(t, p, base+"/~jdoe", rsaKey.Public())
+		r := testRequestWithDigest(body, time.Now())
+		testCavageSign(t, r, rsaKey, actor.PublicKey.ID, []string{"(request-target)", "host", "date", "digest"})
+		r.Body = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"type":"Delete"}`)).Body
+
+		if _, err := p.VerifyHTTPSignature(r); err == nil {
+			t.Errorf("VerifyHTTPSignature() expected error for tampered body")
+		}
+	})
+
+	t.Run("digest not signed", func(t *testing.T) {
+		p := mockProcessor(t, base)
+		actor := testSignedActor(t, p, base+"/~jdoe", rsaKey.Public())
+		r := testRequestWithDigest(body, time.Now())
+		testCavageSign(t, r, rsaKey, actor.PublicKey.ID, []string{"(request-target)", "host", "date"})
+
+		if _, err := p.VerifyHTTPSignature(r); err == nil {
+			t.Errorf("VerifyHTTPSignature() expected error for unsigned digest")
+		}
+	})
+
+	t.Run("clock skew", func(t *testing.T) {
+		p := mockProcessor(t, base)
+		actor := testSignedActor(t, p, base+"/~jdoe", rsaKey.Public())
+		r := testRequestWithDigest(body, time.Now().Add(-time.Hour))
+		testCavageSign(t, r, rsaKey, actor.PublicKey.ID, []string{"(request-target)", "host", "date", "digest"})
+
+		if _, err := p.VerifyHTTPSignature(r); err == nil {
+			t.Errorf("VerifyHTTPSignature() expected error for stale Date header")
+		}
+	})
+
+	t.Run("valid RFC9421", func(t *testing.T) {
+		p := mockProcessor(t, base)
+		actor := testSignedActor(t, p, base+"/~alice", edPub)
+		r := testRequestWithDigest(body, time.Now())
+		components := []string{"@method", "@target-uri", "digest"}
+		params := rfc9421SignatureParams(components, actor.PublicKey.ID, "ed25519", time.Now())
+		sigBase, err := rfc9421SignatureBase(r, components, params)
+		if err != nil {
+			t.Fatalf("unable to build signature base: %s", err)
+		}
+		r.Header.Set("Signature-Input", "sig1="+params)
+		r.Header.Set("Signature", "sig1=:"+base64.StdEncoding.EncodeToString(ed25519.Sign(edKey, []byte(sigBase)))+":")
+
+		author, err := p.VerifyHTTPSignature(r)
+		if err != nil {
+			t.Fatalf("VerifyHTTPSignature() error = %s", err)
+		}
+		if author == nil || !author.ID.Equal(actor.ID) {
+			t.Errorf("VerifyHTTPSignature() author = %v, want %s", author, actor.ID)
+		}
+		// NOTE(marius): the body needs to still be readable by the handlers
+		b := bytes.Buffer{}
+		if _, _ = b.ReadFrom(r.Body); b.String() != body {
+			t.Errorf("VerifyHTTPSignature() did not restore the request body, got %q", b.String())
+		}
+	})
+
+	t.Run("body too large", func(t *testing.T) {
+		p := mockProcessor(t, base)
+		p.maxBodySize = 8
+		actor := testSignedActor(t, p, base+"/~jdoe", rsaKey.Public())
+		r := testRequestWithDigest(body, time.Now())
+		testCavageSign(t, r, rsaKey, actor.PublicKey.ID, []string{"(request-target)", "host", "date", "digest"})
+
+		if _, err := p.VerifyHTTPSignature(r); !IsRequestEntityTooLarge(err) {
+			t.Errorf("VerifyHTTPSignature() error = %v, expected request entity too large", err)
+		}
+	})
+
+	t.Run("remote actor claiming a local ID", func(t *testing.T) {
+		p := mockProcessor(t, base)
+		var keyID vocab.IRI
+		srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// NOTE(marius): the remote server presents its own key as belonging to a local actor
+			impostor := vocab.Actor{
+				ID:   base + "/~jdoe",
+				Type: vocab.PersonType,
+				PublicKey: vocab.PublicKey{
+					ID:           keyID,
+					Owner:        base + "/~jdoe",
+					PublicKeyPem: testPublicKeyPEM(t, rsaKey.Public()),
+				},
+			}
+			raw, _ := vocab.MarshalJSON(impostor)
+			w.Header().Set("Content-Type", "application/activity+json")
+			_, _ = w.Write(raw)
+		}))
+		defer srv.Close()
+		keyID = vocab.IRI(srv.URL + "/~mallory#main-key")
+
+		r := testRequestWithDigest(body, time.Now())
+		testCavageSign(t, r, rsaKey, keyID, []string{"(request-target)", "host", "date", "digest"})
+
+		if author, err := p.VerifyHTTPSignature(r); err == nil {
+			t.Errorf("VerifyHTTPSignature() author = %v, expected error for impersonated actor", author)
+		}
+		if it, err := p.s.Load(base + "/~jdoe"); err == nil {
+			t.Errorf("VerifyHTTPSignature() cached the impersonated actor %v", it)
+		}
+	})
+
+	t.Run("path-style key ID", func(t *testing.T) {
+		var remote vocab.IRI
+		actorKeyID := func() vocab.IRI { return remote + "/users/jdoe/main-key" }
+		srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var raw []byte
+			switch r.URL.Path {
+			case "/users/jdoe/main-key", "/users/mallory/main-key":
+				// NOTE(marius): the key documents of GoToSocial, which are separate from the actor documents
+				raw, _ = json.Marshal(map[string]any{
+					"id":           remote.String() + r.URL.Path,
+					"owner":        remote + "/users/jdoe",
+					"publicKeyPem": testPublicKeyPEM(t, rsaKey.Public()),
+				})
+			case "/users/jdoe":
+				raw, _ = vocab.MarshalJSON(vocab.Actor{
+					ID:   remote + "/users/jdoe",
+					Type: vocab.PersonType,
+					PublicKey: vocab.PublicKey{
+						ID:           actorKeyID(),
+						Owner:        remote + "/users/jdoe",
+						PublicKeyPem: testPublicKeyPEM(t, rsaKey.Public()),
+					},
+				})
+			default:
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", "application/activity+json")
+			_, _ = w.Write(raw)
+		}))
+		defer srv.Close()
+		remote = vocab.IRI(srv.URL)
+
+		p := mockProcessor(t, base)
+		r := testRequestWithDigest(body, time.Now())
+		testCavageSign(t, r, rsaKey, actorKeyID(), []string{"(request-target)", "host", "date", "digest"})
+		author, err := p.VerifyHTTPSignature(r)
+		if err != nil {
+			t.Fatalf("VerifyHTTPSignature() error = %s", err)
+		}
+		if author == nil || !author.ID.Equal(remote+"/users/jdoe") {
+			t.Errorf("VerifyHTTPSignature() author = %v, want %s", author, remote+"/users/jdoe")
+		}
+
+		// NOTE(marius): the key document claims an owner whose public key is a different one
+		r = testRequestWithDigest(body, time.Now())
+		testCavageSign(t, r, rsaKey, remote+"/users/mallory/main-key", []string{"(request-target)", "host", "date", "digest"})
+		if author, err = p.VerifyHTTPSignature(r); err == nil {
+			t.Errorf("VerifyHTTPSignature() author = %v, expected error for a key which isn't the owner's", author)
+		}
+	})
+}
+
+func Test_signRequest(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ec384Key, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	rfc9421Algorithms := map[crypto.Signer]string{
+		rsaKey:   "rsa-v1_5-sha256",
+		ecKey:    "ecdsa-p256-sha256",
+		ec384Key: "ecdsa-p384-sha384",
+		edKey:    "ed25519",
+	}
+	for _, key := range []crypto.Signer{rsaKey, ecKey, ec384Key, edKey} {
+		for _, rfc9421 := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%T rfc9421=%t", key, rfc9421), func(t *testing.T) {
+				r := httptest.NewRequest(http.MethodPost, "https://example.com/inbox", strings.NewReader(`{"type":"Create"}`))
+				if err := signRequest(r, key, "https://example.com/~jdoe#main-key", rfc9421); err != nil {
+					t.Fatalf("signRequest() error = %s", err)
+				}
+				sig, err := parseRequestSignature(r)
+				if err != nil {
+					t.Fatalf("parseRequestSignature() error = %s", err)
+				}
+				if want := rfc9421Algorithms[key]; rfc9421 && sig.alg != want {
+					t.Errorf("signRequest() algorithm = %q, want %q", sig.alg, want)
+				}
+				if err = verifySignature(key.Public(), sig.alg, []byte(sig.base), sig.signature); err != nil {
+					t.Errorf("verifySignature() error = %s", err)
+				}
+				body, _ := io.ReadAll(r.Body)
+				if ok, err := verifyBodyDigest(r.Header, body); !ok || err != nil {
+					t.Errorf("verifyBodyDigest() = %t, %v", ok, err)
+				}
+			})
+		}
+	}
+}
+
+func Test_verifySignature_ecdsaAlgorithmMismatch(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	data := []byte("signature base")
+	sig, err := signData(key, data, true)
+	if err != nil {
+		t.Fatalf("signData() error = %s", err)
+	}
+	if err = verifySignature(key.Public(), "ecdsa-p384-sha384", data, sig); err != nil {
+		t.Errorf("verifySignature() error = %s", err)
+	}
+	if err = verifySignature(key.Public(), "ecdsa-p256-sha256", data, sig); err == nil {
+		t.Errorf("verifySignature() expected error for an algorithm which doesn't match the curve of the key")
+	}
+}
+
+type mockKeyLoader map[vocab.IRI]crypto.PrivateKey
+
+func (m mockKeyLoader) LoadKey(iri vocab.IRI) (crypto.PrivateKey, error) {
+	if key, ok := m[iri]; ok {
+		return key, nil
+	}
+	return nil, errors.NotFoundf("no key for %s", iri)
+}
+
+func TestP_signingKeyFor(t *testing.T) {
+	base := vocab.IRI("https://example.com")
+	actorKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	instanceKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	p := mockProcessor(t, base)
+	p.instanceActor = base
+	p.keyLoader = mockKeyLoader{base + "/~jdoe": actorKey, base: instanceKey}
+
+	tests := []struct {
+		name      string
+		actor     vocab.Item
+		wantKey   crypto.PrivateKey
+		wantKeyID vocab.IRI
+	}{
+		{
+			name:      "local actor",
+			actor:     base + "/~jdoe",
+			wantKey:   actorKey,
+			wantKeyID: base + "/~jdoe#main-key",
+		},
+		{
+			name:      "local actor without key falls back to instance actor",
+			actor:     base + "/~alice",
+			wantKey:   instanceKey,
+			wantKeyID: base + "#main-key",
+		},
+		{
+			name:      "remote actor falls back to instance actor",
+			actor:     vocab.IRI("https://remote.example.com/~bob"),
+			wantKey:   instanceKey,
+			wantKeyID: base + "#main-key",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, keyID, err := p.signingKeyFor(tt.actor)
+			if err != nil {
+				t.Fatalf("signingKeyFor() error = %s", err)
+			}
+			if key != tt.wantKey {
+				t.Errorf("signingKeyFor() returned the wrong key")
+			}
+			if !keyID.Equal(tt.wantKeyID) {
+				t.Errorf("signingKeyFor() key ID = %s, want %s", keyID, tt.wantKeyID)
+			}
+		})
+	}
+}
+
+func TestP_disseminateToRemoteCollections_signed(t *testing.T) {
+	var signature, contentType string
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signature = r.Header.Get("Signature")
+		contentType = r.Header.Get("Content-Type")
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	p := mockProcessor(t, defaultActorID)
+	p.c = nil
+	p.keyLoader = mockKeyLoader{defaultActorID: key}
+	p.deliveryTransport = srv.Client().Transport
+
+	act := &vocab.Activity{
+		ID:     defaultActorID.AddPath("activities/1"),
+		Type:   vocab.CreateType,
+		Actor:  defaultActorID,
+		Object: &vocab.Object{ID: defaultActorID.AddPath("objects/1"), Type: vocab.NoteType},
+	}
+	if err := p.disseminateToRemoteCollections(act, vocab.IRI(srv.URL+"/inbox")); err != nil {
+		t.Fatalf("disseminateToRemoteCollections() error = %s", err)
+	}
+	if !strings.Contains(signature, `keyId="`+defaultActorID.String()+`#main-key"`) {
+		t.Errorf("disseminateToRemoteCollections() signature = %q, expected it to be signed with the key of the actor", signature)
+	}
+	if contentType != activityStreamsMediaType {
+		t.Errorf("disseminateToRemoteCollections() content type = %q, want %q", contentType, activityStreamsMediaType)
+	}
+}
+
+func TestHTTPSignatureVerifierMw_maxBodySize(t *testing.T) {
+	p := mockProcessor(t, "https://example.com")
+	p.maxBodySize = 10
+
+	var readErr error
+	handler := HTTPSignatureVerifierMw(p)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, readErr = io.ReadAll(r.Body); readErr != nil {
+			handleError(readErr).ServeHTTP(w, r)
+		}
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "https://example.com/inbox", strings.NewReader("small")))
+	if readErr != nil || w.Code != http.StatusOK {
+		t.Errorf("HTTPSignatureVerifierMw() status = %d, error = %v for body within limit", w.Code, readErr)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "https://example.com/inbox", strings.NewReader(strings.Repeat("a", 11))))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("HTTPSignatureVerifierMw() status = %d, want %d for unsigned body over limit", w.Code, http.StatusRequestEntityTooLarge)
+	}
+}
@@ -1,6 +1,8 @@
 package processing
 
 import (
+	"encoding/json"
+
 	vocab "github.com/go-ap/activitypub"
 	"github.com/go-ap/filters"
 )
@@ -34,3 +36,25 @@ type CollectionStore interface {
 	// RemoveFrom removes "it" item from "col" collection
 	RemoveFrom(vocab.IRI, ...vocab.Item) error
 }
+
+// BlobStore saves binary media content outside the ActivityStreams objects storage.
+type BlobStore interface {
+	// Put saves the "data" binary content with "mediaType", and returns the IRI where it can be retrieved from,
+	// together with its size in bytes.
+	Put(mediaType vocab.MimeType, data []byte) (vocab.IRI, int64, error)
+	// Get returns the binary content stored at "iri" and its media type.
+	Get(iri vocab.IRI) ([]byte, vocab.MimeType, error)
+	// Size returns the size in bytes of the binary content stored at "iri".
+	Size(iri vocab.IRI) (int64, error)
+	// Delete removes the binary content stored at "iri".
+	Delete(iri vocab.IRI) error
+}
+
+// ProofStore keeps the FEP-8b32 integrity proofs of the activities, which the ActivityPub vocabulary types
+// can't hold, so they can be sent together with the activities when delivering or forwarding them.
+type ProofStore interface {
+	// SaveProof saves the raw JSON "proof" property of the activity with the "iri" ID.
+	SaveProof(iri vocab.IRI, proof json.RawMessage) error
+	// LoadProof returns the raw JSON "proof" property of the activity with the "iri" ID.
+	LoadProof(iri vocab.IRI) (json.RawMessage, error)
+}
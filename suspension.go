@@ -0,0 +1,127 @@
+package processing
+
+import (
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+// ActorStatus is the moderation status of an actor.
+type ActorStatus uint8
+
+const (
+	// Active is the status of the actors which are not restricted in any way.
+	Active ActorStatus = iota
+	// Silenced actors can still post, but their activities are delivered only to their followers,
+	// and their content is not visible publicly.
+	Silenced
+	// Suspended actors can't post, their activities are not accepted from other servers,
+	// and their content is hidden.
+	Suspended
+)
+
+// ActorStatusPolicy resolves the moderation status of the actors, local or remote.
+type ActorStatusPolicy interface {
+	ActorStatus(actor vocab.IRI) ActorStatus
+}
+
+// ActorStatusMap is an ActorStatusPolicy which keeps the statuses of the actors in memory.
+// The actors missing from the map are Active.
+type ActorStatusMap map[vocab.IRI]ActorStatus
+
+func (m ActorStatusMap) ActorStatus(actor vocab.IRI) ActorStatus {
+	return m[actor]
+}
+
+// actorStatus returns the moderation status of the "actor" according to the processor's ActorStatusPolicy.
+func (p P) actorStatus(actor vocab.Item) ActorStatus {
+	if p.actorStatusPolicy == nil || vocab.IsNil(actor) || vocab.PublicNS.Equal(actor.GetLink()) {
+		return Active
+	}
+	return p.actorStatusPolicy.ActorStatus(actor.GetLink())
+}
+
+// IsSuspended checks if the "actor" is suspended.
+func (p P) IsSuspended(actor vocab.Item) bool {
+	return p.actorStatus(actor) == Suspended
+}
+
+// IsSilenced checks if the "actor" is silenced.
+func (p P) IsSilenced(actor vocab.Item) bool {
+	return p.actorStatus(actor) == Silenced
+}
+
+// validateActorStatus returns a forbidden error if any of the "actors" is suspended.
+func (p P) validateActorStatus(actors ...vocab.Item) error {
+	for _, actor := range actors {
+		suspended := false
+		_ = vocab.OnItem(actor, func(actor vocab.Item) error {
+			suspended = suspended || p.IsSuspended(actor)
+			return nil
+		})
+		if suspended {
+			return errors.Forbiddenf("actor %s is suspended", actor.GetLink())
+		}
+	}
+	return nil
+}
+
+// restrictSilencedRecipients removes from the "recipients" of an activity, whose actor is silenced, the ones that
+// don't correspond to the actor itself, or to its followers. The recipients of activities of actors that are not
+// silenced are returned unchanged.
+//
+// For local actors, the recipients are kept if they belong to the actors in their followers collection, or if
+// they're shared inboxes on the servers of those actors.
+// For remote actors, the local recipients are kept if their owners have the actor in their following collection.
+func (p P) restrictSilencedRecipients(actor vocab.Item, recipients vocab.ItemCollection) vocab.ItemCollection {
+	if !p.IsSilenced(actor) || len(recipients) == 0 {
+		return recipients
+	}
+	actorIRI := actor.GetLink()
+	local := p.IsLocalIRI(actorIRI)
+
+	// NOTE(marius): the memberships are looked up only once for each of the recipients, and their owners,
+	// and the followers collection is walked only if there are shared inboxes among the recipients.
+	follows := make(map[vocab.IRI]bool)
+	isFollower := func(iri vocab.IRI) bool {
+		if f, ok := follows[iri]; ok {
+			return f
+		}
+		f := false
+		if local {
+			f = p.isCollectionMember(vocab.Followers.IRI(actorIRI), iri)
+		} else {
+			f = p.isCollectionMember(vocab.Following.IRI(iri), actorIRI)
+		}
+		follows[iri] = f
+		return f
+	}
+	var followerHosts map[string]struct{}
+	hasFollowersOn := func(host string) bool {
+		if followerHosts == nil {
+			followerHosts = make(map[string]struct{})
+			_ = p.walkCollection(vocab.Followers.IRI(actorIRI), func(it vocab.Item) error {
+				followerHosts[iriHost(it.GetLink())] = struct{}{}
+				return nil
+			})
+		}
+		_, ok := followerHosts[host]
+		return ok
+	}
+
+	restricted := make(vocab.ItemCollection, 0, len(recipients))
+	for _, rec := range recipients {
+		recIRI := rec.GetLink()
+		owner, col := vocab.Split(recIRI)
+		switch {
+		case recIRI.Equal(actorIRI) || owner.Equal(actorIRI):
+		case local && (isFollower(recIRI) || isFollower(owner)):
+		case !local && col == vocab.Inbox && isFollower(owner):
+		case local && !p.IsLocalIRI(recIRI) && hasFollowersOn(iriHost(recIRI)):
+		default:
+			p.l.Debugf("Skipping recipient %s which doesn't follow the silenced actor %s", recIRI, actorIRI)
+			continue
+		}
+		restricted = append(restricted, rec)
+	}
+	return restricted
+}
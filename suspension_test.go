@@ -0,0 +1,136 @@
+package processing
+
+import (
+	"testing"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestP_validateActorStatus(t *testing.T) {
+	suspended := vocab.IRI("https://remote.example.com/~suspended")
+	silenced := vocab.IRI("https://remote.example.com/~silenced")
+
+	p := mockProcessor(t, defaultActorID)
+	p.actorStatusPolicy = ActorStatusMap{suspended: Suspended, silenced: Silenced}
+
+	tests := []struct {
+		name    string
+		actors  []vocab.Item
+		wantErr bool
+	}{
+		{name: "no actors"},
+		{name: "active actor", actors: []vocab.Item{defaultActorID}},
+		{name: "silenced actor", actors: []vocab.Item{silenced}},
+		{name: "suspended actor", actors: []vocab.Item{suspended}, wantErr: true},
+		{name: "suspended actor in collection", actors: []vocab.Item{vocab.ItemCollection{defaultActorID, suspended}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := p.validateActorStatus(tt.actors...)
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("validateActorStatus() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.IsForbidden(err) {
+				t.Errorf("validateActorStatus() error = %v, expected forbidden", err)
+			}
+		})
+	}
+}
+
+func TestP_restrictSilencedRecipients(t *testing.T) {
+	p := mockProcessor(t, defaultActorID)
+
+	localFollower := defaultActorID.AddPath("follower")
+	localStranger := defaultActorID.AddPath("stranger")
+	remoteFollower := vocab.IRI("https://remote.example.com/~follower")
+	if err := p.s.AddTo(vocab.Followers.IRI(defaultActor), localFollower, remoteFollower); err != nil {
+		t.Fatalf("unable to add followers: %s", err)
+	}
+	silencedRemote := vocab.IRI("https://silenced.example.com/~jdoe")
+	if _, err := p.s.Create(emptyCol(vocab.Following.IRI(localFollower))); err != nil {
+		t.Fatalf("unable to create following collection: %s", err)
+	}
+	if err := p.s.AddTo(vocab.Following.IRI(localFollower), silencedRemote); err != nil {
+		t.Fatalf("unable to add following: %s", err)
+	}
+
+	recipients := vocab.ItemCollection{
+		vocab.Outbox.IRI(defaultActor),
+		vocab.Inbox.IRI(localFollower),
+		vocab.Inbox.IRI(localStranger),
+		vocab.Inbox.IRI(remoteFollower),
+		vocab.IRI("https://remote.example.com/inbox"),
+		vocab.IRI("https://other.example.com/inbox"),
+	}
+
+	tests := []struct {
+		name   string
+		status ActorStatusMap
+		actor  vocab.IRI
+		want   vocab.ItemCollection
+	}{
+		{
+			name:  "active actor",
+			actor: defaultActorID,
+			want:  recipients,
+		},
+		{
+			name:   "silenced local actor",
+			status: ActorStatusMap{defaultActorID: Silenced},
+			actor:  defaultActorID,
+			want: vocab.ItemCollection{
+				vocab.Outbox.IRI(defaultActor),
+				vocab.Inbox.IRI(localFollower),
+				vocab.Inbox.IRI(remoteFollower),
+				vocab.IRI("https://remote.example.com/inbox"),
+			},
+		},
+		{
+			name:   "silenced remote actor",
+			status: ActorStatusMap{silencedRemote: Silenced},
+			actor:  silencedRemote,
+			want:   vocab.ItemCollection{vocab.Inbox.IRI(localFollower)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p.actorStatusPolicy = tt.status
+			got := p.restrictSilencedRecipients(tt.actor, recipients)
+			if !cmp.Equal(got, tt.want) {
+				t.Errorf("restrictSilencedRecipients() = %s", cmp.Diff(tt.want, got))
+			}
+		})
+	}
+}
+
+func TestP_CanRead_suspended(t *testing.T) {
+	p := mockProcessor(t, defaultActorID)
+	p.actorStatusPolicy = ActorStatusMap{defaultActorID: Suspended}
+
+	public := &vocab.Object{
+		ID:           defaultActorID + "/objects/1",
+		Type:         vocab.NoteType,
+		AttributedTo: defaultActorID,
+		To:           vocab.ItemCollection{vocab.PublicNS},
+	}
+	tests := []struct {
+		name      string
+		it        vocab.Item
+		requester vocab.Item
+		want      bool
+	}{
+		{name: "public object for anonymous", it: public, want: false},
+		{name: "actor for anonymous", it: defaultActor, want: false},
+		{name: "public object for stranger", it: public, requester: vocab.IRI("https://remote.example.com/~jdoe"), want: false},
+		{name: "public object for author", it: public, requester: defaultActorID, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := p.CanRead(tt.it, tt.requester); got != tt.want {
+				t.Errorf("CanRead() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
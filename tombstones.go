@@ -0,0 +1,222 @@
+package processing
+
+import (
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+	"github.com/go-ap/errors"
+)
+
+// TombstonesCollection is the hidden collection, belonging to the local base IRIs, that
+// indexes the tombstones which can be purged.
+const TombstonesCollection = vocab.CollectionPath("tombstones")
+
+// TombstonePolicy determines how long the Tombstones resulting from Delete activities are kept in storage.
+type TombstonePolicy struct {
+	// KeepFor is the duration the tombstones are kept for, before P.PurgeTombstones can remove them.
+	// A zero value means they are kept forever.
+	KeepFor time.Duration
+	// HardDelete means that the deleted objects are removed from storage immediately,
+	// without leaving a Tombstone behind.
+	HardDelete bool
+}
+
+var (
+	// KeepTombstonesForever is the default policy, the tombstones never get purged.
+	KeepTombstonesForever = TombstonePolicy{}
+	// HardDeleteImmediately removes the deleted objects from storage when processing the Delete activity.
+	HardDeleteImmediately = TombstonePolicy{HardDelete: true}
+)
+
+// KeepTombstonesFor returns a policy that allows purging the tombstones older than "d".
+func KeepTombstonesFor(d time.Duration) TombstonePolicy {
+	return TombstonePolicy{KeepFor: d}
+}
+
+// expired returns true if a tombstone deleted at "deleted" can be purged at the "now" moment.
+func (t TombstonePolicy) expired(deleted, now time.Time) bool {
+	if t.HardDelete {
+		return true
+	}
+	if t.KeepFor <= 0 {
+		return false
+	}
+	return deleted.Add(t.KeepFor).Before(now)
+}
+
+// localBaseIRI returns the local base IRI that "it" belongs to, if any.
+func (p *P) localBaseIRI(it vocab.Item) vocab.IRI {
+	for _, base := range p.baseIRI {
+		if it.GetLink().Contains(base, false) {
+			return base
+		}
+	}
+	return ""
+}
+
+// applyTombstonePolicy either hard deletes the "tombstones", or adds them to the tombstones index
+// collection, so they can be purged later.
+func (p *P) applyTombstonePolicy(tombstones vocab.Item) error {
+	errs := make([]error, 0)
+	_ = vocab.OnItem(tombstones, func(it vocab.Item) error {
+		if !vocab.TombstoneType.Match(it.GetType()) {
+			return nil
+		}
+		if p.tombstonePolicy.HardDelete {
+			if err := p.purgeTombstone(it); err != nil {
+				errs = append(errs, err)
+			}
+			return nil
+		}
+		base := p.localBaseIRI(it)
+		if base == "" {
+			return nil
+		}
+		indexIRI := TombstonesCollection.IRI(base)
+		if err := p.saveCollectionObjectForParent(base, blankOrderedCollection(indexIRI)); err != nil {
+			errs = append(errs, errors.Annotatef(err, "unable to create tombstones collection %s", indexIRI))
+			return nil
+		}
+		if err := p.s.AddTo(indexIRI, it.GetLink()); err != nil {
+			errs = append(errs, errors.Annotatef(err, "unable to add tombstone %s to %s", it.GetLink(), indexIRI))
+		}
+		return nil
+	})
+	return errors.Join(errs...)
+}
+
+// purgeTombstone removes the tombstone from storage, together with all the data the deleted object has left
+// behind: its collections, its previous versions and their media, the activities that created and updated it
+// in its author's outbox, and its memberships in the liked and disliked collections of the local actors, in the
+// local replies collection of the object it was replying to, and in the tombstones index collection.
+func (p *P) purgeTombstone(it vocab.Item) error {
+	errs := make([]error, 0)
+	removeFrom := func(colIRI vocab.IRI, items ...vocab.Item) {
+		if colIRI == "" || !p.IsLocalIRI(colIRI) {
+			return
+		}
+		if err := p.s.RemoveFrom(colIRI, items...); err != nil && !errors.IsNotFound(err) {
+			errs = append(errs, errors.Annotatef(err, "unable to remove %v from collection %s", items, colIRI))
+		}
+	}
+	deleteItem := func(iri vocab.IRI) {
+		if err := p.s.Delete(iri); err != nil && !errors.IsNotFound(err) {
+			errs = append(errs, errors.Annotatef(err, "unable to delete %s", iri))
+		}
+	}
+
+	var author vocab.IRI
+	_ = vocab.OnTombstone(it, func(t *vocab.Tombstone) error {
+		if !vocab.IsNil(t.AttributedTo) {
+			author = t.AttributedTo.GetLink()
+		}
+		return vocab.OnItem(t.InReplyTo, func(replyTo vocab.Item) error {
+			removeFrom(vocab.Replies.IRI(replyTo), it.GetLink())
+			return nil
+		})
+	})
+
+	// NOTE(marius): the liked and disliked collections of the actors contain the object, and we find
+	// those actors from the Like and Dislike activities in the object's likes and dislikes collections.
+	for _, typ := range []vocab.ActivityVocabularyType{vocab.LikeType, vocab.DislikeType} {
+		actorCol, objectCol := appreciationCollections(typ)
+		_ = p.walkCollection(objectCol.IRI(it), func(act vocab.Item) error {
+			return vocab.OnActivity(p.loadLocalCopy(act), func(a *vocab.Activity) error {
+				return vocab.OnItem(a.Actor, func(actor vocab.Item) error {
+					removeFrom(actorCol.IRI(actor), it.GetLink())
+					return nil
+				})
+			})
+		})
+	}
+
+	historyIRI := HistoryCollection.IRI(it)
+	_ = p.walkCollection(historyIRI, func(version vocab.Item) error {
+		version = p.loadLocalCopy(version)
+		p.releaseMediaQuota(version, p.deleteBlobs(mediaBlobIRIs(version)...))
+		deleteItem(version.GetLink())
+		return nil
+	})
+
+	// NOTE(marius): the tombstone doesn't keep the IRI of the Create activity, so we need to go through the
+	// author's outbox for finding the activities that created and updated the object.
+	if len(author) > 0 && p.IsLocalIRI(author) {
+		outbox := vocab.Outbox.IRI(author)
+		activities := make(vocab.IRIs, 0)
+		_ = p.walkCollection(outbox, func(act vocab.Item) error {
+			return vocab.OnActivity(p.loadLocalCopy(act), func(a *vocab.Activity) error {
+				if storedObjectTypes.Match(a.Type) && !vocab.IsNil(a.Object) && a.Object.GetLink().Equal(it.GetLink()) {
+					_ = activities.Append(a.GetLink())
+				}
+				return nil
+			})
+		})
+		for _, iri := range activities {
+			removeFrom(outbox, iri)
+			deleteItem(iri)
+		}
+	}
+
+	for _, colIRI := range []vocab.IRI{vocab.Replies.IRI(it), vocab.Likes.IRI(it), vocab.Shares.IRI(it), DislikesCollection.IRI(it), historyIRI} {
+		deleteItem(colIRI)
+	}
+	if base := p.localBaseIRI(it); base != "" {
+		removeFrom(TombstonesCollection.IRI(base), it.GetLink())
+	}
+
+	if err := p.s.Delete(it.GetLink()); err != nil && !errors.IsNotFound(err) {
+		errs = append(errs, errors.Annotatef(err, "unable to delete tombstone %s", it.GetLink()))
+	} else {
+		p.releaseTombstoneQuota(it)
+	}
+	return errors.Join(errs...)
+}
+
+// PurgeTombstones hard deletes the tombstones that have been deleted before the "before" moment, and
+// which have expired according to the processor's TombstonePolicy.
+// It is meant to be called periodically as a maintenance task, and it returns an error when the policy is
+// KeepTombstonesForever, as no tombstone can expire under it, regardless of the "before" moment.
+func (p *P) PurgeTombstones(before time.Time) error {
+	if p.tombstonePolicy == KeepTombstonesForever {
+		return errors.Conflictf("the tombstone policy keeps them forever, unable to purge the tombstones deleted before %s", before)
+	}
+	now := time.Now().UTC()
+	errs := make([]error, 0)
+	for _, base := range p.baseIRI {
+		expired := make(vocab.ItemCollection, 0)
+		stale := make(vocab.IRIs, 0)
+
+		indexIRI := TombstonesCollection.IRI(base)
+		err := p.walkCollection(indexIRI, func(it vocab.Item) error {
+			it = p.loadLocalCopy(it)
+			if !vocab.TombstoneType.Match(it.GetType()) {
+				// NOTE(marius): the object doesn't exist anymore, or it has been replaced
+				return stale.Append(it.GetLink())
+			}
+			return vocab.OnTombstone(it, func(t *vocab.Tombstone) error {
+				if t.Deleted.Before(before) && p.tombstonePolicy.expired(t.Deleted, now) {
+					return expired.Append(it)
+				}
+				return nil
+			})
+		})
+		if err != nil {
+			if !errors.IsNotFound(err) {
+				errs = append(errs, errors.Annotatef(err, "unable to load tombstones from %s", indexIRI))
+			}
+			continue
+		}
+
+		for _, t := range expired {
+			if err = p.purgeTombstone(t); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		for _, iri := range stale {
+			if err = p.s.RemoveFrom(indexIRI, iri); err != nil && !errors.IsNotFound(err) {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
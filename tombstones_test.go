@@ -0,0 +1,230 @@
+package processing
+
+import (
+	"testing"
+	"time"
+
+	vocab "github.com/go-ap/activitypub"
+)
+
+func TestTombstonePolicy_expired(t *testing.T) {
+	now := time.Now().UTC()
+	tests := []struct {
+		name    string
+		policy  TombstonePolicy
+		deleted time.Time
+		want    bool
+	}{
+		{
+			name:    "keep forever",
+			policy:  KeepTombstonesForever,
+			deleted: now.Add(-24 * 365 * time.Hour),
+			want:    false,
+		},
+		{
+			name:    "hard delete",
+			policy:  HardDeleteImmediately,
+			deleted: now,
+			want:    true,
+		},
+		{
+			name:    "keep for a day, deleted an hour ago",
+			policy:  KeepTombstonesFor(24 * time.Hour),
+			deleted: now.Add(-time.Hour),
+			want:    false,
+		},
+		{
+			name:    "keep for a day, deleted two days ago",
+			policy:  KeepTombstonesFor(24 * time.Hour),
+			deleted: now.Add(-48 * time.Hour),
+			want:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.expired(tt.deleted, now); got != tt.want {
+				t.Errorf("expired() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestP_PurgeTombstones(t *testing.T) {
+	now := time.Now().UTC()
+	p := mockProcessor(t, defaultActorID)
+	p.tombstonePolicy = KeepTombstonesFor(24 * time.Hour)
+
+	parent := &vocab.Object{ID: defaultActorID + "/objects/parent", Type: vocab.NoteType}
+	if _, err := p.s.Save(parent); err != nil {
+		t.Fatalf("unable to save object: %s", err)
+	}
+	if _, err := p.s.Create(emptyCol(vocab.Replies.IRI(parent))); err != nil {
+		t.Fatalf("unable to create replies collection: %s", err)
+	}
+
+	expired := &vocab.Tombstone{
+		ID:         defaultActorID + "/objects/expired",
+		Type:       vocab.TombstoneType,
+		FormerType: vocab.NoteType,
+		Deleted:    now.Add(-48 * time.Hour),
+		InReplyTo:  parent.ID,
+	}
+	recent := &vocab.Tombstone{
+		ID:         defaultActorID + "/objects/recent",
+		Type:       vocab.TombstoneType,
+		FormerType: vocab.NoteType,
+		Deleted:    now.Add(-time.Hour),
+	}
+	remote := &vocab.Tombstone{
+		ID:      "https://remote.example.com/objects/1",
+		Type:    vocab.TombstoneType,
+		Deleted: now.Add(-48 * time.Hour),
+	}
+	for _, ts := range []*vocab.Tombstone{expired, recent, remote} {
+		if _, err := p.s.Save(ts); err != nil {
+			t.Fatalf("unable to save tombstone: %s", err)
+		}
+	}
+	if err := p.s.AddTo(vocab.Replies.IRI(parent), expired.ID); err != nil {
+		t.Fatalf("unable to add reply: %s", err)
+	}
+	if err := p.applyTombstonePolicy(vocab.ItemCollection{expired, recent, remote}); err != nil {
+		t.Fatalf("applyTombstonePolicy() error = %s", err)
+	}
+
+	indexIRI := TombstonesCollection.IRI(defaultActorID)
+	stale := vocab.IRI(defaultActorID + "/objects/missing")
+	if err := p.s.AddTo(indexIRI, stale); err != nil {
+		t.Fatalf("unable to add stale tombstone to index: %s", err)
+	}
+
+	if err := p.PurgeTombstones(now); err != nil {
+		t.Fatalf("PurgeTombstones() error = %s", err)
+	}
+
+	if _, err := p.s.Load(expired.ID); err == nil {
+		t.Errorf("PurgeTombstones() expired tombstone %s was not removed", expired.ID)
+	}
+	if _, err := p.s.Load(recent.ID); err != nil {
+		t.Errorf("PurgeTombstones() recent tombstone %s was removed", recent.ID)
+	}
+	if _, err := p.s.Load(remote.ID); err != nil {
+		t.Errorf("PurgeTombstones() remote tombstone %s was removed", remote.ID)
+	}
+
+	contains := func(colIRI, iri vocab.IRI) bool {
+		found := false
+		_ = p.walkCollection(colIRI, func(it vocab.Item) error {
+			found = found || it.GetLink().Equal(iri)
+			return nil
+		})
+		return found
+	}
+	if contains(vocab.Replies.IRI(parent), expired.ID) {
+		t.Errorf("PurgeTombstones() expired tombstone is still in the replies of %s", parent.ID)
+	}
+	for _, iri := range []vocab.IRI{expired.ID, stale} {
+		if contains(indexIRI, iri) {
+			t.Errorf("PurgeTombstones() %s is still in the tombstones index", iri)
+		}
+	}
+	if !contains(indexIRI, recent.ID) {
+		t.Errorf("PurgeTombstones() recent tombstone %s was removed from the tombstones index", recent.ID)
+	}
+}
+
+func TestP_processServerActivity_deleteAppliesTombstonePolicy(t *testing.T) {
+	p := mockProcessor(t, defaultActorID)
+	p.tombstonePolicy = HardDeleteImmediately
+
+	ob := &vocab.Object{ID: "https://remote.example.com/objects/1", Type: vocab.NoteType, AttributedTo: vocab.IRI("https://remote.example.com/~jdoe")}
+	if _, err := p.s.Save(ob); err != nil {
+		t.Fatalf("unable to save object: %s", err)
+	}
+	del := &vocab.Activity{
+		ID:     "https://remote.example.com/activities/1",
+		Type:   vocab.DeleteType,
+		Actor:  vocab.IRI("https://remote.example.com/~jdoe"),
+		Object: ob.ID,
+	}
+	if _, err := p.processServerActivity(del, vocab.Inbox.IRI(defaultActor)); err != nil {
+		t.Fatalf("processServerActivity() error = %s", err)
+	}
+	if it, err := p.s.Load(ob.ID); err == nil {
+		t.Errorf("processServerActivity() left %v in storage, expected it to be hard deleted", it)
+	}
+}
+
+func TestP_purgeTombstone_removesObjectData(t *testing.T) {
+	p := mockProcessor(t, defaultActorID)
+	p.blobs = NewFSBlobStore(t.TempDir(), defaultActorID+"/blobs")
+
+	blob, _, err := p.blobs.Put("image/png", []byte("\x89PNG\r\n\x1a\n"))
+	if err != nil {
+		t.Fatalf("unable to save blob: %s", err)
+	}
+
+	tombstone := &vocab.Tombstone{
+		ID:           defaultActorID + "/objects/1",
+		Type:         vocab.TombstoneType,
+		FormerType:   vocab.ImageType,
+		Deleted:      time.Now().UTC(),
+		AttributedTo: vocab.IRI(defaultActorID),
+	}
+	create := &vocab.Activity{ID: defaultActorID + "/activities/create", Type: vocab.CreateType, Actor: vocab.IRI(defaultActorID), Object: tombstone.ID}
+	like := &vocab.Activity{ID: defaultActorID + "/activities/like", Type: vocab.LikeType, Actor: vocab.IRI(defaultActorID), Object: tombstone.ID}
+	version := &vocab.Object{
+		ID:           objectVersionIRI(tombstone, vocab.IRI(defaultActorID+"/activities/update")),
+		Type:         vocab.ImageType,
+		AttributedTo: vocab.IRI(defaultActorID),
+		URL:          &vocab.Link{Type: vocab.LinkType, Href: blob},
+	}
+	for _, it := range []vocab.Item{tombstone, create, like, version} {
+		if _, err = p.s.Save(it); err != nil {
+			t.Fatalf("unable to save %s: %s", it.GetLink(), err)
+		}
+	}
+
+	collections := map[vocab.IRI]vocab.IRI{
+		vocab.Outbox.IRI(defaultActor):   create.ID,
+		vocab.Liked.IRI(defaultActor):    tombstone.ID,
+		vocab.Likes.IRI(tombstone):       like.ID,
+		HistoryCollection.IRI(tombstone): version.ID,
+	}
+	for colIRI, iri := range collections {
+		if _, err = p.s.Create(emptyCol(colIRI)); err != nil {
+			t.Fatalf("unable to create collection %s: %s", colIRI, err)
+		}
+		if err = p.s.AddTo(colIRI, iri); err != nil {
+			t.Fatalf("unable to add %s to %s: %s", iri, colIRI, err)
+		}
+	}
+
+	if err = p.purgeTombstone(tombstone); err != nil {
+		t.Fatalf("purgeTombstone() error = %s", err)
+	}
+
+	for _, iri := range []vocab.IRI{tombstone.ID, create.ID, version.ID, vocab.Likes.IRI(tombstone), HistoryCollection.IRI(tombstone)} {
+		if _, err = p.s.Load(iri); err == nil {
+			t.Errorf("purgeTombstone() left %s in storage", iri)
+		}
+	}
+	if testCollectionContains(p, vocab.Outbox.IRI(defaultActor), create.ID) {
+		t.Errorf("purgeTombstone() left the Create activity in the outbox")
+	}
+	if testCollectionContains(p, vocab.Liked.IRI(defaultActor), tombstone.ID) {
+		t.Errorf("purgeTombstone() left the object in the actor's liked collection")
+	}
+	if _, err = p.blobs.Size(blob); err == nil {
+		t.Errorf("purgeTombstone() left the blob %s of the object version", blob)
+	}
+}
+
+func TestP_PurgeTombstones_keptForever(t *testing.T) {
+	p := mockProcessor(t, defaultActorID)
+	p.tombstonePolicy = KeepTombstonesForever
+
+	if err := p.PurgeTombstones(time.Now().UTC()); err == nil {
+		t.Errorf("PurgeTombstones() expected an error when the tombstones are kept forever")
+	}
+}
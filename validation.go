@@ -87,6 +87,18 @@ var InvalidTarget = func(s string, p ...interface{}) error {
 var validActivityTypes = append(vocab.ActivityTypes, vocab.IntransitiveActivityTypes...)
 
 func (p P) ValidateServerActivity(a vocab.Item, author vocab.Actor, inbox vocab.IRI) error {
+	if err := p.validateInboundActivity(a, author, inbox); err != nil {
+		return err
+	}
+	return p.validateServerActivity(a, author, inbox)
+}
+
+// validateInboundActivity runs the checks for the "a" activity received from the "author" actor in the "inbox"
+// collection which don't need any storage lookups or remote fetches: the author, the rate limits and
+// the payload limits.
+//
+// It needs to run on the activity as it was received, before any of its properties get dereferenced.
+func (p P) validateInboundActivity(a vocab.Item, author vocab.Actor, inbox vocab.IRI) error {
 	if !IsInbox(inbox) {
 		return errors.BadRequestf("Trying to validate a non inbox IRI %s", inbox)
 	}
@@ -95,6 +107,12 @@ func (p P) ValidateServerActivity(a vocab.Item, author vocab.Actor, inbox vocab.
 		// NOTE(marius): Should we use 403 Forbidden here?
 		return errors.Unauthorizedf("%s actor is not allowed posting to current inbox: %s", name(&author), inbox)
 	}
+	if p.IsBlockedDomain(author.ID) {
+		return errors.Forbiddenf("federation with the domain of %s is not allowed", author.ID)
+	}
+	if err := p.validateActorStatus(&author); err != nil {
+		return err
+	}
 	if vocab.IsNil(a) {
 		return InvalidActivity("received nil")
 	}
@@ -104,6 +122,18 @@ func (p P) ValidateServerActivity(a vocab.Item, author vocab.Actor, inbox vocab.
 	if !validActivityTypes.Match(a.GetType()) {
 		return InvalidActivity("invalid type %v", a.GetType())
 	}
+	if err := p.validateRateLimit(author.ID, a.GetType()); err != nil {
+		return err
+	}
+	return p.validatePayload(a)
+}
+
+// validateServerActivity runs the checks for the "a" activity received from the "author" actor in the "inbox"
+// collection which depend on the state of the inbox owner and on the activity's properties.
+func (p P) validateServerActivity(a vocab.Item, author vocab.Actor, inbox vocab.IRI) error {
+	if vocab.IsNil(a) || vocab.IsIRI(a) {
+		return nil
+	}
 
 	var err error
 	if !p.skipValidationOnInboundCollections {
@@ -125,6 +155,12 @@ func (p P) ValidateServerActivity(a vocab.Item, author vocab.Actor, inbox vocab.
 		if len(act.ID) == 0 {
 			return InvalidActivity("empty activity id")
 		}
+		if p.IsBlockedDomain(act.ID) || p.hasBlockedDomain(act.Actor) {
+			return errors.Forbiddenf("federation with the domain of %s is not allowed", act.ID)
+		}
+		if err := p.validateActorStatus(act.Actor); err != nil {
+			return err
+		}
 		if inboxOwnerHasBlocked(act.Actor) {
 			return errors.NotFoundf("")
 		}
@@ -236,6 +272,9 @@ func (p P) ValidateClientActivity(a vocab.Item, author vocab.Actor, outbox vocab
 		// NOTE(marius): Should we use 403 Forbidden here?
 		return errors.Unauthorizedf("actor %q does not own the current outbox %s", name(&author), outbox)
 	}
+	if err := p.validateActorStatus(&author); err != nil {
+		return err
+	}
 	if vocab.IsNil(a) {
 		return InvalidActivity("is nil")
 	}
@@ -257,6 +296,9 @@ func (p P) ValidateClientActivity(a vocab.Item, author vocab.Actor, outbox vocab
 	if !validActivityTypes.Match(a.GetType()) {
 		return InvalidActivity("invalid type %v", a.GetType())
 	}
+	if err = p.validatePayload(a); err != nil {
+		return err
+	}
 
 	err = vocab.OnIntransitiveActivity(a, func(act *vocab.IntransitiveActivity) error {
 		if act.Actor, err = p.ValidateClientActor(act.Actor, author); err != nil {